@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+type (
+	TBanishment struct {
+		Reason       string
+		Issued       int
+		Until        int
+		Permanent    bool
+		FinalWarning bool
+	}
+)
+
+func InitCharacters() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: Former names are written by the query manager when a namelocked
+	// character gets a new name approved. The web server only reads them but
+	// makes sure the table exists so profile lookups don't fail.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS FormerNames (
+			CharacterID INTEGER NOT NULL,
+			Name TEXT NOT NULL COLLATE NOCASE,
+			Timestamp INTEGER NOT NULL,
+			PRIMARY KEY (CharacterID, Name)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create former names table: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func ExitCharacters() {
+	// no-op
+}
+
+func GetCharacterID(CharacterName string) int {
+	if g_NewsDb == nil {
+		return 0
+	}
+
+	var CharacterID int
+	Err := g_NewsDb.QueryRow(`
+		SELECT CharacterID FROM Characters WHERE Name = ?
+	`, CharacterName).Scan(&CharacterID)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query character id: %v", Err)
+		}
+		return 0
+	}
+	return CharacterID
+}
+
+func GetCharacterFormerNames(CharacterID int) []string {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT Name FROM FormerNames
+		WHERE CharacterID = ?
+		ORDER BY Timestamp DESC
+	`, CharacterID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query former names: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Names []string
+	for Rows.Next() {
+		var Name string
+		if Err := Rows.Scan(&Name); Err != nil {
+			g_LogErr.Printf("Failed to scan former name row: %v", Err)
+			continue
+		}
+		Names = append(Names, Name)
+	}
+	return Names
+}
+
+func IsCharacterNamelocked(CharacterID int) bool {
+	if g_NewsDb == nil {
+		return false
+	}
+
+	// NOTE: A namelock stays in the table after the new name is approved, so
+	// only pending ones are considered active.
+	var Count int
+	Err := g_NewsDb.QueryRow(`
+		SELECT COUNT(*) FROM Namelocks
+		WHERE CharacterID = ? AND Approved = 0
+	`, CharacterID).Scan(&Count)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query namelock: %v", Err)
+		return false
+	}
+	return Count > 0
+}
+
+func GetCharacterBanishment(CharacterID int) *TBanishment {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	// IMPORTANT: Only the public part of a banishment is selected here. The
+	// staff comment, the gamemaster and the IP address, as well as anything in
+	// `Notations`, must never reach the public profile.
+	var Banishment TBanishment
+	Now := time.Now().Unix()
+	Err := g_NewsDb.QueryRow(`
+		SELECT b.Reason, b.Issued, b.Until, b.FinalWarning
+		FROM Banishments b
+		JOIN Characters c ON c.AccountID = b.AccountID
+		WHERE c.CharacterID = ? AND (b.Until <= b.Issued OR b.Until > ?)
+		ORDER BY b.Issued DESC
+		LIMIT 1
+	`, CharacterID, Now).Scan(&Banishment.Reason, &Banishment.Issued,
+		&Banishment.Until, &Banishment.FinalWarning)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query banishment: %v", Err)
+		}
+		return nil
+	}
+
+	Banishment.Permanent = Banishment.Until <= Banishment.Issued
+	return &Banishment
+}
+
+func LoadCharacterPublicRecord(Character *TCharacterProfile) {
+	CharacterID := GetCharacterID(Character.Name)
+	if CharacterID <= 0 {
+		return
+	}
+
+	Character.FormerNames = GetCharacterFormerNames(CharacterID)
+	Character.Namelocked = IsCharacterNamelocked(CharacterID)
+	Character.Banishment = GetCharacterBanishment(CharacterID)
+	Character.Highscores = GetCharacterHighscores(Character.World, Character.Name)
+}
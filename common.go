@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"os"
 	"strconv"
@@ -379,22 +380,73 @@ func WorldTypeString(Type int) string {
 	}
 }
 
-func FormatTimestamp(Timestamp int) string {
+// VocationString maps a profession name, promoted or not, to the base vocation
+// used for filtering ("knight", "paladin", "sorcerer", "druid" or "none").
+func VocationString(Profession string) string {
+	Profession = strings.ToLower(Profession)
+	for _, Vocation := range []string{"knight", "paladin", "sorcerer", "druid"} {
+		if strings.Contains(Profession, Vocation) {
+			return Vocation
+		}
+	}
+	return "none"
+}
+
+func StartOfDay(Time time.Time) int {
+	Year, Month, Day := Time.Date()
+	return int(time.Date(Year, Month, Day, 0, 0, 0, 0, Time.Location()).Unix())
+}
+
+func FormatTimestamp(Timestamp int, Location *time.Location) string {
 	String := "Never"
 	if Timestamp > 0 {
-		Time := time.Unix(int64(Timestamp), 0)
+		if Location == nil {
+			Location = time.Local
+		}
+		Time := time.Unix(int64(Timestamp), 0).In(Location)
 		String = Time.Format("Jan 02 2006, 15:04:05 MST")
 	}
 	return String
 }
 
-func FormatDurationSince(Timestamp int) string {
-	String := "N/A"
-	if Timestamp > 0{
-		Duration := time.Since(time.Unix(int64(Timestamp), 0))
-		String = Duration.Truncate(time.Second).String()
+// FormatDurationSince spells out the time elapsed since the timestamp using its
+// two largest units (e.g. "2 days, 5 hours"), with unit names translated into
+// the given language.
+func FormatDurationSince(Timestamp int, Language string) string {
+	if Timestamp <= 0 {
+		return Translate(Language, "N/A")
 	}
-	return String
+
+	Seconds := int(time.Since(time.Unix(int64(Timestamp), 0)) / time.Second)
+	Units := []struct {
+		Seconds  int
+		Singular string
+		Plural   string
+	}{
+		{86400, "day", "days"},
+		{3600, "hour", "hours"},
+		{60, "minute", "minutes"},
+		{1, "second", "seconds"},
+	}
+
+	Parts := []string{}
+	for _, Unit := range Units {
+		Count := Seconds / Unit.Seconds
+		if Count == 0 && len(Parts) == 0 && Unit.Seconds > 1 {
+			continue
+		}
+
+		Name := Unit.Plural
+		if Count == 1 {
+			Name = Unit.Singular
+		}
+		Parts = append(Parts, fmt.Sprintf("%v %v", Count, Translate(Language, Name)))
+		Seconds -= Count * Unit.Seconds
+		if len(Parts) == 2 || Seconds == 0 {
+			break
+		}
+	}
+	return strings.Join(Parts, ", ")
 }
 
 func UTF8FindNextLeadingByte(Buffer []byte) int {
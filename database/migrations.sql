@@ -16,6 +16,340 @@ CREATE TABLE IF NOT EXISTS news (
 CREATE INDEX IF NOT EXISTS idx_news_created ON news(created_at);
 
 
+-- ============================================================================
+-- NUEVA TABLA: FORMERNAMES
+-- ============================================================================
+-- Nombres anteriores de cada personaje (se muestran en el perfil público).
+-- La web la crea automáticamente al arrancar; la escribe el query manager
+-- cuando se aprueba el nuevo nombre de un personaje con namelock.
+CREATE TABLE IF NOT EXISTS FormerNames (
+	CharacterID INTEGER NOT NULL,
+	Name TEXT NOT NULL COLLATE NOCASE,
+	Timestamp INTEGER NOT NULL,
+	PRIMARY KEY (CharacterID, Name)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: ACCOUNTPREFERENCES
+-- ============================================================================
+-- Preferencias de cada cuenta: personaje principal, zona horaria e idioma.
+-- La web la crea automáticamente al arrancar.
+CREATE TABLE IF NOT EXISTS AccountPreferences (
+	AccountID INTEGER NOT NULL,
+	MainCharacterID INTEGER NOT NULL DEFAULT 0,
+	TimeZone TEXT NOT NULL DEFAULT '',
+	Language TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (AccountID)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: WORLDPLAYERSAMPLES
+-- ============================================================================
+-- Historial de jugadores conectados por mundo. La web registra una muestra
+-- cada WorldSampleInterval y las antiguas se agrupan en una por hora.
+CREATE TABLE IF NOT EXISTS WorldPlayerSamples (
+	World TEXT NOT NULL COLLATE NOCASE,
+	Timestamp INTEGER NOT NULL,
+	NumPlayers INTEGER NOT NULL,
+	PRIMARY KEY (World, Timestamp)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: WORLDSTATUSEVENTS
+-- ============================================================================
+-- Arranques (Startup = 1) y apagados (Startup = 0) observados en cada mundo.
+-- Se usa para calcular el uptime y el historial de caídas.
+CREATE TABLE IF NOT EXISTS WorldStatusEvents (
+	World TEXT NOT NULL COLLATE NOCASE,
+	Timestamp INTEGER NOT NULL,
+	Startup INTEGER NOT NULL,
+	PRIMARY KEY (World, Timestamp, Startup)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HIGHSCORESNAPSHOTS
+-- ============================================================================
+-- Copia diaria de cada lista de highscores por mundo y habilidad. Se usa para
+-- mostrar la evolución de los rankings.
+CREATE TABLE IF NOT EXISTS HighscoreSnapshots (
+	World TEXT NOT NULL COLLATE NOCASE,
+	Skill TEXT NOT NULL,
+	Day INTEGER NOT NULL,
+	CharacterName TEXT NOT NULL COLLATE NOCASE,
+	Rank INTEGER NOT NULL,
+	Value INTEGER NOT NULL,
+	PRIMARY KEY (World, Skill, Day, CharacterName)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: KILLSTATISTICSSNAPSHOTS
+-- ============================================================================
+-- Copias periódicas de las estadísticas de muertes de cada mundo. Son totales
+-- acumulados, así que las vistas de último día y última semana se calculan
+-- como diferencias entre copias.
+CREATE TABLE IF NOT EXISTS KillStatisticsSnapshots (
+	World TEXT NOT NULL COLLATE NOCASE,
+	RaceName TEXT NOT NULL COLLATE NOCASE,
+	Timestamp INTEGER NOT NULL,
+	TimesKilled INTEGER NOT NULL,
+	PlayersKilled INTEGER NOT NULL,
+	PRIMARY KEY (World, RaceName, Timestamp)
+);
+
+CREATE INDEX IF NOT EXISTS KillStatisticsSnapshotsWorldIndex
+	ON KillStatisticsSnapshots (World, Timestamp);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HOUSEBIDS
+-- ============================================================================
+-- Historial de pujas en las subastas de casas. FinishTime es el fin de la
+-- subasta en la que se hizo la puja. La web la crea automáticamente al arrancar.
+CREATE TABLE IF NOT EXISTS HouseBids (
+	BidID INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorldID INTEGER NOT NULL,
+	HouseID INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	BidAmount INTEGER NOT NULL,
+	FinishTime INTEGER NOT NULL,
+	Timestamp INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS HouseBidsAuctionIndex ON HouseBids(WorldID, HouseID, FinishTime);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HOUSEAUCTIONSETTLEMENTS
+-- ============================================================================
+-- Registro de cada cierre de subasta: ganadores, pujadores sin fondos y
+-- subastas reiniciadas (CharacterID 0). Hay como mucho un paso por pujador,
+-- así un cierre interrumpido continúa donde lo dejó. La web la crea
+-- automáticamente.
+CREATE TABLE IF NOT EXISTS HouseAuctionSettlements (
+	SettlementID INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorldID INTEGER NOT NULL,
+	HouseID INTEGER NOT NULL,
+	FinishTime INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	BidAmount INTEGER NOT NULL,
+	Result TEXT NOT NULL,
+	Timestamp INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS HouseAuctionSettlementsIndex ON HouseAuctionSettlements(WorldID, HouseID, FinishTime, CharacterID);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HOUSETRANSFERREQUESTS
+-- ============================================================================
+-- Transferencias de casas pendientes de aceptar por el nuevo dueño. Al
+-- aceptarse pasan a HouseTransfers, donde las recoge el juego.
+CREATE TABLE IF NOT EXISTS HouseTransferRequests (
+	RequestID INTEGER PRIMARY KEY AUTOINCREMENT,
+	WorldID INTEGER NOT NULL,
+	HouseID INTEGER NOT NULL,
+	OwnerID INTEGER NOT NULL,
+	NewOwnerID INTEGER NOT NULL,
+	Price INTEGER NOT NULL,
+	Created INTEGER NOT NULL,
+	UNIQUE (WorldID, HouseID)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HOUSERENTREMINDERS
+-- ============================================================================
+-- Avisos de alquiler enviados por correo, uno por casa y periodo pagado.
+-- La web la crea automáticamente al arrancar.
+CREATE TABLE IF NOT EXISTS HouseRentReminders (
+	WorldID INTEGER NOT NULL,
+	HouseID INTEGER NOT NULL,
+	PaidUntil INTEGER NOT NULL,
+	OwnerID INTEGER NOT NULL,
+	Timestamp INTEGER NOT NULL,
+	PRIMARY KEY (WorldID, HouseID, PaidUntil)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: HOUSERENTREMINDERSETTINGS
+-- ============================================================================
+-- Cuentas que activaron o desactivaron los avisos de alquiler. Sin fila, los
+-- avisos están activados. La web la crea automáticamente al arrancar.
+CREATE TABLE IF NOT EXISTS HouseRentReminderSettings (
+	AccountID INTEGER NOT NULL,
+	Enabled INTEGER NOT NULL,
+	PRIMARY KEY (AccountID)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: GUILDHISTORY
+-- ============================================================================
+-- Historial de cada guild: miembros que se van, cambios de líder y
+-- disoluciones. Guarda el nombre para conservarlo tras disolver la guild.
+CREATE TABLE IF NOT EXISTS GuildHistory (
+	GuildID INTEGER NOT NULL,
+	GuildName TEXT NOT NULL,
+	Timestamp INTEGER NOT NULL,
+	Action TEXT NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	TargetID INTEGER NOT NULL,
+	Reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS GuildHistoryGuildIndex
+	ON GuildHistory (GuildID, Timestamp);
+
+
+-- ============================================================================
+-- NUEVA TABLA: GUILDDISBANDWARNINGS
+-- ============================================================================
+-- Guilds que no cumplen los requisitos (actividad o vicelíderes) y la fecha
+-- en que se disolverán si siguen sin cumplirlos.
+CREATE TABLE IF NOT EXISTS GuildDisbandWarnings (
+	GuildID INTEGER NOT NULL,
+	Reason TEXT NOT NULL,
+	Deadline INTEGER NOT NULL,
+	Warned INTEGER NOT NULL,
+	PRIMARY KEY (GuildID)
+);
+
+
+-- ============================================================================
+-- NUEVA TABLA: GUILDLOGOS
+-- ============================================================================
+-- Logos subidos por los líderes, ya validados y recodificados como PNG.
+CREATE TABLE IF NOT EXISTS GuildLogos (
+	GuildID INTEGER NOT NULL,
+	Data BLOB NOT NULL,
+	Updated INTEGER NOT NULL,
+	PRIMARY KEY (GuildID)
+);
+
+
+-- ============================================================================
+-- NUEVAS TABLAS: GUILDWARS, GUILDWARFRAGS
+-- ============================================================================
+-- Guerras entre guilds con sus condiciones (límite de frags, duración en
+-- segundos y apuesta). Los frags se toman de CharacterDeaths y se guardan en
+-- GuildWarFrags junto con la guild que los hizo, así siguen contando aunque
+-- el asesino o la víctima dejen su guild.
+CREATE TABLE IF NOT EXISTS GuildWars (
+	WarID INTEGER PRIMARY KEY AUTOINCREMENT,
+	GuildID INTEGER NOT NULL,
+	GuildName TEXT NOT NULL,
+	EnemyID INTEGER NOT NULL,
+	EnemyName TEXT NOT NULL,
+	Status TEXT NOT NULL,
+	FragLimit INTEGER NOT NULL,
+	Duration INTEGER NOT NULL,
+	Wager INTEGER NOT NULL,
+	Declared INTEGER NOT NULL,
+	Started INTEGER NOT NULL DEFAULT 0,
+	Ended INTEGER NOT NULL DEFAULT 0,
+	GuildFrags INTEGER NOT NULL DEFAULT 0,
+	EnemyFrags INTEGER NOT NULL DEFAULT 0,
+	WinnerID INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS GuildWarsStatusIndex
+	ON GuildWars (Status, Declared);
+
+CREATE TABLE IF NOT EXISTS GuildWarFrags (
+	WarID INTEGER NOT NULL,
+	GuildID INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	Timestamp INTEGER NOT NULL,
+	PRIMARY KEY (WarID, CharacterID, Timestamp)
+);
+
+
+-- ============================================================================
+-- NUEVAS TABLAS: GUILDBOARDTHREADS, GUILDBOARDPOSTS, GUILDBOARDREADS
+-- ============================================================================
+-- Foro privado de cada guild. Los hilos guardan el último mensaje para poder
+-- ordenarlos y marcar los no leídos; GuildBoardReads guarda el último mensaje
+-- visto por cada cuenta en cada hilo.
+CREATE TABLE IF NOT EXISTS GuildBoardThreads (
+	ThreadID INTEGER PRIMARY KEY AUTOINCREMENT,
+	GuildID INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	Title TEXT NOT NULL,
+	Created INTEGER NOT NULL,
+	LastPostID INTEGER NOT NULL DEFAULT 0,
+	LastPostTime INTEGER NOT NULL DEFAULT 0,
+	LastCharacterID INTEGER NOT NULL DEFAULT 0,
+	PostCount INTEGER NOT NULL DEFAULT 0,
+	Pinned INTEGER NOT NULL DEFAULT 0,
+	Locked INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS GuildBoardThreadsGuildIndex
+	ON GuildBoardThreads (GuildID, Pinned, LastPostTime);
+
+CREATE TABLE IF NOT EXISTS GuildBoardPosts (
+	PostID INTEGER PRIMARY KEY AUTOINCREMENT,
+	ThreadID INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	Text TEXT NOT NULL,
+	Created INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS GuildBoardPostsThreadIndex
+	ON GuildBoardPosts (ThreadID, PostID);
+
+CREATE TABLE IF NOT EXISTS GuildBoardReads (
+	AccountID INTEGER NOT NULL,
+	ThreadID INTEGER NOT NULL,
+	LastPostID INTEGER NOT NULL,
+	PRIMARY KEY (AccountID, ThreadID)
+);
+
+
+-- ============================================================================
+-- NUEVAS TABLAS: GUILDEVENTS, GUILDEVENTSIGNUPS
+-- ============================================================================
+-- Calendario de eventos de cada guild. Capacity es el número de personajes que
+-- pueden apuntarse como asistentes (0 = sin límite). Reminded marca las
+-- inscripciones cuyo recordatorio por e-mail ya se envió.
+CREATE TABLE IF NOT EXISTS GuildEvents (
+	EventID INTEGER PRIMARY KEY AUTOINCREMENT,
+	GuildID INTEGER NOT NULL,
+	Title TEXT NOT NULL,
+	Description TEXT NOT NULL,
+	StartTime INTEGER NOT NULL,
+	Capacity INTEGER NOT NULL,
+	Created INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS GuildEventsGuildIndex
+	ON GuildEvents (GuildID, StartTime);
+
+CREATE TABLE IF NOT EXISTS GuildEventSignups (
+	EventID INTEGER NOT NULL,
+	CharacterID INTEGER NOT NULL,
+	Status TEXT NOT NULL,
+	Updated INTEGER NOT NULL,
+	Reminded INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (EventID, CharacterID)
+);
+
+
+-- ============================================================================
+-- ÍNDICE: GUILDRANKS
+-- ============================================================================
+-- Los rangos personalizados se identifican por (GuildID, RankID). Los rangos
+-- 0, 1 y 2 son líder, vicelíder y miembro; el resto se crean desde la web.
+CREATE UNIQUE INDEX IF NOT EXISTS GuildRanksIndex
+	ON GuildRanks (GuildID, RankID);
+
+
 -- ============================================================================
 -- ACTUALIZAR TABLA EXISTENTE: GUILDS
 -- ============================================================================
@@ -35,7 +369,7 @@ CREATE INDEX IF NOT EXISTS idx_news_created ON news(created_at);
 -- ✓ Guilds           - Ya existe (necesita columna description - ver arriba)
 -- ✓ GuildMembers     - Ya existe
 -- ✓ GuildInvites     - Ya existe
--- ✓ GuildRanks       - Ya existe
+-- ✓ GuildRanks       - Ya existe (ver índice GuildRanksIndex arriba)
 -- ✓ Houses           - Ya existe con HouseID, Name, Rent, Town, etc.
 -- ✓ HouseOwners      - Ya existe con OwnerID, PaidUntil
 -- ✓ HouseAuctions    - Ya existe con BidderID, BidAmount, FinishTime
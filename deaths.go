@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type (
+	TDeathKiller struct {
+		Name        string
+		Player      bool
+		Unjustified bool
+	}
+
+	TDeath struct {
+		CharacterID   int
+		CharacterName string
+		Level         int
+		Timestamp     int
+		PvP           bool
+		Killers       []TDeathKiller
+	}
+
+	TAtomLink struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr,omitempty"`
+	}
+
+	TAtomEntry struct {
+		ID      string    `xml:"id"`
+		Title   string    `xml:"title"`
+		Updated string    `xml:"updated"`
+		Link    TAtomLink `xml:"link"`
+		Summary string    `xml:"summary"`
+	}
+
+	TAtomFeed struct {
+		XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+		ID      string       `xml:"id"`
+		Title   string       `xml:"title"`
+		Updated string       `xml:"updated"`
+		Links   []TAtomLink  `xml:"link"`
+		Entries []TAtomEntry `xml:"entry"`
+	}
+)
+
+// GetLatestDeaths returns the latest deaths on a world, newest first. Each row
+// in `CharacterDeaths` is one killer, so rows with the same character and
+// timestamp are grouped into a single death. A killer is a player when it has
+// an `OffenderID`, otherwise `Remark` holds the creature name.
+func GetLatestDeaths(World string, PvPOnly bool, MinLevel int, MaxDeaths int) []TDeath {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT d.CharacterID, c.Name, MAX(d.Level), d.Timestamp, MAX(d.OffenderID != 0) AS PvP
+		FROM CharacterDeaths d
+		JOIN Characters c ON c.CharacterID = d.CharacterID
+		JOIN Worlds w ON w.WorldID = c.WorldID
+		WHERE w.Name = ? AND d.Level >= ?
+		GROUP BY d.CharacterID, d.Timestamp
+		HAVING ? = 0 OR PvP != 0
+		ORDER BY d.Timestamp DESC
+		LIMIT ?
+	`, World, MinLevel, PvPOnly, MaxDeaths)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query latest deaths: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Deaths []TDeath
+	for Rows.Next() {
+		var Death TDeath
+		if Err := Rows.Scan(&Death.CharacterID, &Death.CharacterName,
+			&Death.Level, &Death.Timestamp, &Death.PvP); Err != nil {
+			g_LogErr.Printf("Failed to scan death: %v", Err)
+			continue
+		}
+		Deaths = append(Deaths, Death)
+	}
+	Rows.Close()
+
+	if len(Deaths) == 0 {
+		return Deaths
+	}
+
+	// NOTE: Killers for all selected deaths are loaded at once, matching each
+	// (CharacterID, Timestamp) pair so other deaths in between aren't read.
+	Pairs := make([]string, 0, len(Deaths))
+	Args := make([]any, 0, 2*len(Deaths))
+	for _, Death := range Deaths {
+		Pairs = append(Pairs, "(?, ?)")
+		Args = append(Args, Death.CharacterID, Death.Timestamp)
+	}
+
+	KillerRows, Err := g_NewsDb.Query(`
+		SELECT d.CharacterID, d.Timestamp, d.OffenderID, COALESCE(o.Name, ''), d.Remark, d.Unjustified
+		FROM CharacterDeaths d
+		LEFT JOIN Characters o ON o.CharacterID = d.OffenderID
+		WHERE (d.CharacterID, d.Timestamp) IN (VALUES `+strings.Join(Pairs, ", ")+`)
+		ORDER BY d.OffenderID = 0, d.rowid
+	`, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query death killers: %v", Err)
+		return Deaths
+	}
+	defer KillerRows.Close()
+
+	Index := make(map[[2]int]*TDeath, len(Deaths))
+	for I := range Deaths {
+		Index[[2]int{Deaths[I].CharacterID, Deaths[I].Timestamp}] = &Deaths[I]
+	}
+
+	for KillerRows.Next() {
+		var CharacterID, Timestamp, OffenderID int
+		var OffenderName, Remark string
+		var Unjustified bool
+		if Err := KillerRows.Scan(&CharacterID, &Timestamp, &OffenderID,
+			&OffenderName, &Remark, &Unjustified); Err != nil {
+			g_LogErr.Printf("Failed to scan death killer: %v", Err)
+			continue
+		}
+
+		Death := Index[[2]int{CharacterID, Timestamp}]
+		if Death == nil {
+			continue
+		}
+
+		Killer := TDeathKiller{Name: Remark, Unjustified: Unjustified}
+		if OffenderID != 0 {
+			Killer.Name = OffenderName
+			Killer.Player = true
+		}
+		Death.Killers = append(Death.Killers, Killer)
+	}
+
+	return Deaths
+}
+
+func DescribeDeathKillers(Killers []TDeathKiller) string {
+	Names := make([]string, 0, len(Killers))
+	for _, Killer := range Killers {
+		Names = append(Names, Killer.Name)
+	}
+
+	switch len(Names) {
+	case 0:
+		return "unknown causes"
+	case 1:
+		return Names[0]
+	default:
+		return strings.Join(Names[:len(Names)-1], ", ") + " and " + Names[len(Names)-1]
+	}
+}
+
+// BuildDeathsFeed renders deaths as an Atom feed. Links are absolute, built
+// with `WebsiteURL`, since feed readers fetch them out of context.
+func BuildDeathsFeed(World string, FeedPath string, Deaths []TDeath) ([]byte, error) {
+	Updated := time.Now()
+	if len(Deaths) > 0 {
+		Updated = time.Unix(int64(Deaths[0].Timestamp), 0)
+	}
+
+	DeathsURL := WebsiteURL("/world/deaths?name=" + url.QueryEscape(World))
+	Feed := TAtomFeed{
+		ID:      DeathsURL,
+		Title:   fmt.Sprintf("Latest Deaths - %v", World),
+		Updated: Updated.UTC().Format(time.RFC3339),
+		Links: []TAtomLink{
+			{Href: DeathsURL, Rel: "alternate"},
+			{Href: WebsiteURL(FeedPath), Rel: "self"},
+		},
+	}
+
+	for _, Death := range Deaths {
+		Feed.Entries = append(Feed.Entries, TAtomEntry{
+			ID:      fmt.Sprintf("%v#death-%v-%v", DeathsURL, Death.CharacterID, Death.Timestamp),
+			Title:   fmt.Sprintf("%v died at level %v", Death.CharacterName, Death.Level),
+			Updated: time.Unix(int64(Death.Timestamp), 0).UTC().Format(time.RFC3339),
+			Link:    TAtomLink{Href: WebsiteURL("/character?name=" + url.QueryEscape(Death.CharacterName))},
+			Summary: fmt.Sprintf("%v died at level %v by %v.", Death.CharacterName,
+				Death.Level, DescribeDeathKillers(Death.Killers)),
+		})
+	}
+
+	Data, Err := xml.MarshalIndent(Feed, "", "  ")
+	if Err != nil {
+		return nil, Err
+	}
+	return append([]byte(xml.Header), Data...), nil
+}
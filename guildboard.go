@@ -0,0 +1,512 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	GUILD_BOARD_OK         = 0
+	GUILD_BOARD_NOT_FOUND  = 1
+	GUILD_BOARD_LOCKED     = 2
+	GUILD_BOARD_BAD_TEXT   = 3
+	GUILD_BOARD_NOT_MEMBER = 4
+	GUILD_BOARD_ERROR      = 5
+)
+
+const (
+	GUILD_BOARD_TITLE_MAX        = 80
+	GUILD_BOARD_TEXT_MAX         = 4000
+	GUILD_BOARD_THREADS_PER_PAGE = 20
+	GUILD_BOARD_POSTS_PER_PAGE   = 20
+)
+
+type (
+	TGuildBoardThread struct {
+		ThreadID   int
+		GuildID    int
+		Title      string
+		AuthorName string
+		Created    int
+		LastPost   int
+		LastPoster string
+		Posts      int
+		Pinned     bool
+		Locked     bool
+		Unread     bool
+	}
+
+	TGuildBoardPost struct {
+		PostID        int
+		CharacterName string
+		Text          string
+		Created       int
+		Unread        bool
+	}
+)
+
+func InitGuildBoard() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: Threads keep the id and time of their last post so the list can
+	// be sorted and unread threads found without going through the posts.
+	// `GuildBoardReads` holds the last post each account has seen in each
+	// thread.
+	for _, Statement := range []string{`
+		CREATE TABLE IF NOT EXISTS GuildBoardThreads (
+			ThreadID INTEGER PRIMARY KEY AUTOINCREMENT,
+			GuildID INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			Title TEXT NOT NULL,
+			Created INTEGER NOT NULL,
+			LastPostID INTEGER NOT NULL DEFAULT 0,
+			LastPostTime INTEGER NOT NULL DEFAULT 0,
+			LastCharacterID INTEGER NOT NULL DEFAULT 0,
+			PostCount INTEGER NOT NULL DEFAULT 0,
+			Pinned INTEGER NOT NULL DEFAULT 0,
+			Locked INTEGER NOT NULL DEFAULT 0
+		)`, `
+		CREATE INDEX IF NOT EXISTS GuildBoardThreadsGuildIndex
+			ON GuildBoardThreads (GuildID, Pinned, LastPostTime)`, `
+		CREATE TABLE IF NOT EXISTS GuildBoardPosts (
+			PostID INTEGER PRIMARY KEY AUTOINCREMENT,
+			ThreadID INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			Text TEXT NOT NULL,
+			Created INTEGER NOT NULL
+		)`, `
+		CREATE INDEX IF NOT EXISTS GuildBoardPostsThreadIndex
+			ON GuildBoardPosts (ThreadID, PostID)`, `
+		CREATE TABLE IF NOT EXISTS GuildBoardReads (
+			AccountID INTEGER NOT NULL,
+			ThreadID INTEGER NOT NULL,
+			LastPostID INTEGER NOT NULL,
+			PRIMARY KEY (AccountID, ThreadID)
+		)`,
+	} {
+		if _, Err := g_NewsDb.Exec(Statement); Err != nil {
+			g_LogErr.Printf("Failed to create guild board tables: %v", Err)
+			return false
+		}
+	}
+
+	return true
+}
+
+func ExitGuildBoard() {
+	// no-op
+}
+
+// SanitizeGuildBoardText turns user input into plain text that is safe to
+// store and show. Invalid UTF-8, control characters and invisible formatting
+// characters (e.g. bidirectional overrides) are dropped, and runs of blank
+// lines are collapsed. Titles are kept on a single line. Escaping is left to
+// the templates.
+func SanitizeGuildBoardText(Text string, MaxLength int, Multiline bool) string {
+	Text = strings.ToValidUTF8(Text, "")
+	Text = strings.ReplaceAll(Text, "\r\n", "\n")
+
+	var Builder strings.Builder
+	Newlines := 0
+	for _, Char := range Text {
+		if Char == '\n' || Char == '\r' {
+			if !Multiline {
+				Char = ' '
+			} else {
+				Newlines += 1
+				if Newlines <= 2 {
+					Builder.WriteRune('\n')
+				}
+				continue
+			}
+		} else if Char == '\t' {
+			Char = ' '
+		} else if unicode.IsControl(Char) || unicode.Is(unicode.Cf, Char) {
+			continue
+		}
+
+		Newlines = 0
+		Builder.WriteRune(Char)
+	}
+
+	Result := strings.TrimSpace(Builder.String())
+	if Runes := []rune(Result); len(Runes) > MaxLength {
+		Result = strings.TrimSpace(string(Runes[:MaxLength]))
+	}
+	return Result
+}
+
+// GetGuildBoardCharacters returns the characters of an account that can post
+// on the board of a guild, which includes the leader even if it doesn't have
+// a member row.
+func GetGuildBoardCharacters(AccountID int, GuildID int) []TAccountCharacter {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT c.CharacterID, c.Name, c.Level
+		FROM Characters c
+		WHERE c.AccountID = ? AND c.CharacterID IN (
+			SELECT CharacterID FROM GuildMembers WHERE GuildID = ?
+			UNION SELECT LeaderID FROM Guilds WHERE GuildID = ?)
+		ORDER BY c.Name ASC
+	`, AccountID, GuildID, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild board characters: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Characters []TAccountCharacter
+	for Rows.Next() {
+		var Character TAccountCharacter
+		if Err := Rows.Scan(&Character.CharacterID, &Character.Name, &Character.Level); Err != nil {
+			g_LogErr.Printf("Failed to scan guild board character: %v", Err)
+			continue
+		}
+		Characters = append(Characters, Character)
+	}
+	return Characters
+}
+
+func IsGuildBoardCharacter(AccountID int, GuildID int, CharacterID int) bool {
+	for _, Character := range GetGuildBoardCharacters(AccountID, GuildID) {
+		if Character.CharacterID == CharacterID {
+			return true
+		}
+	}
+	return false
+}
+
+func GetGuildBoardPageCount(Count int, PerPage int) int {
+	return max(1, (Count+PerPage-1)/PerPage)
+}
+
+// GetGuildBoardThreads returns a page of the threads of a guild with pinned
+// threads first and the rest by latest post, along with the number of pages.
+func GetGuildBoardThreads(GuildID int, AccountID int, Page int) ([]TGuildBoardThread, int) {
+	if g_NewsDb == nil {
+		return nil, 1
+	}
+
+	var Count int
+	Err := g_NewsDb.QueryRow(`
+		SELECT COUNT(*) FROM GuildBoardThreads WHERE GuildID = ?
+	`, GuildID).Scan(&Count)
+	if Err != nil {
+		g_LogErr.Printf("Failed to count guild board threads: %v", Err)
+		return nil, 1
+	}
+
+	Pages := GetGuildBoardPageCount(Count, GUILD_BOARD_THREADS_PER_PAGE)
+	Page = min(max(Page, 1), Pages)
+	Rows, Err := g_NewsDb.Query(`
+		SELECT t.ThreadID, t.GuildID, t.Title, COALESCE(a.Name, ''), t.Created,
+			t.LastPostTime, COALESCE(l.Name, ''), t.PostCount, t.Pinned, t.Locked,
+			t.LastPostID > COALESCE(r.LastPostID, 0)
+		FROM GuildBoardThreads t
+		LEFT JOIN Characters a ON a.CharacterID = t.CharacterID
+		LEFT JOIN Characters l ON l.CharacterID = t.LastCharacterID
+		LEFT JOIN GuildBoardReads r ON r.ThreadID = t.ThreadID AND r.AccountID = ?
+		WHERE t.GuildID = ?
+		ORDER BY t.Pinned DESC, t.LastPostTime DESC, t.ThreadID DESC
+		LIMIT ? OFFSET ?
+	`, AccountID, GuildID, GUILD_BOARD_THREADS_PER_PAGE, (Page-1)*GUILD_BOARD_THREADS_PER_PAGE)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild board threads: %v", Err)
+		return nil, Pages
+	}
+	defer Rows.Close()
+
+	var Threads []TGuildBoardThread
+	for Rows.Next() {
+		var Thread TGuildBoardThread
+		if Err := ScanGuildBoardThread(Rows, &Thread); Err != nil {
+			g_LogErr.Printf("Failed to scan guild board thread: %v", Err)
+			continue
+		}
+		Threads = append(Threads, Thread)
+	}
+	return Threads, Pages
+}
+
+func ScanGuildBoardThread(Row interface{ Scan(...any) error }, Thread *TGuildBoardThread) error {
+	return Row.Scan(&Thread.ThreadID, &Thread.GuildID, &Thread.Title, &Thread.AuthorName,
+		&Thread.Created, &Thread.LastPost, &Thread.LastPoster, &Thread.Posts,
+		&Thread.Pinned, &Thread.Locked, &Thread.Unread)
+}
+
+func GetGuildBoardThread(ThreadID int, AccountID int) *TGuildBoardThread {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	var Thread TGuildBoardThread
+	Err := ScanGuildBoardThread(g_NewsDb.QueryRow(`
+		SELECT t.ThreadID, t.GuildID, t.Title, COALESCE(a.Name, ''), t.Created,
+			t.LastPostTime, COALESCE(l.Name, ''), t.PostCount, t.Pinned, t.Locked,
+			t.LastPostID > COALESCE(r.LastPostID, 0)
+		FROM GuildBoardThreads t
+		LEFT JOIN Characters a ON a.CharacterID = t.CharacterID
+		LEFT JOIN Characters l ON l.CharacterID = t.LastCharacterID
+		LEFT JOIN GuildBoardReads r ON r.ThreadID = t.ThreadID AND r.AccountID = ?
+		WHERE t.ThreadID = ?
+	`, AccountID, ThreadID), &Thread)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query guild board thread: %v", Err)
+		}
+		return nil
+	}
+	return &Thread
+}
+
+// CountGuildBoardUnread returns how many threads of a guild have posts the
+// account hasn't seen.
+func CountGuildBoardUnread(GuildID int, AccountID int) int {
+	if g_NewsDb == nil {
+		return 0
+	}
+
+	var Count int
+	Err := g_NewsDb.QueryRow(`
+		SELECT COUNT(*) FROM GuildBoardThreads t
+		LEFT JOIN GuildBoardReads r ON r.ThreadID = t.ThreadID AND r.AccountID = ?
+		WHERE t.GuildID = ? AND t.LastPostID > COALESCE(r.LastPostID, 0)
+	`, AccountID, GuildID).Scan(&Count)
+	if Err != nil {
+		g_LogErr.Printf("Failed to count unread guild board threads: %v", Err)
+	}
+	return Count
+}
+
+// GetGuildBoardPosts returns a page of the posts of a thread, oldest first,
+// along with the number of pages. Posts the account hasn't seen are marked,
+// and the page is then recorded as read.
+func GetGuildBoardPosts(ThreadID int, AccountID int, Page int) ([]TGuildBoardPost, int) {
+	if g_NewsDb == nil {
+		return nil, 1
+	}
+
+	var Count, LastRead int
+	Err := g_NewsDb.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM GuildBoardPosts WHERE ThreadID = ?),
+			COALESCE((SELECT LastPostID FROM GuildBoardReads
+				WHERE AccountID = ? AND ThreadID = ?), 0)
+	`, ThreadID, AccountID, ThreadID).Scan(&Count, &LastRead)
+	if Err != nil {
+		g_LogErr.Printf("Failed to count guild board posts: %v", Err)
+		return nil, 1
+	}
+
+	Pages := GetGuildBoardPageCount(Count, GUILD_BOARD_POSTS_PER_PAGE)
+	Page = min(max(Page, 1), Pages)
+	Rows, Err := g_NewsDb.Query(`
+		SELECT p.PostID, COALESCE(c.Name, ''), p.Text, p.Created
+		FROM GuildBoardPosts p
+		LEFT JOIN Characters c ON c.CharacterID = p.CharacterID
+		WHERE p.ThreadID = ?
+		ORDER BY p.PostID ASC
+		LIMIT ? OFFSET ?
+	`, ThreadID, GUILD_BOARD_POSTS_PER_PAGE, (Page-1)*GUILD_BOARD_POSTS_PER_PAGE)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild board posts: %v", Err)
+		return nil, Pages
+	}
+	defer Rows.Close()
+
+	var Posts []TGuildBoardPost
+	for Rows.Next() {
+		var Post TGuildBoardPost
+		if Err := Rows.Scan(&Post.PostID, &Post.CharacterName, &Post.Text, &Post.Created); Err != nil {
+			g_LogErr.Printf("Failed to scan guild board post: %v", Err)
+			continue
+		}
+		Post.Unread = Post.PostID > LastRead
+		Posts = append(Posts, Post)
+	}
+	Rows.Close()
+
+	if len(Posts) > 0 {
+		MarkGuildBoardRead(AccountID, ThreadID, Posts[len(Posts)-1].PostID)
+	}
+	return Posts, Pages
+}
+
+// MarkGuildBoardRead records that the account has seen a thread up to a post.
+// Going back to an earlier page doesn't make later posts unread again.
+func MarkGuildBoardRead(AccountID int, ThreadID int, PostID int) {
+	_, Err := g_NewsDb.Exec(`
+		INSERT INTO GuildBoardReads (AccountID, ThreadID, LastPostID)
+		VALUES (?, ?, ?)
+		ON CONFLICT (AccountID, ThreadID) DO UPDATE
+			SET LastPostID = MAX(LastPostID, excluded.LastPostID)
+	`, AccountID, ThreadID, PostID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to mark guild board thread as read: %v", Err)
+	}
+}
+
+// InsertGuildBoardPost adds a post to a thread and updates the thread.
+func InsertGuildBoardPost(Tx *sql.Tx, ThreadID int, CharacterID int, Text string, Now int64) error {
+	Result, Err := Tx.Exec(`
+		INSERT INTO GuildBoardPosts (ThreadID, CharacterID, Text, Created)
+		VALUES (?, ?, ?, ?)
+	`, ThreadID, CharacterID, Text, Now)
+	if Err != nil {
+		return Err
+	}
+
+	PostID, Err := Result.LastInsertId()
+	if Err != nil {
+		return Err
+	}
+
+	_, Err = Tx.Exec(`
+		UPDATE GuildBoardThreads
+		SET LastPostID = ?, LastPostTime = ?, LastCharacterID = ?, PostCount = PostCount + 1
+		WHERE ThreadID = ?
+	`, PostID, Now, CharacterID, ThreadID)
+	return Err
+}
+
+// CreateGuildBoardThread starts a thread with its first post and returns the
+// id of the new thread.
+func CreateGuildBoardThread(GuildID int, CharacterID int, Title string, Text string) (int, int) {
+	if g_NewsDb == nil {
+		return 0, GUILD_BOARD_ERROR
+	}
+
+	Title = SanitizeGuildBoardText(Title, GUILD_BOARD_TITLE_MAX, false)
+	Text = SanitizeGuildBoardText(Text, GUILD_BOARD_TEXT_MAX, true)
+	if Title == "" || Text == "" {
+		return 0, GUILD_BOARD_BAD_TEXT
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return 0, GUILD_BOARD_ERROR
+	}
+	defer Tx.Rollback()
+
+	Now := time.Now().Unix()
+	Result, Err := Tx.Exec(`
+		INSERT INTO GuildBoardThreads (GuildID, CharacterID, Title, Created)
+		VALUES (?, ?, ?, ?)
+	`, GuildID, CharacterID, Title, Now)
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild board thread: %v", Err)
+		return 0, GUILD_BOARD_ERROR
+	}
+
+	ThreadID, Err := Result.LastInsertId()
+	if Err == nil {
+		Err = InsertGuildBoardPost(Tx, int(ThreadID), CharacterID, Text, Now)
+	}
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild board post: %v", Err)
+		return 0, GUILD_BOARD_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild board thread: %v", Err)
+		return 0, GUILD_BOARD_ERROR
+	}
+	return int(ThreadID), GUILD_BOARD_OK
+}
+
+// ReplyGuildBoardThread adds a post to a thread that isn't locked.
+func ReplyGuildBoardThread(ThreadID int, CharacterID int, Text string) int {
+	if g_NewsDb == nil {
+		return GUILD_BOARD_ERROR
+	}
+
+	Text = SanitizeGuildBoardText(Text, GUILD_BOARD_TEXT_MAX, true)
+	if Text == "" {
+		return GUILD_BOARD_BAD_TEXT
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_BOARD_ERROR
+	}
+	defer Tx.Rollback()
+
+	var Locked bool
+	Err = Tx.QueryRow(`
+		SELECT Locked FROM GuildBoardThreads WHERE ThreadID = ?
+	`, ThreadID).Scan(&Locked)
+	if Err == sql.ErrNoRows {
+		return GUILD_BOARD_NOT_FOUND
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query guild board thread: %v", Err)
+		return GUILD_BOARD_ERROR
+	} else if Locked {
+		return GUILD_BOARD_LOCKED
+	}
+
+	if Err := InsertGuildBoardPost(Tx, ThreadID, CharacterID, Text, time.Now().Unix()); Err != nil {
+		g_LogErr.Printf("Failed to insert guild board post: %v", Err)
+		return GUILD_BOARD_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild board post: %v", Err)
+		return GUILD_BOARD_ERROR
+	}
+	return GUILD_BOARD_OK
+}
+
+// SetGuildBoardThreadPinned and SetGuildBoardThreadLocked are used by the
+// leaders to keep threads on top and to close them to replies.
+func SetGuildBoardThreadPinned(ThreadID int, Pinned bool) int {
+	return UpdateGuildBoardThread(`UPDATE GuildBoardThreads SET Pinned = ? WHERE ThreadID = ?`,
+		Pinned, ThreadID)
+}
+
+func SetGuildBoardThreadLocked(ThreadID int, Locked bool) int {
+	return UpdateGuildBoardThread(`UPDATE GuildBoardThreads SET Locked = ? WHERE ThreadID = ?`,
+		Locked, ThreadID)
+}
+
+func UpdateGuildBoardThread(Query string, Args ...any) int {
+	if g_NewsDb == nil {
+		return GUILD_BOARD_ERROR
+	}
+
+	Result, Err := g_NewsDb.Exec(Query, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update guild board thread: %v", Err)
+		return GUILD_BOARD_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return GUILD_BOARD_NOT_FOUND
+	}
+	return GUILD_BOARD_OK
+}
+
+// DeleteGuildBoard removes the threads, posts and read markers of a guild
+// when it's disbanded.
+func DeleteGuildBoard(Tx *sql.Tx, GuildID int) error {
+	for _, Table := range []string{"GuildBoardReads", "GuildBoardPosts"} {
+		_, Err := Tx.Exec(`
+			DELETE FROM `+Table+` WHERE ThreadID IN
+				(SELECT ThreadID FROM GuildBoardThreads WHERE GuildID = ?)
+		`, GuildID)
+		if Err != nil {
+			return Err
+		}
+	}
+
+	_, Err := Tx.Exec(`DELETE FROM GuildBoardThreads WHERE GuildID = ?`, GuildID)
+	return Err
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"time"
+)
+
+const (
+	GUILD_DISBAND_INACTIVE     = "inactive"
+	GUILD_DISBAND_VICE_LEADERS = "vice-leaders"
+)
+
+func InitGuildCleanup() bool {
+	g_Log.Printf("GuildCleanupInterval: %v", g_GuildCleanupInterval)
+	g_Log.Printf("GuildInactivityPeriod: %v", g_GuildInactivityPeriod)
+	g_Log.Printf("GuildMinViceLeaders: %v", g_GuildMinViceLeaders)
+	g_Log.Printf("GuildViceLeaderGracePeriod: %v", g_GuildViceLeaderGracePeriod)
+	g_Log.Printf("GuildDisbandWarningPeriod: %v", g_GuildDisbandWarningPeriod)
+	g_Log.Printf("GuildInviteExpiry: %v", g_GuildInviteExpiry)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: A guild gets a row here once it stops meeting the requirements,
+	// and it's disbanded if it still doesn't meet them by `Deadline`. `Warned`
+	// is set once the leader got the e-mail, so failed ones are tried again.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildDisbandWarnings (
+			GuildID INTEGER NOT NULL,
+			Reason TEXT NOT NULL,
+			Deadline INTEGER NOT NULL,
+			Warned INTEGER NOT NULL,
+			PRIMARY KEY (GuildID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild disband warnings table: %v", Err)
+		return false
+	}
+
+	StartJob("guild cleanup", g_GuildCleanupInterval, CheckGuildRequirements)
+	if g_GuildInviteExpiry > 0 {
+		StartJob("guild invite cleanup", g_GuildCleanupInterval, DeleteExpiredGuildInvites)
+	}
+	return true
+}
+
+func ExitGuildCleanup() {
+	// no-op
+}
+
+// GetGuildCharacterNames returns the names of the members of a guild, which
+// includes the leader even if it doesn't have a member row.
+func GetGuildCharacterNames(GuildID int) ([]string, bool) {
+	if g_NewsDb == nil {
+		return nil, false
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT c.Name FROM Characters c
+		WHERE c.CharacterID IN (
+			SELECT CharacterID FROM GuildMembers WHERE GuildID = ?
+			UNION SELECT LeaderID FROM Guilds WHERE GuildID = ?)
+		ORDER BY c.Name ASC
+	`, GuildID, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild characters: %v", Err)
+		return nil, false
+	}
+	defer Rows.Close()
+
+	var Names []string
+	for Rows.Next() {
+		var Name string
+		if Err := Rows.Scan(&Name); Err != nil {
+			g_LogErr.Printf("Failed to scan guild character: %v", Err)
+			continue
+		}
+		Names = append(Names, Name)
+	}
+	return Names, true
+}
+
+// GetGuildLastLogin returns the most recent login of any guild member, as
+// reported by the game server. Online members count as logged in now. It fails
+// if a member can't be looked up, so an unreachable query manager doesn't make
+// every guild look inactive.
+func GetGuildLastLogin(GuildID int) (int, bool) {
+	Names, Ok := GetGuildCharacterNames(GuildID)
+	if !Ok {
+		return 0, false
+	}
+
+	LastLogin := 0
+	for _, Name := range Names {
+		Result, Character := GetCharacterProfile(Name)
+		switch Result {
+		case 0:
+			if Character.Online {
+				return int(time.Now().Unix()), true
+			}
+			LastLogin = max(LastLogin, Character.LastLogin)
+		case 1:
+			// NOTE: Deleted characters don't count as activity.
+		default:
+			return 0, false
+		}
+	}
+	return LastLogin, true
+}
+
+// GetGuildDisbandReason returns why a guild doesn't meet the requirements to
+// exist, or an empty string if it does. Guilds where no member logged in since
+// `LastLogin` are inactive, counting from when they were founded if that's
+// more recent, and guilds past their grace period need enough vice leaders.
+func GetGuildDisbandReason(Guild *TGuild, LastLogin int, Now time.Time) string {
+	LastActivity := max(LastLogin, Guild.Created)
+	if Now.Sub(time.Unix(int64(LastActivity), 0)) > g_GuildInactivityPeriod {
+		return GUILD_DISBAND_INACTIVE
+	}
+
+	if g_GuildMinViceLeaders > 0 && Guild.ViceLeaderCount < g_GuildMinViceLeaders &&
+		Now.Sub(time.Unix(int64(Guild.Created), 0)) > g_GuildViceLeaderGracePeriod {
+		return GUILD_DISBAND_VICE_LEADERS
+	}
+
+	return ""
+}
+
+func DescribeGuildDisbandReason(Reason string) string {
+	switch Reason {
+	case GUILD_DISBAND_INACTIVE:
+		return fmt.Sprintf("No member logged in for more than %v days", int(g_GuildInactivityPeriod.Hours()/24))
+	case GUILD_DISBAND_VICE_LEADERS:
+		return fmt.Sprintf("Fewer than %v vice-leaders", g_GuildMinViceLeaders)
+	default:
+		return Reason
+	}
+}
+
+// GetGuildDisbandWarning returns why and when a guild that was warned will be
+// disbanded, or an empty reason if it wasn't warned.
+func GetGuildDisbandWarning(GuildID int) (string, int) {
+	if g_NewsDb == nil {
+		return "", 0
+	}
+
+	var Reason string
+	var Deadline int
+	Err := g_NewsDb.QueryRow(`
+		SELECT Reason, Deadline FROM GuildDisbandWarnings WHERE GuildID = ?
+	`, GuildID).Scan(&Reason, &Deadline)
+	if Err != nil && Err != sql.ErrNoRows {
+		g_LogErr.Printf("Failed to query guild disband warning: %v", Err)
+	}
+	return Reason, Deadline
+}
+
+// SetGuildDisbandStatus fills in whether a guild is about to be disbanded and
+// when. Inactivity needs the members' logins from the game server, so it only
+// shows up once the cleanup job warned the guild. Guilds that lack vice
+// leaders but weren't warned yet get the deadline they would have if they
+// were warned now.
+func SetGuildDisbandStatus(Guild *TGuild) {
+	Now := time.Now()
+	Reason, Deadline := GetGuildDisbandWarning(Guild.GuildID)
+	if Reason == "" {
+		Reason = GetGuildDisbandReason(Guild, int(Now.Unix()), Now)
+		Deadline = int(Now.Add(g_GuildDisbandWarningPeriod).Unix())
+	}
+
+	Guild.IsInactive = Reason == GUILD_DISBAND_INACTIVE
+	Guild.LacksViceLeaders = Reason == GUILD_DISBAND_VICE_LEADERS
+	Guild.RequiredViceLeaders = g_GuildMinViceLeaders
+	Guild.DisbandDate = 0
+	if Reason != "" {
+		Guild.DisbandDate = Deadline
+	}
+}
+
+func NotifyGuildDisbandWarning(Guild *TGuild, Reason string, Deadline int) error {
+	Name, Email := GetCharacterAccountEmail(GetGuildLeaderCharID(Guild.GuildID))
+	if Email == "" {
+		return nil
+	}
+
+	Subject := fmt.Sprintf("%v will be disbanded", Guild.Name)
+	Body := fmt.Sprintf("<p>%v, the guild %v you lead no longer meets the requirements"+
+		" to exist: %v.</p><p>It will be disbanded on %v unless this is fixed.</p>",
+		html.EscapeString(Name), html.EscapeString(Guild.Name),
+		html.EscapeString(DescribeGuildDisbandReason(Reason)),
+		time.Unix(int64(Deadline), 0).UTC().Format("Jan 02 2006, 15:04:05 MST"))
+	return SendMail(Email, Subject, Body)
+}
+
+// CheckGuildRequirements warns the leaders of guilds that stopped meeting the
+// requirements and disbands the ones that still don't meet them past their
+// deadline. Guilds that meet them again lose their warning.
+func CheckGuildRequirements() {
+	Now := time.Now()
+	for _, Guild := range GetGuilds() {
+		LastLogin, Ok := GetGuildLastLogin(Guild.GuildID)
+		if !Ok {
+			g_LogWarn.Printf("Skipping guild %v: failed to look up member logins", Guild.GuildID)
+			continue
+		}
+
+		Reason := GetGuildDisbandReason(&Guild, LastLogin, Now)
+		if Reason == "" {
+			_, Err := g_NewsDb.Exec(`
+				DELETE FROM GuildDisbandWarnings WHERE GuildID = ?
+			`, Guild.GuildID)
+			if Err != nil {
+				g_LogErr.Printf("Failed to delete guild disband warning: %v", Err)
+			}
+			continue
+		}
+
+		// NOTE: The deadline is kept if the reason changes, so a guild can't
+		// push it back by fixing one requirement and breaking another.
+		_, Err := g_NewsDb.Exec(`
+			INSERT INTO GuildDisbandWarnings (GuildID, Reason, Deadline, Warned)
+			VALUES (?, ?, ?, 0)
+			ON CONFLICT (GuildID) DO UPDATE SET Reason = excluded.Reason
+		`, Guild.GuildID, Reason, Now.Add(g_GuildDisbandWarningPeriod).Unix())
+		if Err != nil {
+			g_LogErr.Printf("Failed to insert guild disband warning: %v", Err)
+			continue
+		}
+
+		var Deadline int
+		var Warned bool
+		Err = g_NewsDb.QueryRow(`
+			SELECT Deadline, Warned FROM GuildDisbandWarnings WHERE GuildID = ?
+		`, Guild.GuildID).Scan(&Deadline, &Warned)
+		if Err != nil {
+			g_LogErr.Printf("Failed to query guild disband warning: %v", Err)
+			continue
+		}
+
+		if Now.Unix() >= int64(Deadline) {
+			Result := DisbandGuild(Guild.GuildID, 0, DescribeGuildDisbandReason(Reason))
+			if Result == GUILD_ACTION_OK {
+				g_Log.Printf("Guild %v disbanded: %v", Guild.Name, DescribeGuildDisbandReason(Reason))
+			}
+			continue
+		}
+
+		if !Warned {
+			if Err := NotifyGuildDisbandWarning(&Guild, Reason, Deadline); Err != nil {
+				g_LogErr.Printf("Failed to send guild disband warning e-mail for guild %v: %v", Guild.GuildID, Err)
+				continue
+			}
+
+			_, Err = g_NewsDb.Exec(`
+				UPDATE GuildDisbandWarnings SET Warned = 1 WHERE GuildID = ?
+			`, Guild.GuildID)
+			if Err != nil {
+				g_LogErr.Printf("Failed to update guild disband warning: %v", Err)
+			}
+		}
+	}
+}
+
+// DeleteExpiredGuildInvites removes invitations older than the expiry. They're
+// already hidden before that, this only keeps the table from growing.
+func DeleteExpiredGuildInvites() {
+	Result, Err := g_NewsDb.Exec(`
+		DELETE FROM GuildInvites WHERE Timestamp < ?
+	`, GetGuildInviteCutoff())
+	if Err != nil {
+		g_LogErr.Printf("Failed to delete expired guild invites: %v", Err)
+		return
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected > 0 {
+		g_Log.Printf("Deleted %v expired guild invites", Affected)
+	}
+}
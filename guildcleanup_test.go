@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestGuildLastLoginLeaderOnly(t *testing.T) {
+	Db, Err := sql.Open("sqlite3", ":memory:")
+	if Err != nil {
+		t.Fatalf("Failed to open database: %v", Err)
+	}
+	defer Db.Close()
+
+	// NOTE: Each connection to `:memory:` gets its own database.
+	Db.SetMaxOpenConns(1)
+	_, Err = Db.Exec(`
+		CREATE TABLE Characters (CharacterID INTEGER PRIMARY KEY, Name TEXT);
+		CREATE TABLE Guilds (GuildID INTEGER PRIMARY KEY, Name TEXT, LeaderID INTEGER, Created INTEGER);
+		CREATE TABLE GuildMembers (GuildID INTEGER, CharacterID INTEGER, Rank INTEGER, Joined INTEGER);
+		INSERT INTO Characters VALUES (1, 'Leader'), (2, 'Member');
+		INSERT INTO Guilds VALUES (1, 'Founded Online', 1, 0), (2, 'Other', 2, 0);
+		INSERT INTO GuildMembers VALUES (2, 2, 0, 0);
+	`)
+	if Err != nil {
+		t.Fatalf("Failed to create tables: %v", Err)
+	}
+
+	OldDb, OldCache := g_NewsDb, g_CharacterCache
+	defer func() { g_NewsDb, g_CharacterCache = OldDb, OldCache }()
+	g_NewsDb = Db
+
+	Names, Ok := GetGuildCharacterNames(1)
+	if !Ok || !slices.Equal(Names, []string{"Leader"}) {
+		t.Fatalf("Guild 1 has characters %v, expected the leader", Names)
+	}
+
+	// NOTE: The leader's profile is served from the cache so the query
+	// manager isn't needed.
+	Now := time.Now()
+	Yesterday := int(Now.Add(-24 * time.Hour).Unix())
+	g_CharacterCache = []TCharacterCacheEntry{{
+		CharacterName: "Leader",
+		Data:          TCharacterProfile{Name: "Leader", LastLogin: Yesterday},
+		LastAccess:    Now,
+	}}
+
+	LastLogin, Ok := GetGuildLastLogin(1)
+	if !Ok || LastLogin != Yesterday {
+		t.Fatalf("Guild 1 last login is %v, expected %v", LastLogin, Yesterday)
+	}
+
+	Guild := &TGuild{GuildID: 1, Created: int(Now.Add(-365 * 24 * time.Hour).Unix())}
+	Reason := GetGuildDisbandReason(Guild, LastLogin, Now)
+	if Reason == GUILD_DISBAND_INACTIVE {
+		t.Errorf("Guild 1 is inactive although its leader logged in yesterday")
+	}
+
+	Reason = GetGuildDisbandReason(Guild, 0, Now)
+	if Reason != GUILD_DISBAND_INACTIVE {
+		t.Errorf("Guild 1 without logins has disband reason %q, expected %q", Reason, GUILD_DISBAND_INACTIVE)
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	GUILD_EVENT_OK          = 0
+	GUILD_EVENT_NOT_FOUND   = 1
+	GUILD_EVENT_FULL        = 2
+	GUILD_EVENT_STARTED     = 3
+	GUILD_EVENT_BAD_DETAILS = 4
+	GUILD_EVENT_BAD_STATUS  = 5
+	GUILD_EVENT_ERROR       = 6
+)
+
+const (
+	GUILD_EVENT_YES   = "yes"
+	GUILD_EVENT_MAYBE = "maybe"
+	GUILD_EVENT_NO    = "no"
+)
+
+const (
+	GUILD_EVENT_TITLE_MAX       = 80
+	GUILD_EVENT_DESCRIPTION_MAX = 2000
+	GUILD_EVENT_MAX_CAPACITY    = 1000
+	GUILD_EVENT_MAX_ADVANCE     = 365 * 24 * time.Hour
+	GUILD_EVENT_UPCOMING_MAX    = 10
+	GUILD_EVENT_EXPORT_HISTORY  = 30 * 24 * time.Hour
+	GUILD_EVENT_TIME_FORMAT     = "2006-01-02T15:04"
+	GUILD_EVENT_MONTH_FORMAT    = "2006-01"
+)
+
+type (
+	TGuildEvent struct {
+		EventID     int
+		GuildID     int
+		Title       string
+		Description string
+		StartTime   int
+		Capacity    int
+		Going       int
+		Maybe       int
+		Declined    int
+	}
+
+	TGuildEventSignup struct {
+		CharacterID   int
+		CharacterName string
+		Status        string
+		Updated       int
+	}
+
+	TGuildCalendarDay struct {
+		Day    int
+		Today  bool
+		Events []TGuildEvent
+	}
+
+	TGuildEventReminder struct {
+		EventID       int
+		CharacterID   int
+		CharacterName string
+		AccountID     int
+		Email         string
+		Status        string
+		Title         string
+		StartTime     int
+		GuildName     string
+	}
+)
+
+func InitGuildEvents() bool {
+	g_Log.Printf("GuildEventReminderInterval: %v", g_GuildEventReminderInterval)
+	g_Log.Printf("GuildEventReminderAdvance: %v", g_GuildEventReminderAdvance)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: `Capacity` is how many characters can sign up as attending, with
+	// zero meaning there is no limit. `Reminded` is set on a sign-up once its
+	// account got the reminder e-mail, so failed ones are tried again.
+	for _, Statement := range []string{`
+		CREATE TABLE IF NOT EXISTS GuildEvents (
+			EventID INTEGER PRIMARY KEY AUTOINCREMENT,
+			GuildID INTEGER NOT NULL,
+			Title TEXT NOT NULL,
+			Description TEXT NOT NULL,
+			StartTime INTEGER NOT NULL,
+			Capacity INTEGER NOT NULL,
+			Created INTEGER NOT NULL
+		)`, `
+		CREATE INDEX IF NOT EXISTS GuildEventsGuildIndex
+			ON GuildEvents (GuildID, StartTime)`, `
+		CREATE TABLE IF NOT EXISTS GuildEventSignups (
+			EventID INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			Status TEXT NOT NULL,
+			Updated INTEGER NOT NULL,
+			Reminded INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (EventID, CharacterID)
+		)`,
+	} {
+		if _, Err := g_NewsDb.Exec(Statement); Err != nil {
+			g_LogErr.Printf("Failed to create guild event tables: %v", Err)
+			return false
+		}
+	}
+
+	StartJob("guild event reminders", g_GuildEventReminderInterval, SendGuildEventReminders)
+	return true
+}
+
+func ExitGuildEvents() {
+	// no-op
+}
+
+func IsGuildEventStatus(Status string) bool {
+	return Status == GUILD_EVENT_YES || Status == GUILD_EVENT_MAYBE || Status == GUILD_EVENT_NO
+}
+
+func QueryGuildEvents(Where string, Args ...any) []TGuildEvent {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT e.EventID, e.GuildID, e.Title, e.Description, e.StartTime, e.Capacity,
+			COUNT(CASE WHEN s.Status = 'yes' THEN 1 END),
+			COUNT(CASE WHEN s.Status = 'maybe' THEN 1 END),
+			COUNT(CASE WHEN s.Status = 'no' THEN 1 END)
+		FROM GuildEvents e
+		LEFT JOIN GuildEventSignups s ON s.EventID = e.EventID
+		`+Where+`
+		GROUP BY e.EventID
+		ORDER BY e.StartTime ASC, e.EventID ASC
+	`, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild events: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Events []TGuildEvent
+	for Rows.Next() {
+		var Event TGuildEvent
+		if Err := Rows.Scan(&Event.EventID, &Event.GuildID, &Event.Title, &Event.Description,
+			&Event.StartTime, &Event.Capacity, &Event.Going, &Event.Maybe, &Event.Declined); Err != nil {
+			g_LogErr.Printf("Failed to scan guild event: %v", Err)
+			continue
+		}
+		Events = append(Events, Event)
+	}
+	return Events
+}
+
+// GetGuildEvents returns the events of a guild that start in [From, To).
+func GetGuildEvents(GuildID int, From int64, To int64) []TGuildEvent {
+	return QueryGuildEvents("WHERE e.GuildID = ? AND e.StartTime >= ? AND e.StartTime < ?",
+		GuildID, From, To)
+}
+
+func GetUpcomingGuildEvents(GuildID int, Limit int) []TGuildEvent {
+	Events := QueryGuildEvents("WHERE e.GuildID = ? AND e.StartTime > ?",
+		GuildID, time.Now().Unix())
+	if len(Events) > Limit {
+		Events = Events[:Limit]
+	}
+	return Events
+}
+
+func GetGuildEvent(EventID int) *TGuildEvent {
+	Events := QueryGuildEvents("WHERE e.EventID = ?", EventID)
+	if len(Events) == 0 {
+		return nil
+	}
+	return &Events[0]
+}
+
+// GetGuildEventSignups returns who answered an event, attending characters
+// first and then by when they answered.
+func GetGuildEventSignups(EventID int) []TGuildEventSignup {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT s.CharacterID, COALESCE(c.Name, ''), s.Status, s.Updated
+		FROM GuildEventSignups s
+		LEFT JOIN Characters c ON c.CharacterID = s.CharacterID
+		WHERE s.EventID = ?
+		ORDER BY CASE s.Status WHEN 'yes' THEN 0 WHEN 'maybe' THEN 1 ELSE 2 END,
+			s.Updated ASC
+	`, EventID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild event signups: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Signups []TGuildEventSignup
+	for Rows.Next() {
+		var Signup TGuildEventSignup
+		if Err := Rows.Scan(&Signup.CharacterID, &Signup.CharacterName,
+			&Signup.Status, &Signup.Updated); Err != nil {
+			g_LogErr.Printf("Failed to scan guild event signup: %v", Err)
+			continue
+		}
+		Signups = append(Signups, Signup)
+	}
+	return Signups
+}
+
+// CreateGuildEvent schedules an event and returns its id. Events have to start
+// in the future, but not too far ahead.
+func CreateGuildEvent(GuildID int, Title string, Description string, StartTime int64, Capacity int) (int, int) {
+	if g_NewsDb == nil {
+		return 0, GUILD_EVENT_ERROR
+	}
+
+	Title = SanitizeGuildBoardText(Title, GUILD_EVENT_TITLE_MAX, false)
+	Description = SanitizeGuildBoardText(Description, GUILD_EVENT_DESCRIPTION_MAX, true)
+	Now := time.Now()
+	if Title == "" || Capacity < 0 || Capacity > GUILD_EVENT_MAX_CAPACITY ||
+		StartTime <= Now.Unix() || StartTime > Now.Add(GUILD_EVENT_MAX_ADVANCE).Unix() {
+		return 0, GUILD_EVENT_BAD_DETAILS
+	}
+
+	Result, Err := g_NewsDb.Exec(`
+		INSERT INTO GuildEvents (GuildID, Title, Description, StartTime, Capacity, Created)
+		SELECT GuildID, ?, ?, ?, ?, ? FROM Guilds WHERE GuildID = ?
+	`, Title, Description, StartTime, Capacity, Now.Unix(), GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild event: %v", Err)
+		return 0, GUILD_EVENT_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return 0, GUILD_EVENT_NOT_FOUND
+	}
+
+	EventID, Err := Result.LastInsertId()
+	if Err != nil {
+		g_LogErr.Printf("Failed to get guild event id: %v", Err)
+		return 0, GUILD_EVENT_ERROR
+	}
+	return int(EventID), GUILD_EVENT_OK
+}
+
+func DeleteGuildEvent(EventID int) int {
+	if g_NewsDb == nil {
+		return GUILD_EVENT_ERROR
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+	defer Tx.Rollback()
+
+	Result, Err := Tx.Exec(`DELETE FROM GuildEvents WHERE EventID = ?`, EventID)
+	if Err == nil {
+		_, Err = Tx.Exec(`DELETE FROM GuildEventSignups WHERE EventID = ?`, EventID)
+	}
+	if Err != nil {
+		g_LogErr.Printf("Failed to delete guild event: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return GUILD_EVENT_NOT_FOUND
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild event: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+	return GUILD_EVENT_OK
+}
+
+// SignUpGuildEvent records the answer of a character to an event, replacing
+// any previous one. Answers can't change once the event started, and only
+// `Capacity` characters can be attending.
+func SignUpGuildEvent(EventID int, CharacterID int, Status string) int {
+	if g_NewsDb == nil {
+		return GUILD_EVENT_ERROR
+	}
+
+	if !IsGuildEventStatus(Status) {
+		return GUILD_EVENT_BAD_STATUS
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+	defer Tx.Rollback()
+
+	var StartTime int64
+	var Capacity, Going int
+	Err = Tx.QueryRow(`
+		SELECT e.StartTime, e.Capacity,
+			(SELECT COUNT(*) FROM GuildEventSignups s
+				WHERE s.EventID = e.EventID AND s.Status = 'yes' AND s.CharacterID != ?)
+		FROM GuildEvents e WHERE e.EventID = ?
+	`, CharacterID, EventID).Scan(&StartTime, &Capacity, &Going)
+	if Err == sql.ErrNoRows {
+		return GUILD_EVENT_NOT_FOUND
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query guild event: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+
+	if StartTime <= time.Now().Unix() {
+		return GUILD_EVENT_STARTED
+	}
+
+	if Status == GUILD_EVENT_YES && Capacity > 0 && Going >= Capacity {
+		return GUILD_EVENT_FULL
+	}
+
+	_, Err = Tx.Exec(`
+		INSERT INTO GuildEventSignups (EventID, CharacterID, Status, Updated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (EventID, CharacterID) DO UPDATE
+			SET Status = excluded.Status, Updated = excluded.Updated
+	`, EventID, CharacterID, Status, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild event signup: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild event signup: %v", Err)
+		return GUILD_EVENT_ERROR
+	}
+	return GUILD_EVENT_OK
+}
+
+// DeleteGuildEvents removes the events of a guild and their sign-ups when
+// it's disbanded.
+func DeleteGuildEvents(Tx *sql.Tx, GuildID int) error {
+	_, Err := Tx.Exec(`
+		DELETE FROM GuildEventSignups WHERE EventID IN
+			(SELECT EventID FROM GuildEvents WHERE GuildID = ?)
+	`, GuildID)
+	if Err != nil {
+		return Err
+	}
+
+	_, Err = Tx.Exec(`DELETE FROM GuildEvents WHERE GuildID = ?`, GuildID)
+	return Err
+}
+
+// GetGuildCalendarMonth returns the start of the month a calendar shows, in
+// the viewer's time zone. Months that can't be parsed show the current one.
+func GetGuildCalendarMonth(Month string, Location *time.Location) time.Time {
+	if Start, Err := time.ParseInLocation(GUILD_EVENT_MONTH_FORMAT, Month, Location); Err == nil {
+		return Start
+	}
+
+	Now := time.Now().In(Location)
+	return time.Date(Now.Year(), Now.Month(), 1, 0, 0, 0, 0, Location)
+}
+
+// BuildGuildCalendar lays the events of a month out in weeks starting on
+// Monday. Days outside the month are left empty to fill the first and last
+// week.
+func BuildGuildCalendar(Month time.Time, Events []TGuildEvent) [][]TGuildCalendarDay {
+	Location := Month.Location()
+	Now := time.Now().In(Location)
+	Days := Month.AddDate(0, 1, -1).Day()
+	Offset := (int(Month.Weekday()) + 6) % 7
+
+	Cells := make([]TGuildCalendarDay, Offset, Offset+Days+6)
+	for Day := 1; Day <= Days; Day += 1 {
+		Cells = append(Cells, TGuildCalendarDay{
+			Day: Day,
+			Today: Now.Year() == Month.Year() && Now.Month() == Month.Month() &&
+				Now.Day() == Day,
+		})
+	}
+
+	for len(Cells)%7 != 0 {
+		Cells = append(Cells, TGuildCalendarDay{})
+	}
+
+	for _, Event := range Events {
+		Start := time.Unix(int64(Event.StartTime), 0).In(Location)
+		if Start.Year() == Month.Year() && Start.Month() == Month.Month() {
+			Cell := &Cells[Offset+Start.Day()-1]
+			Cell.Events = append(Cell.Events, Event)
+		}
+	}
+
+	var Weeks [][]TGuildCalendarDay
+	for Index := 0; Index < len(Cells); Index += 7 {
+		Weeks = append(Weeks, Cells[Index:Index+7])
+	}
+	return Weeks
+}
+
+// EscapeICalendarText escapes a value for an iCalendar TEXT property.
+func EscapeICalendarText(Text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(Text)
+}
+
+// WriteICalendarLine writes a content line, folding it so no line is longer
+// than 75 octets without splitting a UTF-8 sequence.
+func WriteICalendarLine(Buffer *bytes.Buffer, Line string) {
+	Limit := 75
+	for len(Line) > Limit {
+		Cut := Limit
+		for Cut > 0 && !utf8.RuneStart(Line[Cut]) {
+			Cut -= 1
+		}
+		Buffer.WriteString(Line[:Cut])
+		Buffer.WriteString("\r\n ")
+		Line = Line[Cut:]
+		// NOTE: The leading space of continuation lines counts too.
+		Limit = 74
+	}
+	Buffer.WriteString(Line)
+	Buffer.WriteString("\r\n")
+}
+
+// BuildGuildEventsCalendar exports the events of a guild as an iCalendar
+// file. Times are written in UTC so calendar applications show them in their
+// own time zone.
+func BuildGuildEventsCalendar(Guild *TGuild, Events []TGuildEvent) []byte {
+	// NOTE: Calendar applications use the UID to tell whether an event was
+	// already imported, so it has to be the same for every export.
+	Host := "localhost"
+	if URL, Err := url.Parse(g_WebsiteURL); Err == nil && URL.Host != "" {
+		Host = URL.Host
+	}
+
+	var Buffer bytes.Buffer
+	Stamp := time.Now().UTC().Format("20060102T150405Z")
+	WriteICalendarLine(&Buffer, "BEGIN:VCALENDAR")
+	WriteICalendarLine(&Buffer, "VERSION:2.0")
+	WriteICalendarLine(&Buffer, "PRODID:-//tibia-web//Guild Events//EN")
+	WriteICalendarLine(&Buffer, "CALSCALE:GREGORIAN")
+	WriteICalendarLine(&Buffer, "METHOD:PUBLISH")
+	WriteICalendarLine(&Buffer, "X-WR-CALNAME:"+EscapeICalendarText(Guild.Name))
+	for _, Event := range Events {
+		Start := time.Unix(int64(Event.StartTime), 0).UTC().Format("20060102T150405Z")
+		WriteICalendarLine(&Buffer, "BEGIN:VEVENT")
+		WriteICalendarLine(&Buffer, fmt.Sprintf("UID:guild-event-%v@%v", Event.EventID, Host))
+		WriteICalendarLine(&Buffer, "DTSTAMP:"+Stamp)
+		WriteICalendarLine(&Buffer, "DTSTART:"+Start)
+		WriteICalendarLine(&Buffer, "SUMMARY:"+EscapeICalendarText(Event.Title))
+		if Event.Description != "" {
+			WriteICalendarLine(&Buffer, "DESCRIPTION:"+EscapeICalendarText(Event.Description))
+		}
+		WriteICalendarLine(&Buffer, "URL:"+WebsiteURL(fmt.Sprintf("/guild/event?id=%v", Event.EventID)))
+		WriteICalendarLine(&Buffer, "END:VEVENT")
+	}
+	WriteICalendarLine(&Buffer, "END:VCALENDAR")
+	return Buffer.Bytes()
+}
+
+// SendGuildEventReminders e-mails the accounts of characters that signed up
+// as attending or maybe for events starting soon. Characters that left the
+// guild since they signed up aren't reminded. The time is shown in the time
+// zone of each account.
+func SendGuildEventReminders() {
+	Now := time.Now()
+	Rows, Err := g_NewsDb.Query(`
+		SELECT s.EventID, s.CharacterID, c.Name, c.AccountID, COALESCE(a.Email, ''),
+			s.Status, e.Title, e.StartTime, g.Name
+		FROM GuildEventSignups s
+		JOIN GuildEvents e ON e.EventID = s.EventID
+		JOIN Characters c ON c.CharacterID = s.CharacterID
+		JOIN Accounts a ON a.AccountID = c.AccountID
+		JOIN Guilds g ON g.GuildID = e.GuildID
+		WHERE s.Reminded = 0 AND s.Status IN ('yes', 'maybe')
+			AND e.StartTime > ? AND e.StartTime <= ?
+			AND (g.LeaderID = s.CharacterID OR s.CharacterID IN (
+				SELECT CharacterID FROM GuildMembers WHERE GuildID = e.GuildID))
+	`, Now.Unix(), Now.Add(g_GuildEventReminderAdvance).Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild event reminders: %v", Err)
+		return
+	}
+
+	var Reminders []TGuildEventReminder
+	for Rows.Next() {
+		var Reminder TGuildEventReminder
+		if Err := Rows.Scan(&Reminder.EventID, &Reminder.CharacterID, &Reminder.CharacterName,
+			&Reminder.AccountID, &Reminder.Email, &Reminder.Status, &Reminder.Title,
+			&Reminder.StartTime, &Reminder.GuildName); Err != nil {
+			g_LogErr.Printf("Failed to scan guild event reminder: %v", Err)
+			continue
+		}
+		Reminders = append(Reminders, Reminder)
+	}
+	Rows.Close()
+
+	for _, Reminder := range Reminders {
+		if Reminder.Email == "" {
+			continue
+		}
+
+		Answer := "will attend"
+		if Reminder.Status == GUILD_EVENT_MAYBE {
+			Answer = "might attend"
+		}
+
+		Location := GetAccountPreferences(Reminder.AccountID).Location
+		Subject := fmt.Sprintf("%v starts soon", Reminder.Title)
+		Body := fmt.Sprintf("<p>%v, the %v event %v starts on %v.</p><p>You said you %v.</p>",
+			html.EscapeString(Reminder.CharacterName), html.EscapeString(Reminder.GuildName),
+			html.EscapeString(Reminder.Title), FormatTimestamp(Reminder.StartTime, Location),
+			Answer)
+		if Err := SendMail(Reminder.Email, Subject, Body); Err != nil {
+			g_LogErr.Printf("Failed to send guild event reminder e-mail to character %v: %v",
+				Reminder.CharacterID, Err)
+			continue
+		}
+
+		_, Err := g_NewsDb.Exec(`
+			UPDATE GuildEventSignups SET Reminded = 1 WHERE EventID = ? AND CharacterID = ?
+		`, Reminder.EventID, Reminder.CharacterID)
+		if Err != nil {
+			g_LogErr.Printf("Failed to update guild event signup: %v", Err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"time"
+)
+
+const (
+	GUILD_LOGO_OK             = 0
+	GUILD_LOGO_TOO_LARGE      = 1
+	GUILD_LOGO_BAD_FORMAT     = 2
+	GUILD_LOGO_BAD_DIMENSIONS = 3
+	GUILD_LOGO_NOT_FOUND      = 4
+	GUILD_LOGO_ERROR          = 5
+	GUILD_LOGO_DEFAULT_SIZE   = 64
+	GUILD_LOGO_FORM_OVERHEAD  = 16 * 1024
+)
+
+var (
+	// NOTE: These are the format names registered by the `image` decoders
+	// imported above. Anything else is rejected before it's decoded.
+	g_GuildLogoFormats = []string{"png", "jpeg", "gif"}
+
+	g_DefaultGuildLogo []byte
+)
+
+func InitGuildLogos() bool {
+	g_Log.Printf("GuildLogoMaxSize: %v", g_GuildLogoMaxSize)
+	g_Log.Printf("GuildLogoMaxDimension: %v", g_GuildLogoMaxDimension)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: Logos are small and already re-encoded as PNG, so they're kept
+	// in the database with the rest of the guild data and go away with it.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildLogos (
+			GuildID INTEGER NOT NULL,
+			Data BLOB NOT NULL,
+			Updated INTEGER NOT NULL,
+			PRIMARY KEY (GuildID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild logos table: %v", Err)
+		return false
+	}
+
+	g_DefaultGuildLogo, Err = RenderDefaultGuildLogo()
+	if Err != nil {
+		g_LogErr.Printf("Failed to render default guild logo: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func ExitGuildLogos() {
+	// no-op
+}
+
+// RenderDefaultGuildLogo draws the shield shown for guilds without a logo.
+func RenderDefaultGuildLogo() ([]byte, error) {
+	Size := GUILD_LOGO_DEFAULT_SIZE
+	Border := color.RGBA{0xc9, 0xa8, 0x6a, 0xff}
+	Fill := color.RGBA{0x3d, 0x28, 0x17, 0xff}
+	Image := image.NewRGBA(image.Rect(0, 0, Size, Size))
+
+	// NOTE: The shield has straight sides down to the middle and then
+	// narrows to a point. A pixel is part of the border if it's inside the
+	// shield but outside the same shield shrunk by `Inset`.
+	InShield := func(X, Y, Inset float64) bool {
+		Top, Bottom := 4+Inset, float64(Size)-4-Inset
+		Left, Right := 8+Inset, float64(Size)-8-Inset
+		if Y < Top || Y > Bottom || X < Left || X > Right {
+			return false
+		}
+
+		Middle := float64(Size) / 2
+		if Y <= Middle {
+			return true
+		}
+
+		HalfWidth := (Right - Left) / 2 * (Bottom - Y) / (Bottom - Middle)
+		return X >= Middle-HalfWidth && X <= Middle+HalfWidth
+	}
+
+	for Y := 0; Y < Size; Y += 1 {
+		for X := 0; X < Size; X += 1 {
+			PX, PY := float64(X)+0.5, float64(Y)+0.5
+			if InShield(PX, PY, 3) {
+				Image.SetRGBA(X, Y, Fill)
+			} else if InShield(PX, PY, 0) {
+				Image.SetRGBA(X, Y, Border)
+			}
+		}
+	}
+
+	var Buffer bytes.Buffer
+	if Err := png.Encode(&Buffer, Image); Err != nil {
+		return nil, Err
+	}
+	return Buffer.Bytes(), nil
+}
+
+// ReencodeGuildLogo checks an uploaded logo against the allowed formats and
+// limits, and decodes it into a fresh PNG so nothing but the pixels of the
+// original file makes it through (e.g. metadata or data appended to it).
+func ReencodeGuildLogo(Data []byte) ([]byte, int) {
+	if len(Data) == 0 || len(Data) > g_GuildLogoMaxSize {
+		return nil, GUILD_LOGO_TOO_LARGE
+	}
+
+	// NOTE: The header is checked before decoding anything so a small file
+	// claiming huge dimensions can't make us allocate the whole image.
+	Config, Format, Err := image.DecodeConfig(bytes.NewReader(Data))
+	if Err != nil {
+		return nil, GUILD_LOGO_BAD_FORMAT
+	}
+
+	Allowed := false
+	for _, Name := range g_GuildLogoFormats {
+		if Name == Format {
+			Allowed = true
+			break
+		}
+	}
+
+	if !Allowed {
+		return nil, GUILD_LOGO_BAD_FORMAT
+	}
+
+	if Config.Width <= 0 || Config.Height <= 0 ||
+		Config.Width > g_GuildLogoMaxDimension || Config.Height > g_GuildLogoMaxDimension {
+		return nil, GUILD_LOGO_BAD_DIMENSIONS
+	}
+
+	Decoded, _, Err := image.Decode(bytes.NewReader(Data))
+	if Err != nil {
+		return nil, GUILD_LOGO_BAD_FORMAT
+	}
+
+	Bounds := Decoded.Bounds()
+	Image := image.NewNRGBA(image.Rect(0, 0, Bounds.Dx(), Bounds.Dy()))
+	for Y := 0; Y < Bounds.Dy(); Y += 1 {
+		for X := 0; X < Bounds.Dx(); X += 1 {
+			Image.Set(X, Y, Decoded.At(Bounds.Min.X+X, Bounds.Min.Y+Y))
+		}
+	}
+
+	var Buffer bytes.Buffer
+	Encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if Err := Encoder.Encode(&Buffer, Image); Err != nil {
+		g_LogErr.Printf("Failed to encode guild logo: %v", Err)
+		return nil, GUILD_LOGO_ERROR
+	}
+	return Buffer.Bytes(), GUILD_LOGO_OK
+}
+
+// GetGuildLogo returns the logo of a guild and when it was uploaded, or the
+// default logo and zero if it doesn't have one.
+func GetGuildLogo(GuildID int) ([]byte, int) {
+	if g_NewsDb == nil {
+		return g_DefaultGuildLogo, 0
+	}
+
+	var Data []byte
+	var Updated int
+	Err := g_NewsDb.QueryRow(`
+		SELECT Data, Updated FROM GuildLogos WHERE GuildID = ?
+	`, GuildID).Scan(&Data, &Updated)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query guild logo: %v", Err)
+		}
+		return g_DefaultGuildLogo, 0
+	}
+	return Data, Updated
+}
+
+// GetGuildLogoUpdated returns when the logo of a guild was uploaded, or zero
+// if it doesn't have one. It's used to bust cached copies of the logo.
+func GetGuildLogoUpdated(GuildID int) int {
+	if g_NewsDb == nil {
+		return 0
+	}
+
+	var Updated int
+	Err := g_NewsDb.QueryRow(`
+		SELECT Updated FROM GuildLogos WHERE GuildID = ?
+	`, GuildID).Scan(&Updated)
+	if Err != nil && Err != sql.ErrNoRows {
+		g_LogErr.Printf("Failed to query guild logo: %v", Err)
+	}
+	return Updated
+}
+
+// SetGuildLogo validates and stores the logo of a guild, replacing the
+// previous one.
+func SetGuildLogo(GuildID int, Data []byte) int {
+	if g_NewsDb == nil {
+		return GUILD_LOGO_ERROR
+	}
+
+	Logo, Result := ReencodeGuildLogo(Data)
+	if Result != GUILD_LOGO_OK {
+		return Result
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		INSERT INTO GuildLogos (GuildID, Data, Updated)
+		SELECT GuildID, ?, ? FROM Guilds WHERE GuildID = ?
+		ON CONFLICT (GuildID) DO UPDATE SET Data = excluded.Data, Updated = excluded.Updated
+	`, Logo, time.Now().Unix(), GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild logo: %v", Err)
+		return GUILD_LOGO_ERROR
+	}
+	return GUILD_LOGO_OK
+}
+
+func DeleteGuildLogo(GuildID int) int {
+	if g_NewsDb == nil {
+		return GUILD_LOGO_ERROR
+	}
+
+	Result, Err := g_NewsDb.Exec(`
+		DELETE FROM GuildLogos WHERE GuildID = ?
+	`, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to delete guild logo: %v", Err)
+		return GUILD_LOGO_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return GUILD_LOGO_NOT_FOUND
+	}
+	return GUILD_LOGO_OK
+}
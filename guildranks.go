@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// NOTE: Ranks are ordered by `RankID`, lower being higher. The first three are
+// always there and carry the permissions: the leader, vice leaders, and the top
+// member rank. Guilds without rows in `GuildRanks` use the default names.
+const (
+	GUILD_RANK_LEADER      = 0
+	GUILD_RANK_VICE_LEADER = 1
+	GUILD_RANK_MEMBER      = 2
+	GUILD_RANK_NAME_MAX    = 30
+	GUILD_TITLE_MAX        = 30
+)
+
+const (
+	GUILD_RANK_OK          = 0
+	GUILD_RANK_NOT_FOUND   = 1
+	GUILD_RANK_NOT_ALLOWED = 2
+	GUILD_RANK_ERROR       = 3
+)
+
+type (
+	TGuildRank struct {
+		RankID  int
+		Name    string
+		Members int
+	}
+)
+
+func InitGuildRanks() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildRanks (
+			GuildID INTEGER NOT NULL,
+			RankID INTEGER NOT NULL,
+			Name TEXT NOT NULL,
+			PRIMARY KEY (GuildID, RankID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild ranks table: %v", Err)
+		return false
+	}
+
+	// NOTE: Older databases may have the table without a primary key.
+	_, Err = g_NewsDb.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS GuildRanksIndex
+			ON GuildRanks (GuildID, RankID)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild ranks index: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func ExitGuildRanks() {
+	// no-op
+}
+
+func DefaultGuildRankName(RankID int) string {
+	switch RankID {
+	case GUILD_RANK_LEADER:
+		return "Leader"
+	case GUILD_RANK_VICE_LEADER:
+		return "Vice-Leader"
+	case GUILD_RANK_MEMBER:
+		return "Member"
+	default:
+		return "Unknown"
+	}
+}
+
+// GetGuildRanks returns the ranks of a guild, highest first, along with how
+// many members each one has.
+func GetGuildRanks(GuildID int) []TGuildRank {
+	Ranks := []TGuildRank{
+		{RankID: GUILD_RANK_LEADER, Name: DefaultGuildRankName(GUILD_RANK_LEADER)},
+		{RankID: GUILD_RANK_VICE_LEADER, Name: DefaultGuildRankName(GUILD_RANK_VICE_LEADER)},
+		{RankID: GUILD_RANK_MEMBER, Name: DefaultGuildRankName(GUILD_RANK_MEMBER)},
+	}
+
+	if g_NewsDb == nil {
+		return Ranks
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT RankID, Name FROM GuildRanks WHERE GuildID = ? ORDER BY RankID ASC
+	`, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild ranks: %v", Err)
+		return Ranks
+	}
+	defer Rows.Close()
+
+	for Rows.Next() {
+		var Rank TGuildRank
+		if Err := Rows.Scan(&Rank.RankID, &Rank.Name); Err != nil {
+			g_LogErr.Printf("Failed to scan guild rank: %v", Err)
+			continue
+		}
+
+		if Rank.RankID >= 0 && Rank.RankID <= GUILD_RANK_MEMBER {
+			Ranks[Rank.RankID] = Rank
+		} else if Rank.RankID > GUILD_RANK_MEMBER {
+			Ranks = append(Ranks, Rank)
+		}
+	}
+	Rows.Close()
+
+	CountRows, Err := g_NewsDb.Query(`
+		SELECT Rank, COUNT(*) FROM GuildMembers WHERE GuildID = ? GROUP BY Rank
+	`, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to count guild rank members: %v", Err)
+		return Ranks
+	}
+	defer CountRows.Close()
+
+	for CountRows.Next() {
+		var RankID, Members int
+		if Err := CountRows.Scan(&RankID, &Members); Err != nil {
+			g_LogErr.Printf("Failed to scan guild rank members: %v", Err)
+			continue
+		}
+
+		for Index := range Ranks {
+			if Ranks[Index].RankID == RankID {
+				Ranks[Index].Members = Members
+			}
+		}
+	}
+	return Ranks
+}
+
+// GetGuildJoinRank returns the lowest rank of a guild, which new members start
+// with.
+func GetGuildJoinRank(GuildID int) int {
+	if g_NewsDb == nil {
+		return GUILD_RANK_MEMBER
+	}
+
+	var RankID int
+	Err := g_NewsDb.QueryRow(`
+		SELECT MAX(RankID, ?) FROM (SELECT COALESCE(MAX(RankID), 0) AS RankID
+			FROM GuildRanks WHERE GuildID = ?)
+	`, GUILD_RANK_MEMBER, GuildID).Scan(&RankID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild join rank: %v", Err)
+		return GUILD_RANK_MEMBER
+	}
+	return RankID
+}
+
+// InsertDefaultGuildRanks makes sure the default ranks have rows, so they can
+// be renamed and moved like the others.
+func InsertDefaultGuildRanks(Tx *sql.Tx, GuildID int) error {
+	for RankID := GUILD_RANK_LEADER; RankID <= GUILD_RANK_MEMBER; RankID += 1 {
+		_, Err := Tx.Exec(`
+			INSERT OR IGNORE INTO GuildRanks (GuildID, RankID, Name)
+			VALUES (?, ?, ?)
+		`, GuildID, RankID, DefaultGuildRankName(RankID))
+		if Err != nil {
+			return Err
+		}
+	}
+	return nil
+}
+
+// UpdateGuildRanks runs `Update` in a transaction, after the default ranks of
+// the guild have been inserted.
+func UpdateGuildRanks(GuildID int, Update func(Tx *sql.Tx) (int, error)) int {
+	if g_NewsDb == nil {
+		return GUILD_RANK_ERROR
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_RANK_ERROR
+	}
+	defer Tx.Rollback()
+
+	if Err := InsertDefaultGuildRanks(Tx, GuildID); Err != nil {
+		g_LogErr.Printf("Failed to insert default guild ranks: %v", Err)
+		return GUILD_RANK_ERROR
+	}
+
+	Result, Err := Update(Tx)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update guild ranks: %v", Err)
+		return GUILD_RANK_ERROR
+	}
+
+	if Result != GUILD_RANK_OK {
+		return Result
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild ranks: %v", Err)
+		return GUILD_RANK_ERROR
+	}
+	return GUILD_RANK_OK
+}
+
+func CreateGuildRank(GuildID int, Name string) int {
+	return UpdateGuildRanks(GuildID, func(Tx *sql.Tx) (int, error) {
+		_, Err := Tx.Exec(`
+			INSERT INTO GuildRanks (GuildID, RankID, Name)
+			SELECT ?, MAX(RankID) + 1, ? FROM GuildRanks WHERE GuildID = ?
+		`, GuildID, Name, GuildID)
+		return GUILD_RANK_OK, Err
+	})
+}
+
+func RenameGuildRank(GuildID int, RankID int, Name string) int {
+	return UpdateGuildRanks(GuildID, func(Tx *sql.Tx) (int, error) {
+		Result, Err := Tx.Exec(`
+			UPDATE GuildRanks SET Name = ? WHERE GuildID = ? AND RankID = ?
+		`, Name, GuildID, RankID)
+		if Err != nil {
+			return GUILD_RANK_ERROR, Err
+		}
+
+		if Affected, _ := Result.RowsAffected(); Affected == 0 {
+			return GUILD_RANK_NOT_FOUND, nil
+		}
+		return GUILD_RANK_OK, nil
+	})
+}
+
+func GuildRankExists(Tx *sql.Tx, GuildID int, RankID int) (bool, error) {
+	var Exists bool
+	Err := Tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM GuildRanks WHERE GuildID = ? AND RankID = ?)
+	`, GuildID, RankID).Scan(&Exists)
+	return Exists, Err
+}
+
+// MoveGuildRank swaps a rank with the one above (`Offset` -1) or below (`Offset`
+// 1) it, along with their members. Only member ranks can be moved, so the
+// leader and vice leader ranks always stay on top.
+func MoveGuildRank(GuildID int, RankID int, Offset int) int {
+	OtherID := RankID + Offset
+	if RankID < GUILD_RANK_MEMBER || OtherID < GUILD_RANK_MEMBER {
+		return GUILD_RANK_NOT_ALLOWED
+	}
+
+	return UpdateGuildRanks(GuildID, func(Tx *sql.Tx) (int, error) {
+		for _, ID := range []int{RankID, OtherID} {
+			if Exists, Err := GuildRankExists(Tx, GuildID, ID); Err != nil || !Exists {
+				return GUILD_RANK_NOT_FOUND, Err
+			}
+		}
+
+		// NOTE: Swap through -1 since (GuildID, RankID) is unique.
+		for _, Table := range []string{"GuildRanks", "GuildMembers"} {
+			Column := "RankID"
+			if Table == "GuildMembers" {
+				Column = "Rank"
+			}
+
+			for _, Swap := range [][2]int{{RankID, -1}, {OtherID, RankID}, {-1, OtherID}} {
+				_, Err := Tx.Exec(`
+					UPDATE `+Table+` SET `+Column+` = ? WHERE GuildID = ? AND `+Column+` = ?
+				`, Swap[1], GuildID, Swap[0])
+				if Err != nil {
+					return GUILD_RANK_ERROR, Err
+				}
+			}
+		}
+		return GUILD_RANK_OK, nil
+	})
+}
+
+// DeleteGuildRank deletes a rank below the default ones. Its members move to
+// the next lower rank, or the one above if it was the lowest, and lower ranks
+// move up to fill the gap.
+func DeleteGuildRank(GuildID int, RankID int) int {
+	if RankID <= GUILD_RANK_MEMBER {
+		return GUILD_RANK_NOT_ALLOWED
+	}
+
+	return UpdateGuildRanks(GuildID, func(Tx *sql.Tx) (int, error) {
+		if Exists, Err := GuildRankExists(Tx, GuildID, RankID); Err != nil || !Exists {
+			return GUILD_RANK_NOT_FOUND, Err
+		}
+
+		HasLower, Err := GuildRankExists(Tx, GuildID, RankID+1)
+		if Err != nil {
+			return GUILD_RANK_ERROR, Err
+		}
+
+		MemberUpdate := `UPDATE GuildMembers SET Rank = Rank - 1 WHERE GuildID = ? AND Rank > ?`
+		if !HasLower {
+			MemberUpdate = `UPDATE GuildMembers SET Rank = Rank - 1 WHERE GuildID = ? AND Rank = ?`
+		}
+
+		// NOTE: Lower ranks are shifted up through negative ids since
+		// (GuildID, RankID) is unique and rows aren't updated in order.
+		for _, Statement := range []string{
+			`DELETE FROM GuildRanks WHERE GuildID = ? AND RankID = ?`,
+			MemberUpdate,
+			`UPDATE GuildRanks SET RankID = 1 - RankID WHERE GuildID = ? AND RankID > ?`,
+		} {
+			if _, Err := Tx.Exec(Statement, GuildID, RankID); Err != nil {
+				return GUILD_RANK_ERROR, Err
+			}
+		}
+
+		_, Err = Tx.Exec(`
+			UPDATE GuildRanks SET RankID = -RankID WHERE GuildID = ? AND RankID < 0
+		`, GuildID)
+		if Err != nil {
+			return GUILD_RANK_ERROR, Err
+		}
+		return GUILD_RANK_OK, nil
+	})
+}
+
+// GetGuildMemberRank returns the rank of a character in a guild, or -1 if it
+// isn't a member.
+func GetGuildMemberRank(GuildID int, CharacterID int) int {
+	if g_NewsDb == nil {
+		return -1
+	}
+
+	var RankID int
+	Err := g_NewsDb.QueryRow(`
+		SELECT Rank FROM GuildMembers WHERE GuildID = ? AND CharacterID = ?
+	`, GuildID, CharacterID).Scan(&RankID)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query guild member rank: %v", Err)
+		}
+		return -1
+	}
+	return RankID
+}
+
+// CanChangeGuildMemberRank checks whether a member with `ActorRank` may move a
+// member from `MemberRank` to `NewRank`. The leader may set any rank, vice
+// leaders only for members below them and not above their own rank. The
+// leader rank itself only changes hands through a leadership transfer.
+func CanChangeGuildMemberRank(ActorRank int, MemberRank int, NewRank int) bool {
+	if MemberRank == GUILD_RANK_LEADER || NewRank == GUILD_RANK_LEADER {
+		return false
+	}
+
+	switch ActorRank {
+	case GUILD_RANK_LEADER:
+		return true
+	case GUILD_RANK_VICE_LEADER:
+		return MemberRank > ActorRank && NewRank >= ActorRank
+	default:
+		return false
+	}
+}
+
+// CanChangeGuildMemberTitle checks whether a member with `ActorRank` may change
+// the title of a member with `MemberRank`.
+func CanChangeGuildMemberTitle(ActorRank int, MemberRank int) bool {
+	switch ActorRank {
+	case GUILD_RANK_LEADER:
+		return true
+	case GUILD_RANK_VICE_LEADER:
+		return MemberRank > ActorRank
+	default:
+		return false
+	}
+}
+
+func SetGuildMemberRank(GuildID int, CharacterID int, RankID int) int {
+	return UpdateGuildRanks(GuildID, func(Tx *sql.Tx) (int, error) {
+		if Exists, Err := GuildRankExists(Tx, GuildID, RankID); Err != nil || !Exists {
+			return GUILD_RANK_NOT_FOUND, Err
+		}
+
+		Result, Err := Tx.Exec(`
+			UPDATE GuildMembers SET Rank = ? WHERE GuildID = ? AND CharacterID = ?
+		`, RankID, GuildID, CharacterID)
+		if Err != nil {
+			return GUILD_RANK_ERROR, Err
+		}
+
+		if Affected, _ := Result.RowsAffected(); Affected == 0 {
+			return GUILD_RANK_NOT_FOUND, nil
+		}
+		return GUILD_RANK_OK, nil
+	})
+}
+
+func SetGuildMemberTitle(GuildID int, CharacterID int, Title string) int {
+	if g_NewsDb == nil {
+		return GUILD_RANK_ERROR
+	}
+
+	Result, Err := g_NewsDb.Exec(`
+		UPDATE GuildMembers SET Title = ? WHERE GuildID = ? AND CharacterID = ?
+	`, Title, GuildID, CharacterID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update guild member title: %v", Err)
+		return GUILD_RANK_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return GUILD_RANK_NOT_FOUND
+	}
+	return GUILD_RANK_OK
+}
+
+// GetGuildRankNames returns the rank names of a guild indexed by rank id.
+func GetGuildRankNames(GuildID int) map[int]string {
+	Names := make(map[int]string)
+	for _, Rank := range GetGuildRanks(GuildID) {
+		Names[Rank.RankID] = Rank.Name
+	}
+	return Names
+}
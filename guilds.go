@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	GUILD_ACTION_OK              = 0
+	GUILD_ACTION_NOT_MEMBER      = 1
+	GUILD_ACTION_IS_LEADER       = 2
+	GUILD_ACTION_GUILD_NOT_FOUND = 3
+	GUILD_ACTION_ERROR           = 4
+)
+
+const (
+	GUILD_HISTORY_MAX           = 50
+	GUILD_HISTORY_LEFT          = "left"
+	GUILD_HISTORY_DISBANDED     = "disbanded"
+	GUILD_HISTORY_LEADERSHIP    = "leadership"
+	GUILD_HISTORY_REASON_LEADER = "Disbanded by the leader"
+)
+
+type (
+	TGuildHistoryEntry struct {
+		Timestamp     int
+		Action        string
+		CharacterName string
+		TargetName    string
+		Reason        string
+	}
+)
+
+func InitGuilds() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: The guild name is kept so entries still make sense after the
+	// guild is disbanded.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildHistory (
+			GuildID INTEGER NOT NULL,
+			GuildName TEXT NOT NULL,
+			Timestamp INTEGER NOT NULL,
+			Action TEXT NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			TargetID INTEGER NOT NULL,
+			Reason TEXT NOT NULL
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild history table: %v", Err)
+		return false
+	}
+
+	_, Err = g_NewsDb.Exec(`
+		CREATE INDEX IF NOT EXISTS GuildHistoryGuildIndex
+			ON GuildHistory (GuildID, Timestamp)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild history index: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func ExitGuilds() {
+	// no-op
+}
+
+func InsertGuildHistory(Tx *sql.Tx, GuildID int, Action string, CharacterID int, TargetID int, Reason string) error {
+	_, Err := Tx.Exec(`
+		INSERT INTO GuildHistory
+			(GuildID, GuildName, Timestamp, Action, CharacterID, TargetID, Reason)
+		SELECT GuildID, Name, ?, ?, ?, ?, ? FROM Guilds WHERE GuildID = ?
+	`, time.Now().Unix(), Action, CharacterID, TargetID, Reason, GuildID)
+	return Err
+}
+
+func GetGuildHistory(GuildID int) []TGuildHistoryEntry {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT h.Timestamp, h.Action, COALESCE(c.Name, ''), COALESCE(t.Name, ''), h.Reason
+		FROM GuildHistory h
+		LEFT JOIN Characters c ON c.CharacterID = h.CharacterID
+		LEFT JOIN Characters t ON t.CharacterID = h.TargetID
+		WHERE h.GuildID = ?
+		ORDER BY h.Timestamp DESC, h.rowid DESC
+		LIMIT ?
+	`, GuildID, GUILD_HISTORY_MAX)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild history: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var History []TGuildHistoryEntry
+	for Rows.Next() {
+		var Entry TGuildHistoryEntry
+		if Err := Rows.Scan(&Entry.Timestamp, &Entry.Action, &Entry.CharacterName,
+			&Entry.TargetName, &Entry.Reason); Err != nil {
+			g_LogErr.Printf("Failed to scan guild history: %v", Err)
+			continue
+		}
+		History = append(History, Entry)
+	}
+	return History
+}
+
+// GetAccountGuildCharacters returns the characters of an account that are
+// members of a guild.
+func GetAccountGuildCharacters(AccountID int, GuildID int) []TAccountCharacter {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT c.CharacterID, c.Name, c.Level
+		FROM GuildMembers m
+		JOIN Characters c ON c.CharacterID = m.CharacterID
+		WHERE m.GuildID = ? AND c.AccountID = ?
+		ORDER BY c.Name ASC
+	`, GuildID, AccountID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query account guild characters: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Characters []TAccountCharacter
+	for Rows.Next() {
+		var Character TAccountCharacter
+		if Err := Rows.Scan(&Character.CharacterID, &Character.Name, &Character.Level); Err != nil {
+			g_LogErr.Printf("Failed to scan account guild character: %v", Err)
+			continue
+		}
+		Characters = append(Characters, Character)
+	}
+	return Characters
+}
+
+// UpdateGuild runs `Update` in a transaction with the current leader of the
+// guild, which is used by actions that change its membership.
+func UpdateGuild(GuildID int, Update func(Tx *sql.Tx, LeaderID int) (int, error)) int {
+	if g_NewsDb == nil {
+		return GUILD_ACTION_ERROR
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_ACTION_ERROR
+	}
+	defer Tx.Rollback()
+
+	var LeaderID int
+	Err = Tx.QueryRow(`SELECT LeaderID FROM Guilds WHERE GuildID = ?`, GuildID).Scan(&LeaderID)
+	if Err == sql.ErrNoRows {
+		return GUILD_ACTION_GUILD_NOT_FOUND
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query guild leader: %v", Err)
+		return GUILD_ACTION_ERROR
+	}
+
+	Result, Err := Update(Tx, LeaderID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update guild: %v", Err)
+		return GUILD_ACTION_ERROR
+	}
+
+	if Result != GUILD_ACTION_OK {
+		return Result
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild: %v", Err)
+		return GUILD_ACTION_ERROR
+	}
+	return GUILD_ACTION_OK
+}
+
+// LeaveGuild removes a character of the account from a guild. The leader has
+// to pass the leadership on or disband the guild instead.
+func LeaveGuild(AccountID int, GuildID int, CharacterID int) int {
+	return UpdateGuild(GuildID, func(Tx *sql.Tx, LeaderID int) (int, error) {
+		if CharacterID == LeaderID {
+			return GUILD_ACTION_IS_LEADER, nil
+		}
+
+		Result, Err := Tx.Exec(`
+			DELETE FROM GuildMembers
+			WHERE GuildID = ? AND CharacterID = ? AND CharacterID IN
+				(SELECT CharacterID FROM Characters WHERE AccountID = ?)
+		`, GuildID, CharacterID, AccountID)
+		if Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		if Affected, _ := Result.RowsAffected(); Affected == 0 {
+			return GUILD_ACTION_NOT_MEMBER, nil
+		}
+
+		return GUILD_ACTION_OK, InsertGuildHistory(Tx, GuildID,
+			GUILD_HISTORY_LEFT, CharacterID, 0, "")
+	})
+}
+
+// DisbandGuild deletes a guild along with its members, invites, ranks, logo,
+// disband warning, message board and events.
+// `CharacterID` is who disbanded it, or zero if it was done automatically.
+func DisbandGuild(GuildID int, CharacterID int, Reason string) int {
+	return UpdateGuild(GuildID, func(Tx *sql.Tx, LeaderID int) (int, error) {
+		if Err := InsertGuildHistory(Tx, GuildID, GUILD_HISTORY_DISBANDED,
+			CharacterID, 0, Reason); Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		if Err := DeleteGuildBoard(Tx, GuildID); Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		if Err := DeleteGuildEvents(Tx, GuildID); Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		for _, Table := range []string{"GuildMembers", "GuildInvites", "GuildRanks",
+			"GuildLogos", "GuildDisbandWarnings", "Guilds"} {
+			if _, Err := Tx.Exec(`DELETE FROM `+Table+` WHERE GuildID = ?`, GuildID); Err != nil {
+				return GUILD_ACTION_ERROR, Err
+			}
+		}
+		return GUILD_ACTION_OK, nil
+	})
+}
+
+// TransferGuildLeadership makes a member the new leader. The previous leader
+// stays in the guild as a vice leader.
+func TransferGuildLeadership(GuildID int, NewLeaderID int) int {
+	return UpdateGuild(GuildID, func(Tx *sql.Tx, LeaderID int) (int, error) {
+		if NewLeaderID == LeaderID {
+			return GUILD_ACTION_IS_LEADER, nil
+		}
+
+		Result, Err := Tx.Exec(`
+			UPDATE GuildMembers SET Rank = ? WHERE GuildID = ? AND CharacterID = ?
+		`, GUILD_RANK_LEADER, GuildID, NewLeaderID)
+		if Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		if Affected, _ := Result.RowsAffected(); Affected == 0 {
+			return GUILD_ACTION_NOT_MEMBER, nil
+		}
+
+		// NOTE: Guilds founded through the website don't have a member row
+		// for the leader, so one is added if needed.
+		Result, Err = Tx.Exec(`
+			UPDATE GuildMembers SET Rank = ? WHERE GuildID = ? AND CharacterID = ?
+		`, GUILD_RANK_VICE_LEADER, GuildID, LeaderID)
+		if Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		if Affected, _ := Result.RowsAffected(); Affected == 0 {
+			_, Err = Tx.Exec(`
+				INSERT INTO GuildMembers (GuildID, CharacterID, Rank, Title, Joined, Status)
+				VALUES (?, ?, ?, '', ?, 'active')
+			`, GuildID, LeaderID, GUILD_RANK_VICE_LEADER, time.Now().Unix())
+			if Err != nil {
+				return GUILD_ACTION_ERROR, Err
+			}
+		}
+
+		_, Err = Tx.Exec(`
+			UPDATE Guilds SET LeaderID = ? WHERE GuildID = ?
+		`, NewLeaderID, GuildID)
+		if Err != nil {
+			return GUILD_ACTION_ERROR, Err
+		}
+
+		return GUILD_ACTION_OK, InsertGuildHistory(Tx, GuildID,
+			GUILD_HISTORY_LEADERSHIP, LeaderID, NewLeaderID, "")
+	})
+}
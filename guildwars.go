@@ -0,0 +1,462 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	GUILD_WAR_OK             = 0
+	GUILD_WAR_NOT_FOUND      = 1
+	GUILD_WAR_SAME_GUILD     = 2
+	GUILD_WAR_ALREADY_AT_WAR = 3
+	GUILD_WAR_NOT_PENDING    = 4
+	GUILD_WAR_BAD_TERMS      = 5
+	GUILD_WAR_ERROR          = 6
+)
+
+const (
+	GUILD_WAR_PENDING   = "pending"
+	GUILD_WAR_ACTIVE    = "active"
+	GUILD_WAR_ENDED     = "ended"
+	GUILD_WAR_REJECTED  = "rejected"
+	GUILD_WAR_CANCELLED = "cancelled"
+
+	GUILD_WAR_MAX_FRAG_LIMIT = 1000
+	GUILD_WAR_MAX_DAYS       = 30
+	GUILD_WAR_MAX_WAGER      = 100000000
+	GUILD_WAR_HISTORY_MAX    = 20
+)
+
+type (
+	// NOTE: `GuildID` is the guild that declared the war and `EnemyID` the
+	// one it was declared on. Names are kept so wars still make sense after
+	// either guild is disbanded. The stored frags are updated by the job
+	// while the war is active and are final once it ends. Pages show them as
+	// they are, so viewing a war never writes to the database.
+	TGuildWar struct {
+		WarID      int
+		GuildID    int
+		GuildName  string
+		EnemyID    int
+		EnemyName  string
+		Status     string
+		FragLimit  int
+		Duration   int
+		Wager      int
+		Declared   int
+		Started    int
+		Ended      int
+		GuildFrags int
+		EnemyFrags int
+		WinnerID   int
+	}
+)
+
+// Days returns the duration of the war in days.
+func (War TGuildWar) Days() int {
+	return War.Duration / (24 * 60 * 60)
+}
+
+// Deadline returns when an active war ends if no side reaches the frag
+// limit first.
+func (War TGuildWar) Deadline() int {
+	return War.Started + War.Duration
+}
+
+func InitGuildWars() bool {
+	g_Log.Printf("GuildWarInterval: %v", g_GuildWarInterval)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildWars (
+			WarID INTEGER PRIMARY KEY AUTOINCREMENT,
+			GuildID INTEGER NOT NULL,
+			GuildName TEXT NOT NULL,
+			EnemyID INTEGER NOT NULL,
+			EnemyName TEXT NOT NULL,
+			Status TEXT NOT NULL,
+			FragLimit INTEGER NOT NULL,
+			Duration INTEGER NOT NULL,
+			Wager INTEGER NOT NULL,
+			Declared INTEGER NOT NULL,
+			Started INTEGER NOT NULL DEFAULT 0,
+			Ended INTEGER NOT NULL DEFAULT 0,
+			GuildFrags INTEGER NOT NULL DEFAULT 0,
+			EnemyFrags INTEGER NOT NULL DEFAULT 0,
+			WinnerID INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild wars table: %v", Err)
+		return false
+	}
+
+	_, Err = g_NewsDb.Exec(`
+		CREATE INDEX IF NOT EXISTS GuildWarsStatusIndex
+			ON GuildWars (Status, Declared)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild wars index: %v", Err)
+		return false
+	}
+
+	// NOTE: Frags are stored as they're found along with the guild that
+	// scored them, so they still count after the killer or the victim leave
+	// their guild.
+	_, Err = g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS GuildWarFrags (
+			WarID INTEGER NOT NULL,
+			GuildID INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			Timestamp INTEGER NOT NULL,
+			PRIMARY KEY (WarID, CharacterID, Timestamp)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create guild war frags table: %v", Err)
+		return false
+	}
+
+	StartJob("guild wars", g_GuildWarInterval, UpdateGuildWars)
+	return true
+}
+
+func ExitGuildWars() {
+	// no-op
+}
+
+// RecordGuildWarFrags stores the frags of a war scored before `End`. A kill
+// only counts if both the killer and the victim were members of their guilds
+// when it happened. Each killer of a death has its own row in
+// `CharacterDeaths`, so a death with several killers from the same guild is
+// only stored once.
+func RecordGuildWarFrags(War *TGuildWar, End int) {
+	if g_NewsDb == nil {
+		return
+	}
+
+	// NOTE: Guilds founded through the website don't have a member row for
+	// the leader, so the leader is added to the members of each side.
+	for _, Side := range [][2]int{{War.GuildID, War.EnemyID}, {War.EnemyID, War.GuildID}} {
+		Killers, Victims := Side[0], Side[1]
+		_, Err := g_NewsDb.Exec(`
+			INSERT OR IGNORE INTO GuildWarFrags (WarID, GuildID, CharacterID, Timestamp)
+			SELECT DISTINCT ?, ?, d.CharacterID, d.Timestamp
+			FROM CharacterDeaths d
+			JOIN (SELECT CharacterID, Joined FROM GuildMembers WHERE GuildID = ?
+				UNION SELECT LeaderID, 0 FROM Guilds WHERE GuildID = ?) k
+				ON k.CharacterID = d.OffenderID AND k.Joined <= d.Timestamp
+			JOIN (SELECT CharacterID, Joined FROM GuildMembers WHERE GuildID = ?
+				UNION SELECT LeaderID, 0 FROM Guilds WHERE GuildID = ?) v
+				ON v.CharacterID = d.CharacterID AND v.Joined <= d.Timestamp
+			WHERE d.Timestamp >= ? AND d.Timestamp < ?
+		`, War.WarID, Killers, Killers, Killers, Victims, Victims, War.Started, End)
+		if Err != nil {
+			g_LogErr.Printf("Failed to record guild war frags: %v", Err)
+		}
+	}
+}
+
+// CountGuildWarScore fills in the frags of both sides of a war up to and
+// including `Last`.
+func CountGuildWarScore(War *TGuildWar, Last int) {
+	if g_NewsDb == nil {
+		return
+	}
+
+	Err := g_NewsDb.QueryRow(`
+		SELECT COALESCE(SUM(GuildID = ?), 0), COALESCE(SUM(GuildID = ?), 0)
+		FROM GuildWarFrags WHERE WarID = ? AND Timestamp <= ?
+	`, War.GuildID, War.EnemyID, War.WarID, Last).Scan(&War.GuildFrags, &War.EnemyFrags)
+	if Err != nil {
+		g_LogErr.Printf("Failed to count guild war frags: %v", Err)
+	}
+}
+
+// GetGuildWarLimitTime returns when either side of a war scored the frag that
+// reached the frag limit, or zero if neither did.
+func GetGuildWarLimitTime(War *TGuildWar) int {
+	if g_NewsDb == nil {
+		return 0
+	}
+
+	LimitTime := 0
+	for _, GuildID := range []int{War.GuildID, War.EnemyID} {
+		var Timestamp int
+		Err := g_NewsDb.QueryRow(`
+			SELECT Timestamp FROM GuildWarFrags WHERE WarID = ? AND GuildID = ?
+			ORDER BY Timestamp ASC LIMIT 1 OFFSET ?
+		`, War.WarID, GuildID, War.FragLimit-1).Scan(&Timestamp)
+		if Err == sql.ErrNoRows {
+			continue
+		} else if Err != nil {
+			g_LogErr.Printf("Failed to query guild war frags: %v", Err)
+			continue
+		}
+
+		if LimitTime == 0 || Timestamp < LimitTime {
+			LimitTime = Timestamp
+		}
+	}
+	return LimitTime
+}
+
+func QueryGuildWars(Query string, Args ...any) []TGuildWar {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT WarID, GuildID, GuildName, EnemyID, EnemyName, Status, FragLimit,
+			Duration, Wager, Declared, Started, Ended, GuildFrags, EnemyFrags, WinnerID
+		FROM GuildWars
+	`+Query, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild wars: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Wars []TGuildWar
+	for Rows.Next() {
+		var War TGuildWar
+		if Err := Rows.Scan(&War.WarID, &War.GuildID, &War.GuildName, &War.EnemyID,
+			&War.EnemyName, &War.Status, &War.FragLimit, &War.Duration, &War.Wager,
+			&War.Declared, &War.Started, &War.Ended, &War.GuildFrags, &War.EnemyFrags,
+			&War.WinnerID); Err != nil {
+			g_LogErr.Printf("Failed to scan guild war: %v", Err)
+			continue
+		}
+		Wars = append(Wars, War)
+	}
+	return Wars
+}
+
+// GetGuildWars returns the pending and active wars of a guild along with its
+// latest finished ones.
+func GetGuildWars(GuildID int) []TGuildWar {
+	return QueryGuildWars(`
+		WHERE (GuildID = ? OR EnemyID = ?)
+			AND (Status IN (?, ?) OR WarID IN (
+				SELECT WarID FROM GuildWars
+				WHERE (GuildID = ? OR EnemyID = ?) AND Status NOT IN (?, ?)
+				ORDER BY Declared DESC LIMIT ?))
+		ORDER BY Status != ?, Status != ?, Declared DESC
+	`, GuildID, GuildID, GUILD_WAR_PENDING, GUILD_WAR_ACTIVE,
+		GuildID, GuildID, GUILD_WAR_PENDING, GUILD_WAR_ACTIVE, GUILD_WAR_HISTORY_MAX,
+		GUILD_WAR_ACTIVE, GUILD_WAR_PENDING)
+}
+
+// GetActiveGuildWars returns every war that is being fought.
+func GetActiveGuildWars() []TGuildWar {
+	return QueryGuildWars(`
+		WHERE Status = ? ORDER BY Started DESC
+	`, GUILD_WAR_ACTIVE)
+}
+
+// GetEndedGuildWars returns the wars that were fought to the end, newest
+// first. Rejected and cancelled declarations are left out.
+func GetEndedGuildWars(Limit int) []TGuildWar {
+	return QueryGuildWars(`
+		WHERE Status = ? ORDER BY Ended DESC LIMIT ?
+	`, GUILD_WAR_ENDED, Limit)
+}
+
+func GetGuildWar(WarID int) *TGuildWar {
+	Wars := QueryGuildWars(`WHERE WarID = ?`, WarID)
+	if len(Wars) == 0 {
+		return nil
+	}
+	return &Wars[0]
+}
+
+// DeclareGuildWar proposes a war with the given terms, which the enemy leader
+// has to accept before it starts. The wager is only recorded, paying it is up
+// to the guilds.
+func DeclareGuildWar(GuildID int, EnemyID int, FragLimit int, Days int, Wager int) int {
+	if g_NewsDb == nil {
+		return GUILD_WAR_ERROR
+	}
+
+	if GuildID == EnemyID {
+		return GUILD_WAR_SAME_GUILD
+	}
+
+	if FragLimit < 1 || FragLimit > GUILD_WAR_MAX_FRAG_LIMIT ||
+		Days < 1 || Days > GUILD_WAR_MAX_DAYS ||
+		Wager < 0 || Wager > GUILD_WAR_MAX_WAGER {
+		return GUILD_WAR_BAD_TERMS
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return GUILD_WAR_ERROR
+	}
+	defer Tx.Rollback()
+
+	var GuildName, EnemyName string
+	Err = Tx.QueryRow(`SELECT Name FROM Guilds WHERE GuildID = ?`, GuildID).Scan(&GuildName)
+	if Err == nil {
+		Err = Tx.QueryRow(`SELECT Name FROM Guilds WHERE GuildID = ?`, EnemyID).Scan(&EnemyName)
+	}
+	if Err == sql.ErrNoRows {
+		return GUILD_WAR_NOT_FOUND
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query guild: %v", Err)
+		return GUILD_WAR_ERROR
+	}
+
+	var Count int
+	Err = Tx.QueryRow(`
+		SELECT COUNT(*) FROM GuildWars
+		WHERE ((GuildID = ? AND EnemyID = ?) OR (GuildID = ? AND EnemyID = ?))
+			AND Status IN (?, ?)
+	`, GuildID, EnemyID, EnemyID, GuildID, GUILD_WAR_PENDING, GUILD_WAR_ACTIVE).Scan(&Count)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query guild wars: %v", Err)
+		return GUILD_WAR_ERROR
+	} else if Count > 0 {
+		return GUILD_WAR_ALREADY_AT_WAR
+	}
+
+	_, Err = Tx.Exec(`
+		INSERT INTO GuildWars (GuildID, GuildName, EnemyID, EnemyName, Status,
+			FragLimit, Duration, Wager, Declared)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, GuildID, GuildName, EnemyID, EnemyName, GUILD_WAR_PENDING, FragLimit,
+		Days*24*60*60, Wager, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert guild war: %v", Err)
+		return GUILD_WAR_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit guild war: %v", Err)
+		return GUILD_WAR_ERROR
+	}
+	return GUILD_WAR_OK
+}
+
+// AnswerGuildWar starts or rejects a war declared on `EnemyID`.
+func AnswerGuildWar(WarID int, EnemyID int, Accept bool) int {
+	Status := GUILD_WAR_REJECTED
+	Column := "Ended"
+	if Accept {
+		Status = GUILD_WAR_ACTIVE
+		Column = "Started"
+	}
+	return UpdatePendingGuildWar(WarID, `EnemyID = ?`, EnemyID, Status, Column)
+}
+
+// CancelGuildWar withdraws a declaration that wasn't answered yet.
+func CancelGuildWar(WarID int, GuildID int) int {
+	return UpdatePendingGuildWar(WarID, `GuildID = ?`, GuildID,
+		GUILD_WAR_CANCELLED, "Ended")
+}
+
+func UpdatePendingGuildWar(WarID int, Condition string, GuildID int, Status string, Column string) int {
+	if g_NewsDb == nil {
+		return GUILD_WAR_ERROR
+	}
+
+	Result, Err := g_NewsDb.Exec(`
+		UPDATE GuildWars SET Status = ?, `+Column+` = ?
+		WHERE WarID = ? AND Status = ? AND `+Condition,
+		Status, time.Now().Unix(), WarID, GUILD_WAR_PENDING, GuildID)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update guild war: %v", Err)
+		return GUILD_WAR_ERROR
+	}
+
+	if Affected, _ := Result.RowsAffected(); Affected == 0 {
+		return GUILD_WAR_NOT_PENDING
+	}
+	return GUILD_WAR_OK
+}
+
+// EndGuildWar stores the final score of a war and its winner, which is zero
+// on a draw.
+func EndGuildWar(War *TGuildWar, Ended int) {
+	War.Status = GUILD_WAR_ENDED
+	War.Ended = Ended
+	War.WinnerID = 0
+	if War.GuildFrags > War.EnemyFrags {
+		War.WinnerID = War.GuildID
+	} else if War.EnemyFrags > War.GuildFrags {
+		War.WinnerID = War.EnemyID
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		UPDATE GuildWars SET Status = ?, Ended = ?, GuildFrags = ?, EnemyFrags = ?, WinnerID = ?
+		WHERE WarID = ? AND Status = ?
+	`, War.Status, War.Ended, War.GuildFrags, War.EnemyFrags, War.WinnerID,
+		War.WarID, GUILD_WAR_ACTIVE)
+	if Err != nil {
+		g_LogErr.Printf("Failed to end guild war %v: %v", War.WarID, Err)
+		return
+	}
+
+	g_Log.Printf("Guild war %v between %v and %v ended %v:%v", War.WarID,
+		War.GuildName, War.EnemyName, War.GuildFrags, War.EnemyFrags)
+}
+
+// UpdateGuildWars stores the frags of active wars and ends the ones that
+// reached their frag limit or duration. A war that reached its frag limit ends
+// with the frag that reached it. Members of a disbanded guild can't be told
+// apart anymore, so its wars end with the frags stored until then and
+// declarations to or from it are cancelled.
+func UpdateGuildWars() {
+	if g_NewsDb == nil {
+		return
+	}
+
+	Now := int(time.Now().Unix())
+	_, Err := g_NewsDb.Exec(`
+		UPDATE GuildWars SET Status = ?, Ended = ?
+		WHERE Status = ? AND (GuildID NOT IN (SELECT GuildID FROM Guilds)
+			OR EnemyID NOT IN (SELECT GuildID FROM Guilds))
+	`, GUILD_WAR_CANCELLED, Now, GUILD_WAR_PENDING)
+	if Err != nil {
+		g_LogErr.Printf("Failed to cancel guild wars: %v", Err)
+	}
+
+	Disbanded := make(map[int]bool)
+	for _, War := range QueryGuildWars(`
+		WHERE Status = ? AND (GuildID NOT IN (SELECT GuildID FROM Guilds)
+			OR EnemyID NOT IN (SELECT GuildID FROM Guilds))
+	`, GUILD_WAR_ACTIVE) {
+		Disbanded[War.WarID] = true
+	}
+
+	for _, War := range QueryGuildWars(`WHERE Status = ?`, GUILD_WAR_ACTIVE) {
+		Deadline := War.Deadline()
+		if !Disbanded[War.WarID] {
+			RecordGuildWarFrags(&War, min(Now+1, Deadline))
+		}
+
+		Ended := GetGuildWarLimitTime(&War)
+		if Ended == 0 && (Disbanded[War.WarID] || Now >= Deadline) {
+			Ended = min(Now, Deadline)
+		}
+
+		if Ended != 0 {
+			CountGuildWarScore(&War, Ended)
+			EndGuildWar(&War, Ended)
+			continue
+		}
+
+		CountGuildWarScore(&War, Now)
+		_, Err := g_NewsDb.Exec(`
+			UPDATE GuildWars SET GuildFrags = ?, EnemyFrags = ? WHERE WarID = ?
+		`, War.GuildFrags, War.EnemyFrags, War.WarID)
+		if Err != nil {
+			g_LogErr.Printf("Failed to update guild war score: %v", Err)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+type (
+	THighscoreSnapshot struct {
+		Day   int
+		Rank  int
+		Value int
+	}
+
+	TCharacterHighscore struct {
+		Skill       string
+		Rank        int
+		Value       int
+		Day         int
+		WeekAgoRank int
+		BestRank    int
+		History     []THighscoreSnapshot
+	}
+
+	THighscoreGainer struct {
+		CharacterName string
+		Gain          int
+		Rank          int
+		RankDelta     int
+	}
+)
+
+const (
+	HIGHSCORE_HISTORY_MAX = 30
+)
+
+var (
+	g_HighscoreSkills = []string{
+		"level", "magic", "fist", "club", "sword",
+		"axe", "distance", "shielding", "fishing",
+	}
+)
+
+// Date returns the day of the snapshot, which is taken in server time.
+func (Snapshot THighscoreSnapshot) Date() string {
+	return time.Unix(int64(Snapshot.Day), 0).Format("Jan 02 2006")
+}
+
+func InitHighscores() bool {
+	g_Log.Printf("HighscoreSnapshotRetention: %v", g_HighscoreSnapshotRetention)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HighscoreSnapshots (
+			World TEXT NOT NULL COLLATE NOCASE,
+			Skill TEXT NOT NULL,
+			Day INTEGER NOT NULL,
+			CharacterName TEXT NOT NULL COLLATE NOCASE,
+			Rank INTEGER NOT NULL,
+			Value INTEGER NOT NULL,
+			PRIMARY KEY (World, Skill, Day, CharacterName)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create highscore snapshots table: %v", Err)
+		return false
+	}
+
+	// NOTE: The job runs every hour but only takes one snapshot per day, so a
+	// restart doesn't skip or duplicate a day.
+	StartJob("highscore snapshots", time.Hour, SnapshotHighscores)
+	return true
+}
+
+func ExitHighscores() {
+	// no-op
+}
+
+func SnapshotHighscores() {
+	Today := StartOfDay(time.Now())
+	for _, World := range GetWorlds() {
+		for _, Skill := range g_HighscoreSkills {
+			var Count int
+			Err := g_NewsDb.QueryRow(`
+				SELECT COUNT(*) FROM HighscoreSnapshots
+				WHERE World = ? AND Skill = ? AND Day = ?
+			`, World.Name, Skill, Today).Scan(&Count)
+			if Err != nil {
+				g_LogErr.Printf("Failed to query highscore snapshots: %v", Err)
+				return
+			}
+
+			if Count > 0 {
+				continue
+			}
+
+			Highscores := GetHighscores(World.Name, Skill, "")
+			if len(Highscores) == 0 {
+				continue
+			}
+
+			if !InsertHighscoreSnapshot(World.Name, Skill, Today, Highscores) {
+				return
+			}
+		}
+	}
+
+	if g_HighscoreSnapshotRetention > 0 {
+		_, Err := g_NewsDb.Exec(`
+			DELETE FROM HighscoreSnapshots WHERE Day < ?
+		`, time.Now().Add(-g_HighscoreSnapshotRetention).Unix())
+		if Err != nil {
+			g_LogErr.Printf("Failed to delete old highscore snapshots: %v", Err)
+		}
+	}
+}
+
+func InsertHighscoreSnapshot(World string, Skill string, Day int, Highscores []THighscore) bool {
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return false
+	}
+	defer Tx.Rollback()
+
+	for _, Highscore := range Highscores {
+		_, Err := Tx.Exec(`
+			INSERT OR REPLACE INTO HighscoreSnapshots
+				(World, Skill, Day, CharacterName, Rank, Value)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, World, Skill, Day, Highscore.CharacterName, Highscore.Rank, Highscore.SkillValue)
+		if Err != nil {
+			g_LogErr.Printf("Failed to insert highscore snapshot: %v", Err)
+			return false
+		}
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit highscore snapshot: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+// GetPreviousHighscoreRanks returns the ranks from the latest snapshot taken
+// before today, indexed by lowercase character name. Today's snapshot is just
+// the current list so it isn't useful for comparisons.
+func GetPreviousHighscoreRanks(World string, Skill string) map[string]int {
+	Ranks := make(map[string]int)
+	if g_NewsDb == nil {
+		return Ranks
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT CharacterName, Rank FROM HighscoreSnapshots
+		WHERE World = ? AND Skill = ? AND Day = (
+			SELECT MAX(Day) FROM HighscoreSnapshots
+			WHERE World = ? AND Skill = ? AND Day < ?)
+	`, World, Skill, World, Skill, StartOfDay(time.Now()))
+	if Err != nil {
+		g_LogErr.Printf("Failed to query previous highscore ranks: %v", Err)
+		return Ranks
+	}
+	defer Rows.Close()
+
+	for Rows.Next() {
+		var Name string
+		var Rank int
+		if Err := Rows.Scan(&Name, &Rank); Err != nil {
+			g_LogErr.Printf("Failed to scan highscore snapshot: %v", Err)
+			continue
+		}
+		Ranks[strings.ToLower(Name)] = Rank
+	}
+	return Ranks
+}
+
+// AnnotateHighscores fills in rank movement since the previous snapshot. It
+// does nothing if there is no previous snapshot, so the first day doesn't
+// mark everyone as a new entry.
+func AnnotateHighscores(Highscores []THighscore, PreviousRanks map[string]int) {
+	if len(PreviousRanks) == 0 {
+		return
+	}
+
+	for Index := range Highscores {
+		Highscore := &Highscores[Index]
+		if Rank, Ok := PreviousRanks[strings.ToLower(Highscore.CharacterName)]; Ok {
+			Highscore.RankDelta = Rank - Highscore.Rank
+		} else {
+			Highscore.NewEntry = true
+		}
+	}
+}
+
+// GetCharacterHighscores returns the current rank of a character in each
+// highscore list along with its daily snapshots, oldest first.
+func GetCharacterHighscores(World string, CharacterName string) []TCharacterHighscore {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT Skill, Day, Rank, Value FROM HighscoreSnapshots
+		WHERE World = ? AND CharacterName = ?
+		ORDER BY Day ASC
+	`, World, CharacterName)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query character highscores: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	Snapshots := make(map[string][]THighscoreSnapshot)
+	for Rows.Next() {
+		var Skill string
+		var Snapshot THighscoreSnapshot
+		if Err := Rows.Scan(&Skill, &Snapshot.Day, &Snapshot.Rank, &Snapshot.Value); Err != nil {
+			g_LogErr.Printf("Failed to scan highscore snapshot: %v", Err)
+			continue
+		}
+		Snapshots[Skill] = append(Snapshots[Skill], Snapshot)
+	}
+
+	History := make(map[string]*TCharacterHighscore)
+	for Skill, List := range Snapshots {
+		Last := List[len(List)-1]
+		Entry := &TCharacterHighscore{
+			Skill:    Skill,
+			Rank:     Last.Rank,
+			Value:    Last.Value,
+			Day:      Last.Day,
+			BestRank: Last.Rank,
+			History:  SampleHighscoreSnapshots(List, HIGHSCORE_HISTORY_MAX),
+		}
+
+		WeekAgo := int(time.Unix(int64(Last.Day), 0).AddDate(0, 0, -7).Unix())
+		for _, Snapshot := range List {
+			Entry.BestRank = min(Entry.BestRank, Snapshot.Rank)
+			if Snapshot.Day <= WeekAgo {
+				Entry.WeekAgoRank = Snapshot.Rank
+			}
+		}
+		History[Skill] = Entry
+	}
+
+	// NOTE: Characters drop off snapshots when they fall out of the list, so
+	// only skills where the character is still ranked are shown.
+	Latest := StartOfDay(time.Now().AddDate(0, 0, -1))
+	var Result []TCharacterHighscore
+	for _, Skill := range g_HighscoreSkills {
+		if Entry := History[Skill]; Entry != nil && Entry.Day >= Latest {
+			Result = append(Result, *Entry)
+		}
+	}
+	return Result
+}
+
+// SampleHighscoreSnapshots picks at most `Max` snapshots spread evenly over the
+// list, always keeping the first and the last one.
+func SampleHighscoreSnapshots(List []THighscoreSnapshot, Max int) []THighscoreSnapshot {
+	if len(List) <= Max {
+		return List
+	}
+
+	Sample := make([]THighscoreSnapshot, 0, Max)
+	for Index := 0; Index < Max; Index++ {
+		Sample = append(Sample, List[Index*(len(List)-1)/(Max-1)])
+	}
+	return Sample
+}
+
+// GetHighscoreGainers compares the latest snapshot of a highscore list with
+// the oldest one from the previous week and returns the characters that gained
+// the most.
+func GetHighscoreGainers(World string, Skill string, MaxGainers int) []THighscoreGainer {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	var Latest int
+	Err := g_NewsDb.QueryRow(`
+		SELECT COALESCE(MAX(Day), 0) FROM HighscoreSnapshots
+		WHERE World = ? AND Skill = ?
+	`, World, Skill).Scan(&Latest)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query highscore snapshots: %v", Err)
+		return nil
+	}
+
+	if Latest == 0 {
+		return nil
+	}
+
+	var Oldest int
+	Err = g_NewsDb.QueryRow(`
+		SELECT COALESCE(MIN(Day), 0) FROM HighscoreSnapshots
+		WHERE World = ? AND Skill = ? AND Day >= ? AND Day < ?
+	`, World, Skill, time.Unix(int64(Latest), 0).AddDate(0, 0, -7).Unix(), Latest).Scan(&Oldest)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query highscore snapshots: %v", Err)
+		return nil
+	}
+
+	if Oldest == 0 {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT n.CharacterName, n.Value - o.Value AS Gain, n.Rank, o.Rank - n.Rank
+		FROM HighscoreSnapshots n
+		JOIN HighscoreSnapshots o
+			ON o.World = n.World AND o.Skill = n.Skill
+			AND o.CharacterName = n.CharacterName AND o.Day = ?
+		WHERE n.World = ? AND n.Skill = ? AND n.Day = ? AND n.Value > o.Value
+		ORDER BY Gain DESC, n.Rank ASC
+		LIMIT ?
+	`, Oldest, World, Skill, Latest, MaxGainers)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query highscore gainers: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Gainers []THighscoreGainer
+	for Rows.Next() {
+		var Gainer THighscoreGainer
+		if Err := Rows.Scan(&Gainer.CharacterName, &Gainer.Gain, &Gainer.Rank, &Gainer.RankDelta); Err != nil {
+			g_LogErr.Printf("Failed to scan highscore gainer: %v", Err)
+			continue
+		}
+		Gainers = append(Gainers, Gainer)
+	}
+	return Gainers
+}
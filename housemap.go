@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NOTE: The game server map is split into sector files of 32x32 tiles named
+// after the sector coordinates (e.g. `1000-1000-07.sec`), one tile per line:
+//
+//	12-4: Refresh, Content={102, 2463}
+//
+// House fields aren't part of the sectors, they come from the `houses.dat`
+// file the game server uses to define each house. There is a single map and
+// house data file for every world, same as the game server ships them.
+const (
+	HOUSE_MAP_SECTOR_SIZE = 32
+	HOUSE_MAP_TILE_SIZE   = 8
+	HOUSE_MAP_MARGIN      = 6
+	HOUSE_MAP_MAX_TILES   = 64
+)
+
+type (
+	TMapPosition struct {
+		X int
+		Y int
+		Z int
+	}
+
+	TMapTile struct {
+		Ground     int
+		HasObjects bool
+	}
+
+	TMapSector struct {
+		Tiles [HOUSE_MAP_SECTOR_SIZE][HOUSE_MAP_SECTOR_SIZE]*TMapTile
+	}
+)
+
+var (
+	g_HouseMapMutex   sync.Mutex
+	g_HouseMapEnabled bool
+	g_HouseFields     map[int][]TMapPosition
+	g_MapSectors      map[TMapPosition]*TMapSector
+	g_HouseMapCache   map[int][]byte
+
+	g_HouseIDPattern       = regexp.MustCompile(`\bID\s*=\s*(\d+)`)
+	g_HouseFieldsPattern   = regexp.MustCompile(`\bFields\s*=\s*\{([^}]*)\}`)
+	g_HousePositionPattern = regexp.MustCompile(`\[\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*\]`)
+
+	// NOTE: Sector files only have object type ids, which don't say what an
+	// object looks like, so grounds get a color from this palette based on
+	// their type. It's enough to tell apart floors, grass and water.
+	g_MapGroundPalette = []color.RGBA{
+		{0x4a, 0x6b, 0x35, 0xff},
+		{0x5c, 0x7a, 0x3e, 0xff},
+		{0x6e, 0x5a, 0x3c, 0xff},
+		{0x80, 0x6a, 0x48, 0xff},
+		{0x77, 0x77, 0x77, 0xff},
+		{0x8a, 0x84, 0x78, 0xff},
+		{0x33, 0x5c, 0x8a, 0xff},
+		{0x9a, 0x8c, 0x62, 0xff},
+	}
+	g_MapEmptyColor    = color.RGBA{0x11, 0x11, 0x11, 0xff}
+	g_MapHouseColor    = color.RGBA{0xc9, 0xa8, 0x6a, 0xff}
+	g_MapEntranceColor = color.RGBA{0xd9, 0x4f, 0x3c, 0xff}
+)
+
+func InitHouseMaps() bool {
+	g_Log.Printf("MapDirectory: %v", g_MapDirectory)
+	g_Log.Printf("HouseDataFile: %v", g_HouseDataFile)
+
+	// NOTE: House maps are cosmetic, same as outfit previews, so missing map
+	// data only disables them.
+	if Info, Err := os.Stat(g_MapDirectory); Err != nil || !Info.IsDir() {
+		g_LogWarn.Printf("House maps disabled: map directory \"%v\" not found", g_MapDirectory)
+		return true
+	}
+
+	Fields, Err := LoadHouseFields(g_HouseDataFile)
+	if Err != nil {
+		g_LogWarn.Printf("House map fields disabled: %v", Err)
+	}
+
+	g_HouseFields = Fields
+	g_MapSectors = make(map[TMapPosition]*TMapSector)
+	g_HouseMapCache = make(map[int][]byte)
+	g_HouseMapEnabled = true
+	return true
+}
+
+func ExitHouseMaps() {
+	g_HouseMapEnabled = false
+	g_HouseFields = nil
+	g_MapSectors = nil
+	g_HouseMapCache = nil
+}
+
+func HouseMapsEnabled() bool {
+	return g_HouseMapEnabled
+}
+
+// LoadHouseFields reads the fields of each house from the game server's house
+// data file, which is a list of `Key = Value` entries where each house starts
+// with its `ID`.
+func LoadHouseFields(FileName string) (map[int][]TMapPosition, error) {
+	Data, Err := os.ReadFile(FileName)
+	if Err != nil {
+		return nil, Err
+	}
+
+	Fields := make(map[int][]TMapPosition)
+	Text := string(Data)
+	IDMatches := g_HouseIDPattern.FindAllStringSubmatchIndex(Text, -1)
+	for Index, Match := range IDMatches {
+		HouseID, _ := strconv.Atoi(Text[Match[2]:Match[3]])
+		End := len(Text)
+		if Index+1 < len(IDMatches) {
+			End = IDMatches[Index+1][0]
+		}
+
+		FieldsMatch := g_HouseFieldsPattern.FindStringSubmatch(Text[Match[1]:End])
+		if FieldsMatch == nil {
+			continue
+		}
+
+		for _, Position := range g_HousePositionPattern.FindAllStringSubmatch(FieldsMatch[1], -1) {
+			X, _ := strconv.Atoi(Position[1])
+			Y, _ := strconv.Atoi(Position[2])
+			Z, _ := strconv.Atoi(Position[3])
+			Fields[HouseID] = append(Fields[HouseID], TMapPosition{X, Y, Z})
+		}
+	}
+	return Fields, nil
+}
+
+func LoadMapSector(FileName string) (*TMapSector, error) {
+	File, Err := os.Open(FileName)
+	if Err != nil {
+		return nil, Err
+	}
+	defer File.Close()
+
+	Sector := &TMapSector{}
+	Scanner := bufio.NewScanner(File)
+	Scanner.Buffer(nil, 1024*1024)
+	for LineNumber := 1; Scanner.Scan(); LineNumber += 1 {
+		Line := strings.TrimSpace(Scanner.Text())
+		if len(Line) == 0 || Line[0] == '#' {
+			continue
+		}
+
+		Offset, Attributes, Ok := strings.Cut(Line, ":")
+		OffsetX, OffsetY, OffsetOk := strings.Cut(Offset, "-")
+		if !Ok || !OffsetOk {
+			g_LogWarn.Printf("%v:%v: invalid tile \"%v\"", FileName, LineNumber, Line)
+			continue
+		}
+
+		X, ErrX := strconv.Atoi(strings.TrimSpace(OffsetX))
+		Y, ErrY := strconv.Atoi(strings.TrimSpace(OffsetY))
+		if ErrX != nil || ErrY != nil || X < 0 || X >= HOUSE_MAP_SECTOR_SIZE ||
+			Y < 0 || Y >= HOUSE_MAP_SECTOR_SIZE {
+			g_LogWarn.Printf("%v:%v: invalid tile offset \"%v\"", FileName, LineNumber, Offset)
+			continue
+		}
+
+		// NOTE: The first object is the ground and anything after it means
+		// there is something on the tile, like a wall or furniture.
+		Tile := &TMapTile{}
+		if _, Content, Found := strings.Cut(Attributes, "Content={"); Found {
+			End := strings.IndexFunc(Content, func(Rune rune) bool {
+				return Rune < '0' || Rune > '9'
+			})
+			if End < 0 {
+				End = len(Content)
+			}
+			Tile.Ground, _ = strconv.Atoi(Content[:End])
+			Tile.HasObjects = strings.HasPrefix(strings.TrimSpace(Content[End:]), ",")
+		}
+		Sector.Tiles[X][Y] = Tile
+	}
+
+	if Err := Scanner.Err(); Err != nil {
+		return nil, Err
+	}
+	return Sector, nil
+}
+
+// GetMapTile returns the tile at a position or nil if there is none. Sectors
+// are loaded the first time they're needed, and missing ones are remembered
+// so they aren't looked up again. Expects `g_HouseMapMutex` to be held.
+func GetMapTile(Position TMapPosition) *TMapTile {
+	if Position.X < 0 || Position.Y < 0 {
+		return nil
+	}
+
+	Key := TMapPosition{
+		X: Position.X / HOUSE_MAP_SECTOR_SIZE,
+		Y: Position.Y / HOUSE_MAP_SECTOR_SIZE,
+		Z: Position.Z,
+	}
+
+	Sector, Ok := g_MapSectors[Key]
+	if !Ok {
+		FileName := filepath.Join(g_MapDirectory, fmt.Sprintf("%04d-%04d-%02d.sec", Key.X, Key.Y, Key.Z))
+		var Err error
+		Sector, Err = LoadMapSector(FileName)
+		if Err != nil && !os.IsNotExist(Err) {
+			g_LogErr.Printf("Failed to load map sector: %v", Err)
+		}
+		g_MapSectors[Key] = Sector
+	}
+
+	if Sector == nil {
+		return nil
+	}
+	return Sector.Tiles[Position.X%HOUSE_MAP_SECTOR_SIZE][Position.Y%HOUSE_MAP_SECTOR_SIZE]
+}
+
+func MapGroundColor(Tile *TMapTile) color.RGBA {
+	if Tile == nil {
+		return g_MapEmptyColor
+	}
+
+	Hash := fnv.New32a()
+	Hash.Write([]byte(strconv.Itoa(Tile.Ground)))
+	Color := g_MapGroundPalette[Hash.Sum32()%uint32(len(g_MapGroundPalette))]
+	if Tile.HasObjects {
+		Color = ShadeMapColor(Color, 60)
+	}
+	return Color
+}
+
+// ShadeMapColor scales a color by `Percent`.
+func ShadeMapColor(Color color.RGBA, Percent int) color.RGBA {
+	return color.RGBA{
+		R: uint8(int(Color.R) * Percent / 100),
+		G: uint8(int(Color.G) * Percent / 100),
+		B: uint8(int(Color.B) * Percent / 100),
+		A: Color.A,
+	}
+}
+
+func BlendMapColor(A color.RGBA, B color.RGBA) color.RGBA {
+	return color.RGBA{
+		R: uint8((int(A.R) + int(B.R)*2) / 3),
+		G: uint8((int(A.G) + int(B.G)*2) / 3),
+		B: uint8((int(A.B) + int(B.B)*2) / 3),
+		A: 0xff,
+	}
+}
+
+// RenderHouseMapImage draws the floor of `Entrance` around the house fields,
+// highlighting the fields and marking the entrance. Expects `g_HouseMapMutex`
+// to be held.
+func RenderHouseMapImage(Entrance TMapPosition, Fields []TMapPosition) *image.RGBA {
+	MinX, MinY, MaxX, MaxY := Entrance.X, Entrance.Y, Entrance.X, Entrance.Y
+	Highlight := make(map[TMapPosition]bool, len(Fields))
+	for _, Field := range Fields {
+		if Field.Z != Entrance.Z {
+			continue
+		}
+		Highlight[Field] = true
+		MinX, MinY = min(MinX, Field.X), min(MinY, Field.Y)
+		MaxX, MaxY = max(MaxX, Field.X), max(MaxY, Field.Y)
+	}
+
+	// NOTE: Really big houses are cropped around the entrance.
+	StartX, Width := MinX-HOUSE_MAP_MARGIN, MaxX-MinX+1+HOUSE_MAP_MARGIN*2
+	if Width > HOUSE_MAP_MAX_TILES {
+		StartX, Width = Entrance.X-HOUSE_MAP_MAX_TILES/2, HOUSE_MAP_MAX_TILES
+	}
+
+	StartY, Height := MinY-HOUSE_MAP_MARGIN, MaxY-MinY+1+HOUSE_MAP_MARGIN*2
+	if Height > HOUSE_MAP_MAX_TILES {
+		StartY, Height = Entrance.Y-HOUSE_MAP_MAX_TILES/2, HOUSE_MAP_MAX_TILES
+	}
+
+	Image := image.NewRGBA(image.Rect(0, 0, Width*HOUSE_MAP_TILE_SIZE, Height*HOUSE_MAP_TILE_SIZE))
+	for TileY := 0; TileY < Height; TileY += 1 {
+		for TileX := 0; TileX < Width; TileX += 1 {
+			Position := TMapPosition{StartX + TileX, StartY + TileY, Entrance.Z}
+			Color := MapGroundColor(GetMapTile(Position))
+			if Position == Entrance {
+				Color = g_MapEntranceColor
+			} else if Highlight[Position] {
+				Color = BlendMapColor(Color, g_MapHouseColor)
+			}
+
+			for Y := 0; Y < HOUSE_MAP_TILE_SIZE; Y += 1 {
+				for X := 0; X < HOUSE_MAP_TILE_SIZE; X += 1 {
+					Image.SetRGBA(TileX*HOUSE_MAP_TILE_SIZE+X, TileY*HOUSE_MAP_TILE_SIZE+Y, Color)
+				}
+			}
+		}
+	}
+	return Image
+}
+
+// RenderHouseMap returns a PNG of the area around a house. Renders are cached
+// since the map only changes when the server is restarted with a new one.
+func RenderHouseMap(House *THouse) ([]byte, bool) {
+	if !g_HouseMapEnabled || House == nil {
+		return nil, false
+	}
+
+	// NOTE: All worlds run the same map, so a house looks the same on each of
+	// them and renders are shared.
+	Key := House.HouseID
+	g_HouseMapMutex.Lock()
+	defer g_HouseMapMutex.Unlock()
+	if Data, Ok := g_HouseMapCache[Key]; Ok {
+		return Data, Data != nil
+	}
+
+	Entrance := TMapPosition{House.PositionX, House.PositionY, House.PositionZ}
+	if GetMapTile(Entrance) == nil {
+		g_LogWarn.Printf("House %v is outside of the map", House.HouseID)
+		g_HouseMapCache[Key] = nil
+		return nil, false
+	}
+
+	var Buffer bytes.Buffer
+	Image := RenderHouseMapImage(Entrance, g_HouseFields[House.HouseID])
+	if Err := png.Encode(&Buffer, Image); Err != nil {
+		g_LogErr.Printf("Failed to encode house map: %v", Err)
+		return nil, false
+	}
+
+	g_HouseMapCache[Key] = Buffer.Bytes()
+	return Buffer.Bytes(), true
+}
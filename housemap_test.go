@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderHouseMapFixture(t *testing.T) {
+	Fields, Err := LoadHouseFields("testdata/map/houses.dat")
+	if Err != nil {
+		t.Fatalf("Failed to load house fields: %v", Err)
+	}
+
+	HouseFields := Fields[10]
+	if len(HouseFields) != 12 {
+		t.Fatalf("House 10 has %v fields, expected 12", len(HouseFields))
+	}
+
+	Sector, Err := LoadMapSector("testdata/map/1000-1000-07.sec")
+	if Err != nil {
+		t.Fatalf("Failed to load map sector: %v", Err)
+	}
+
+	g_MapSectors = map[TMapPosition]*TMapSector{{1000, 1000, 7}: Sector}
+	defer func() { g_MapSectors = nil }()
+
+	Entrance := TMapPosition{32010, 32013, 7}
+	if GetMapTile(Entrance) == nil {
+		t.Fatalf("House 10 entrance is outside of the map")
+	}
+
+	Image := RenderHouseMapImage(Entrance, HouseFields)
+	Bounds := Image.Bounds()
+	if Bounds.Dx() != 16*HOUSE_MAP_TILE_SIZE || Bounds.Dy() != 17*HOUSE_MAP_TILE_SIZE {
+		t.Fatalf("Unexpected house map size %vx%v", Bounds.Dx(), Bounds.Dy())
+	}
+
+	// NOTE: The map starts `HOUSE_MAP_MARGIN` tiles before the first field at
+	// 32009,32009.
+	TilePixel := func(Position TMapPosition) (int, int) {
+		return (Position.X - 32009 + HOUSE_MAP_MARGIN) * HOUSE_MAP_TILE_SIZE,
+			(Position.Y - 32009 + HOUSE_MAP_MARGIN) * HOUSE_MAP_TILE_SIZE
+	}
+
+	if X, Y := TilePixel(Entrance); Image.RGBAAt(X, Y) != g_MapEntranceColor {
+		t.Errorf("Entrance has color %v, expected %v", Image.RGBAAt(X, Y), g_MapEntranceColor)
+	}
+
+	for _, Field := range HouseFields {
+		Expected := BlendMapColor(MapGroundColor(GetMapTile(Field)), g_MapHouseColor)
+		if X, Y := TilePixel(Field); Image.RGBAAt(X, Y) != Expected {
+			t.Errorf("Field %v has color %v, expected %v", Field, Image.RGBAAt(X, Y), Expected)
+		}
+	}
+
+	Outside := TMapPosition{32009 - HOUSE_MAP_MARGIN, 32009 - HOUSE_MAP_MARGIN, 7}
+	if X, Y := TilePixel(Outside); Image.RGBAAt(X, Y) != MapGroundColor(GetMapTile(Outside)) {
+		t.Errorf("Tile %v outside of the house is highlighted", Outside)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"time"
+)
+
+type (
+	THouseRentDue struct {
+		WorldID      int
+		World        string
+		HouseID      int
+		HouseName    string
+		Rent         int
+		OwnerID      int
+		OwnerName    string
+		AccountID    int
+		PaidUntil    int
+		ReminderSent bool
+	}
+)
+
+func InitHouseRent() bool {
+	g_Log.Printf("HouseRentReminderAdvance: %v", g_HouseRentReminderAdvance)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: Reminders are sent once per paid period, so `PaidUntil` is part of
+	// the key. Paying the rent moves it forward and allows a new reminder.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HouseRentReminders (
+			WorldID INTEGER NOT NULL,
+			HouseID INTEGER NOT NULL,
+			PaidUntil INTEGER NOT NULL,
+			OwnerID INTEGER NOT NULL,
+			Timestamp INTEGER NOT NULL,
+			PRIMARY KEY (WorldID, HouseID, PaidUntil)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house rent reminders table: %v", Err)
+		return false
+	}
+
+	// NOTE: Reminders are enabled for accounts without an entry here.
+	_, Err = g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HouseRentReminderSettings (
+			AccountID INTEGER NOT NULL,
+			Enabled INTEGER NOT NULL,
+			PRIMARY KEY (AccountID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house rent reminder settings table: %v", Err)
+		return false
+	}
+
+	StartJob("house rent reminders", 24*time.Hour, SendHouseRentReminders)
+	return true
+}
+
+func ExitHouseRent() {
+	// no-op
+}
+
+func GetHouseRentDue(Where string, Args ...any) []THouseRentDue {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Now := time.Now()
+	Args = append([]any{Now.Unix(), Now.Add(g_HouseRentReminderAdvance).Unix()}, Args...)
+	Rows, Err := g_NewsDb.Query(`
+		SELECT ho.WorldID, COALESCE(w.Name, ''), ho.HouseID, COALESCE(h.Name, ''),
+			COALESCE(h.Rent, 0), ho.OwnerID, c.Name, c.AccountID, ho.PaidUntil,
+			EXISTS (SELECT 1 FROM HouseRentReminders r
+				WHERE r.WorldID = ho.WorldID AND r.HouseID = ho.HouseID
+					AND r.PaidUntil = ho.PaidUntil)
+		FROM HouseOwners ho
+		JOIN Characters c ON c.CharacterID = ho.OwnerID
+		LEFT JOIN Houses h ON h.WorldID = ho.WorldID AND h.HouseID = ho.HouseID
+		LEFT JOIN Worlds w ON w.WorldID = ho.WorldID
+		WHERE ho.PaidUntil > ? AND ho.PaidUntil <= ? `+Where+`
+		ORDER BY ho.PaidUntil ASC
+	`, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house rent due: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Houses []THouseRentDue
+	for Rows.Next() {
+		var House THouseRentDue
+		if Err := Rows.Scan(&House.WorldID, &House.World, &House.HouseID, &House.HouseName,
+			&House.Rent, &House.OwnerID, &House.OwnerName, &House.AccountID,
+			&House.PaidUntil, &House.ReminderSent); Err != nil {
+			g_LogErr.Printf("Failed to scan house rent due: %v", Err)
+			continue
+		}
+		Houses = append(Houses, House)
+	}
+	return Houses
+}
+
+// GetUpcomingEvictions returns every house whose rent runs out within the
+// reminder window, soonest first.
+func GetUpcomingEvictions() []THouseRentDue {
+	return GetHouseRentDue("")
+}
+
+func GetAccountHouseRentDue(AccountID int) []THouseRentDue {
+	return GetHouseRentDue("AND c.AccountID = ?", AccountID)
+}
+
+func GetHouseRentRemindersEnabled(AccountID int) bool {
+	if g_NewsDb == nil {
+		return false
+	}
+
+	var Enabled bool
+	Err := g_NewsDb.QueryRow(`
+		SELECT Enabled FROM HouseRentReminderSettings WHERE AccountID = ?
+	`, AccountID).Scan(&Enabled)
+	if Err == sql.ErrNoRows {
+		return true
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query house rent reminder settings: %v", Err)
+		return true
+	}
+	return Enabled
+}
+
+func SetHouseRentRemindersEnabled(AccountID int, Enabled bool) int {
+	if g_NewsDb == nil {
+		return 1
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		INSERT INTO HouseRentReminderSettings (AccountID, Enabled)
+		VALUES (?, ?)
+		ON CONFLICT (AccountID) DO UPDATE SET Enabled = excluded.Enabled
+	`, AccountID, Enabled)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update house rent reminder settings: %v", Err)
+		return 1
+	}
+
+	return 0
+}
+
+// SendHouseRentReminders e-mails the owners of houses whose rent runs out
+// soon. A reminder is only recorded after the e-mail is sent, so failed ones
+// are tried again on the next run.
+func SendHouseRentReminders() {
+	for _, House := range GetUpcomingEvictions() {
+		if House.ReminderSent || !GetHouseRentRemindersEnabled(House.AccountID) {
+			continue
+		}
+
+		_, Email := GetCharacterAccountEmail(House.OwnerID)
+		if Email == "" {
+			continue
+		}
+
+		Subject := fmt.Sprintf("The rent of %v is due soon", House.HouseName)
+		Body := fmt.Sprintf("<p>The rent of %v, owned by %v on %v, is paid until %v."+
+			" Make sure there are at least %v gold in the bank or the house will be"+
+			" lost.</p><p>You can turn these reminders off on your account page.</p>",
+			html.EscapeString(House.HouseName), html.EscapeString(House.OwnerName),
+			html.EscapeString(House.World),
+			time.Unix(int64(House.PaidUntil), 0).UTC().Format("Jan 02 2006, 15:04:05 MST"),
+			House.Rent)
+		if Err := SendMail(Email, Subject, Body); Err != nil {
+			g_LogErr.Printf("Failed to send rent reminder e-mail to character %v: %v", House.OwnerID, Err)
+			continue
+		}
+
+		_, Err := g_NewsDb.Exec(`
+			INSERT OR IGNORE INTO HouseRentReminders
+				(WorldID, HouseID, PaidUntil, OwnerID, Timestamp)
+			VALUES (?, ?, ?, ?, ?)
+		`, House.WorldID, House.HouseID, House.PaidUntil, House.OwnerID, time.Now().Unix())
+		if Err != nil {
+			g_LogErr.Printf("Failed to insert house rent reminder: %v", Err)
+		}
+	}
+}
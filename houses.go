@@ -0,0 +1,856 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"time"
+)
+
+const (
+	HOUSE_BID_OK          = 0
+	HOUSE_BID_TOO_LOW     = 1
+	HOUSE_BID_NOT_ACTIVE  = 2
+	HOUSE_BID_ERROR       = 3
+	HOUSE_BID_HISTORY_MAX = 20
+)
+
+const (
+	HOUSE_ASSIGN_OK                 = 0
+	HOUSE_ASSIGN_NO_CHARACTER       = 1
+	HOUSE_ASSIGN_INSUFFICIENT_FUNDS = 2
+	HOUSE_ASSIGN_ALREADY_OWNED      = 3
+)
+
+const (
+	HOUSE_TRANSFER_OK                = 0
+	HOUSE_TRANSFER_NOT_OWNER         = 1
+	HOUSE_TRANSFER_INVALID_CHARACTER = 2
+	HOUSE_TRANSFER_SCHEDULED         = 3
+	HOUSE_TRANSFER_NOT_FOUND         = 4
+	HOUSE_TRANSFER_ERROR             = 5
+)
+
+type (
+	THouseBid struct {
+		CharacterName string
+		BidAmount     int
+		Timestamp     int
+	}
+
+	TFinishedHouseAuction struct {
+		WorldID    int
+		World      string
+		HouseID    int
+		BidderID   int
+		BidAmount  int
+		FinishTime int
+	}
+
+	THouseTransfer struct {
+		RequestID    int
+		WorldID      int
+		HouseID      int
+		HouseName    string
+		OwnerID      int
+		OwnerName    string
+		NewOwnerID   int
+		NewOwnerName string
+		Price        int
+		Created      int
+		MoveOut      bool
+		Accepted     bool
+	}
+
+	THouseAuctionCandidate struct {
+		CharacterID   int
+		CharacterName string
+		BidAmount     int
+	}
+)
+
+func InitHouses() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	// NOTE: `FinishTime` is the finish time of the auction the bid was placed
+	// on, which tells bids from different auctions of the same house apart.
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HouseBids (
+			BidID INTEGER PRIMARY KEY AUTOINCREMENT,
+			WorldID INTEGER NOT NULL,
+			HouseID INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			BidAmount INTEGER NOT NULL,
+			FinishTime INTEGER NOT NULL,
+			Timestamp INTEGER NOT NULL
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house bids table: %v", Err)
+		return false
+	}
+
+	_, Err = g_NewsDb.Exec(`
+		CREATE INDEX IF NOT EXISTS HouseBidsAuctionIndex
+			ON HouseBids (WorldID, HouseID, FinishTime)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house bids index: %v", Err)
+		return false
+	}
+
+	// NOTE: Every step of a settlement is recorded here, including bidders
+	// that couldn't pay and auctions that were restarted without a winner, in
+	// which case `CharacterID` is zero. There is at most one step per bidder
+	// so a settlement that is interrupted can pick up where it left off.
+	_, Err = g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HouseAuctionSettlements (
+			SettlementID INTEGER PRIMARY KEY AUTOINCREMENT,
+			WorldID INTEGER NOT NULL,
+			HouseID INTEGER NOT NULL,
+			FinishTime INTEGER NOT NULL,
+			CharacterID INTEGER NOT NULL,
+			BidAmount INTEGER NOT NULL,
+			Result TEXT NOT NULL,
+			Timestamp INTEGER NOT NULL
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house auction settlements table: %v", Err)
+		return false
+	}
+
+	_, Err = g_NewsDb.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS HouseAuctionSettlementsIndex
+			ON HouseAuctionSettlements (WorldID, HouseID, FinishTime, CharacterID)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house auction settlements index: %v", Err)
+		return false
+	}
+
+	// NOTE: Transfer requests wait here until the new owner accepts them. There
+	// is at most one per house, a new request replaces the previous one.
+	_, Err = g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS HouseTransferRequests (
+			RequestID INTEGER PRIMARY KEY AUTOINCREMENT,
+			WorldID INTEGER NOT NULL,
+			HouseID INTEGER NOT NULL,
+			OwnerID INTEGER NOT NULL,
+			NewOwnerID INTEGER NOT NULL,
+			Price INTEGER NOT NULL,
+			Created INTEGER NOT NULL,
+			UNIQUE (WorldID, HouseID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create house transfer requests table: %v", Err)
+		return false
+	}
+
+	g_Log.Printf("HouseAuctionSettlementInterval: %v", g_HouseAuctionSettlementInterval)
+	g_Log.Printf("HouseAuctionDuration: %v", g_HouseAuctionDuration)
+	StartJob("house auction settlement", g_HouseAuctionSettlementInterval, SettleHouseAuctions)
+	return true
+}
+
+func ExitHouses() {
+	// no-op
+}
+
+// PlaceHouseBid raises the bid on an active house auction. The auction row is
+// only updated if it still holds the bid that was read at the start of the
+// transaction, so two concurrent bidders can't both win: the second one will
+// either see the new bid or fail the compare-and-set.
+func PlaceHouseBid(WorldID int, HouseID int, CharacterID int, BidAmount int) int {
+	if g_NewsDb == nil {
+		return HOUSE_BID_ERROR
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return HOUSE_BID_ERROR
+	}
+	defer Tx.Rollback()
+
+	var PreviousBidderID, PreviousBid, FinishTime int
+	Err = Tx.QueryRow(`
+		SELECT COALESCE(BidderID, 0), COALESCE(BidAmount, 0), COALESCE(FinishTime, 0)
+		FROM HouseAuctions WHERE WorldID = ? AND HouseID = ?
+	`, WorldID, HouseID).Scan(&PreviousBidderID, &PreviousBid, &FinishTime)
+	if Err == sql.ErrNoRows {
+		return HOUSE_BID_NOT_ACTIVE
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query house auction %v: %v", HouseID, Err)
+		return HOUSE_BID_ERROR
+	}
+
+	Now := time.Now().Unix()
+	if int64(FinishTime) <= Now {
+		return HOUSE_BID_NOT_ACTIVE
+	}
+
+	if BidAmount <= PreviousBid {
+		return HOUSE_BID_TOO_LOW
+	}
+
+	Result, Err := Tx.Exec(`
+		UPDATE HouseAuctions SET BidderID = ?, BidAmount = ?
+		WHERE WorldID = ? AND HouseID = ? AND FinishTime > ?
+			AND COALESCE(BidderID, 0) = ? AND COALESCE(BidAmount, 0) = ?
+	`, CharacterID, BidAmount, WorldID, HouseID, Now, PreviousBidderID, PreviousBid)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update house auction %v: %v", HouseID, Err)
+		return HOUSE_BID_ERROR
+	}
+
+	if Affected, Err := Result.RowsAffected(); Err != nil || Affected != 1 {
+		// NOTE: Someone else got their bid in first.
+		return HOUSE_BID_TOO_LOW
+	}
+
+	_, Err = Tx.Exec(`
+		INSERT INTO HouseBids (WorldID, HouseID, CharacterID, BidAmount, FinishTime, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, WorldID, HouseID, CharacterID, BidAmount, FinishTime, Now)
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert house bid: %v", Err)
+		return HOUSE_BID_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit house bid: %v", Err)
+		return HOUSE_BID_ERROR
+	}
+
+	if PreviousBidderID != 0 && PreviousBidderID != CharacterID {
+		go NotifyHouseOutbid(WorldID, HouseID, PreviousBidderID, PreviousBid, BidAmount, FinishTime)
+	}
+
+	return HOUSE_BID_OK
+}
+
+// GetHouseBids returns the bids placed on the current auction of a house,
+// newest first.
+func GetHouseBids(WorldID int, HouseID int, FinishTime int64) []THouseBid {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT COALESCE(c.Name, ''), b.BidAmount, b.Timestamp
+		FROM HouseBids b
+		LEFT JOIN Characters c ON c.CharacterID = b.CharacterID
+		WHERE b.WorldID = ? AND b.HouseID = ? AND b.FinishTime = ?
+		ORDER BY b.BidID DESC
+		LIMIT ?
+	`, WorldID, HouseID, FinishTime, HOUSE_BID_HISTORY_MAX)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house bids: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Bids []THouseBid
+	for Rows.Next() {
+		var Bid THouseBid
+		if Err := Rows.Scan(&Bid.CharacterName, &Bid.BidAmount, &Bid.Timestamp); Err != nil {
+			g_LogErr.Printf("Failed to scan house bid: %v", Err)
+			continue
+		}
+		Bids = append(Bids, Bid)
+	}
+	return Bids
+}
+
+func GetCharacterAccountEmail(CharacterID int) (Name string, Email string) {
+	if g_NewsDb == nil {
+		return "", ""
+	}
+
+	Err := g_NewsDb.QueryRow(`
+		SELECT c.Name, COALESCE(a.Email, '') FROM Characters c
+		JOIN Accounts a ON a.AccountID = c.AccountID
+		WHERE c.CharacterID = ?
+	`, CharacterID).Scan(&Name, &Email)
+	if Err != nil && Err != sql.ErrNoRows {
+		g_LogErr.Printf("Failed to query account e-mail of character %v: %v", CharacterID, Err)
+	}
+	return Name, Email
+}
+
+// NotifyHouseOutbid e-mails the account of a character that was outbid. It is
+// run in its own goroutine so a slow mail server doesn't hold up the bid.
+func NotifyHouseOutbid(WorldID int, HouseID int, CharacterID int, PreviousBid int, NewBid int, FinishTime int) {
+	Name, Email := GetCharacterAccountEmail(CharacterID)
+	if Email == "" {
+		return
+	}
+
+	HouseName := fmt.Sprintf("house %v", HouseID)
+	if House := GetHouse(WorldID, HouseID); House != nil {
+		HouseName = House.Name
+	}
+
+	Subject := fmt.Sprintf("You have been outbid on %v", HouseName)
+	Body := fmt.Sprintf("<p>The bid of %v gold placed by %v on %v has been outbid."+
+		" The highest bid is now %v gold.</p><p>The auction ends on %v.</p>",
+		PreviousBid, html.EscapeString(Name), html.EscapeString(HouseName), NewBid,
+		time.Unix(int64(FinishTime), 0).UTC().Format("Jan 02 2006, 15:04:05 MST"))
+	if Err := SendMail(Email, Subject, Body); Err != nil {
+		g_LogErr.Printf("Failed to send outbid e-mail to character %v: %v", CharacterID, Err)
+	}
+}
+
+func SendCharacterMail(CharacterID int, Subject string, Body string) {
+	_, Email := GetCharacterAccountEmail(CharacterID)
+	if Email == "" {
+		return
+	}
+
+	if Err := SendMail(Email, Subject, Body); Err != nil {
+		g_LogErr.Printf("Failed to send e-mail to character %v: %v", CharacterID, Err)
+	}
+}
+
+func GetFinishedHouseAuctions() []TFinishedHouseAuction {
+	Rows, Err := g_NewsDb.Query(`
+		SELECT ha.WorldID, COALESCE(w.Name, ''), ha.HouseID, COALESCE(ha.BidderID, 0),
+			COALESCE(ha.BidAmount, 0), ha.FinishTime
+		FROM HouseAuctions ha
+		LEFT JOIN Worlds w ON w.WorldID = ha.WorldID
+		WHERE ha.FinishTime IS NOT NULL AND ha.FinishTime <= ?
+		ORDER BY ha.FinishTime ASC
+	`, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to query finished house auctions: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Auctions []TFinishedHouseAuction
+	for Rows.Next() {
+		var Auction TFinishedHouseAuction
+		if Err := Rows.Scan(&Auction.WorldID, &Auction.World, &Auction.HouseID,
+			&Auction.BidderID, &Auction.BidAmount, &Auction.FinishTime); Err != nil {
+			g_LogErr.Printf("Failed to scan finished house auction: %v", Err)
+			continue
+		}
+		Auctions = append(Auctions, Auction)
+	}
+	return Auctions
+}
+
+// GetHouseAuctionCandidates returns everyone who bid on an auction, with their
+// highest bid, from the highest bid down. If the winner can't pay, the house
+// goes to the next one in the list.
+func GetHouseAuctionCandidates(Auction TFinishedHouseAuction) []THouseAuctionCandidate {
+	Rows, Err := g_NewsDb.Query(`
+		SELECT b.CharacterID, COALESCE(c.Name, ''), MAX(b.BidAmount) AS Amount
+		FROM HouseBids b
+		LEFT JOIN Characters c ON c.CharacterID = b.CharacterID
+		WHERE b.WorldID = ? AND b.HouseID = ? AND b.FinishTime = ?
+		GROUP BY b.CharacterID
+		ORDER BY Amount DESC, MIN(b.BidID) ASC
+	`, Auction.WorldID, Auction.HouseID, Auction.FinishTime)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house auction bids: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Candidates []THouseAuctionCandidate
+	for Rows.Next() {
+		var Candidate THouseAuctionCandidate
+		if Err := Rows.Scan(&Candidate.CharacterID, &Candidate.CharacterName, &Candidate.BidAmount); Err != nil {
+			g_LogErr.Printf("Failed to scan house auction bid: %v", Err)
+			continue
+		}
+		Candidates = append(Candidates, Candidate)
+	}
+
+	// NOTE: Bids placed before the bid history existed only live in the
+	// auction itself.
+	if Auction.BidderID != 0 && (len(Candidates) == 0 || Candidates[0].CharacterID != Auction.BidderID) {
+		Name, _ := GetCharacterAccountEmail(Auction.BidderID)
+		Candidates = append([]THouseAuctionCandidate{{
+			CharacterID:   Auction.BidderID,
+			CharacterName: Name,
+			BidAmount:     Auction.BidAmount,
+		}}, Candidates...)
+	}
+
+	return Candidates
+}
+
+// GetSettledHouseAuctionCandidates returns the bidders of an auction whose
+// settlement step was already recorded by an earlier run.
+func GetSettledHouseAuctionCandidates(Auction TFinishedHouseAuction) map[int]bool {
+	Settled := make(map[int]bool)
+	Rows, Err := g_NewsDb.Query(`
+		SELECT CharacterID FROM HouseAuctionSettlements
+		WHERE WorldID = ? AND HouseID = ? AND FinishTime = ?
+	`, Auction.WorldID, Auction.HouseID, Auction.FinishTime)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house auction settlements: %v", Err)
+		return Settled
+	}
+	defer Rows.Close()
+
+	for Rows.Next() {
+		var CharacterID int
+		if Err := Rows.Scan(&CharacterID); Err != nil {
+			g_LogErr.Printf("Failed to scan house auction settlement: %v", Err)
+			continue
+		}
+		Settled[CharacterID] = true
+	}
+	return Settled
+}
+
+// RecordHouseAuctionSettlement records one settlement step and, if `Finish`
+// is set, removes the auction in the same transaction. It returns false if
+// the step was already recorded or couldn't be, so side effects like e-mails
+// only happen once.
+func RecordHouseAuctionSettlement(Auction TFinishedHouseAuction, CharacterID int, BidAmount int, Result string, Finish bool) bool {
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return false
+	}
+	defer Tx.Rollback()
+
+	Inserted, Err := Tx.Exec(`
+		INSERT OR IGNORE INTO HouseAuctionSettlements
+			(WorldID, HouseID, FinishTime, CharacterID, BidAmount, Result, Timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, Auction.WorldID, Auction.HouseID, Auction.FinishTime, CharacterID, BidAmount, Result, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert house auction settlement: %v", Err)
+		return false
+	}
+
+	if Affected, Err := Inserted.RowsAffected(); Err != nil || Affected != 1 {
+		return false
+	}
+
+	if Finish {
+		_, Err = Tx.Exec(`
+			DELETE FROM HouseAuctions WHERE WorldID = ? AND HouseID = ? AND FinishTime = ?
+		`, Auction.WorldID, Auction.HouseID, Auction.FinishTime)
+		if Err != nil {
+			g_LogErr.Printf("Failed to delete house auction %v on world %v: %v",
+				Auction.HouseID, Auction.WorldID, Err)
+			return false
+		}
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit house auction settlement: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func SettleHouseAuctions() {
+	for _, Auction := range GetFinishedHouseAuctions() {
+		if !SettleHouseAuction(Auction) {
+			return
+		}
+	}
+}
+
+// SettleHouseAuction goes through the bidders of a finished auction from the
+// highest bid down until the game accepts one of them as the new owner. If no
+// one can take the house, the auction is restarted. It returns false if the
+// query manager couldn't be reached, in which case the auction is left alone
+// and settled on a later run. Bidders that were already turned down by an
+// earlier run are skipped, so they aren't asked to pay or e-mailed twice.
+func SettleHouseAuction(Auction TFinishedHouseAuction) bool {
+	HouseName := fmt.Sprintf("house %v", Auction.HouseID)
+	if House := GetHouse(Auction.WorldID, Auction.HouseID); House != nil {
+		HouseName = House.Name
+	}
+
+	Settled := GetSettledHouseAuctionCandidates(Auction)
+	Candidates := GetHouseAuctionCandidates(Auction)
+	Winner := -1
+	for Index, Candidate := range Candidates {
+		if Settled[Candidate.CharacterID] {
+			continue
+		}
+
+		Result := AssignHouse(Auction.World, Auction.HouseID, Candidate.CharacterID, Candidate.BidAmount)
+		if Result == HOUSE_ASSIGN_OK {
+			Winner = Index
+			break
+		}
+
+		switch Result {
+		case HOUSE_ASSIGN_NO_CHARACTER:
+			RecordHouseAuctionSettlement(Auction, Candidate.CharacterID, Candidate.BidAmount, "character not found", false)
+		case HOUSE_ASSIGN_INSUFFICIENT_FUNDS:
+			if RecordHouseAuctionSettlement(Auction, Candidate.CharacterID, Candidate.BidAmount, "insufficient funds", false) {
+				go SendCharacterMail(Candidate.CharacterID,
+					fmt.Sprintf("Your bid on %v could not be paid", HouseName),
+					fmt.Sprintf("<p>%v won the auction for %v with a bid of %v gold but didn't have"+
+						" enough money in the bank to pay for it. The house has been offered to"+
+						" the next bidder.</p>", html.EscapeString(Candidate.CharacterName),
+						html.EscapeString(HouseName), Candidate.BidAmount))
+			}
+		case HOUSE_ASSIGN_ALREADY_OWNED:
+			// NOTE: The game already gave the house away, possibly to this same
+			// bidder on a run that failed before it was recorded, so there is
+			// nothing left to auction.
+			RecordHouseAuctionSettlement(Auction, Candidate.CharacterID, Candidate.BidAmount, "house already owned", true)
+			return true
+		default:
+			return false
+		}
+	}
+
+	if Winner < 0 {
+		RestartHouseAuction(Auction)
+		return true
+	}
+
+	WinnerCandidate := Candidates[Winner]
+	if !RecordHouseAuctionSettlement(Auction, WinnerCandidate.CharacterID, WinnerCandidate.BidAmount, "assigned", true) {
+		// NOTE: The house is assigned but the auction is still there. The next
+		// run will find it already owned and close it.
+		return false
+	}
+
+	g_Log.Printf("House %v on %v was assigned to %v for %v gold", Auction.HouseID,
+		Auction.World, WinnerCandidate.CharacterName, WinnerCandidate.BidAmount)
+
+	go SendCharacterMail(WinnerCandidate.CharacterID,
+		fmt.Sprintf("You won the auction for %v", HouseName),
+		fmt.Sprintf("<p>Congratulations! %v won the auction for %v with a bid of %v gold."+
+			" The house is yours.</p>", html.EscapeString(WinnerCandidate.CharacterName),
+			html.EscapeString(HouseName), WinnerCandidate.BidAmount))
+
+	for _, Candidate := range Candidates[Winner+1:] {
+		go SendCharacterMail(Candidate.CharacterID,
+			fmt.Sprintf("The auction for %v has ended", HouseName),
+			fmt.Sprintf("<p>The auction for %v has ended. The bid of %v gold placed by %v"+
+				" was not the winning bid.</p>", html.EscapeString(HouseName),
+				Candidate.BidAmount, html.EscapeString(Candidate.CharacterName)))
+	}
+
+	return true
+}
+
+func RestartHouseAuction(Auction TFinishedHouseAuction) {
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return
+	}
+	defer Tx.Rollback()
+
+	FinishTime := time.Now().Add(g_HouseAuctionDuration).Unix()
+	_, Err = Tx.Exec(`
+		UPDATE HouseAuctions SET BidderID = NULL, BidAmount = 0, FinishTime = ?
+		WHERE WorldID = ? AND HouseID = ? AND FinishTime = ?
+	`, FinishTime, Auction.WorldID, Auction.HouseID, Auction.FinishTime)
+	if Err != nil {
+		g_LogErr.Printf("Failed to restart house auction %v on world %v: %v",
+			Auction.HouseID, Auction.WorldID, Err)
+		return
+	}
+
+	_, Err = Tx.Exec(`
+		INSERT OR IGNORE INTO HouseAuctionSettlements
+			(WorldID, HouseID, FinishTime, CharacterID, BidAmount, Result, Timestamp)
+		VALUES (?, ?, ?, 0, 0, 'restarted', ?)
+	`, Auction.WorldID, Auction.HouseID, Auction.FinishTime, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert house auction settlement: %v", Err)
+		return
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit house auction restart: %v", Err)
+	}
+}
+
+func GetHouseTransfers(Where string, Args ...any) []THouseTransfer {
+	// NOTE: Pending requests live in `HouseTransferRequests` until the new
+	// owner accepts them. Accepted transfers and move-outs are in
+	// `HouseTransfers` where the game picks them up at the next server save.
+	Rows, Err := g_NewsDb.Query(`
+		SELECT x.RequestID, x.WorldID, x.HouseID, COALESCE(h.Name, ''), x.OwnerID,
+			COALESCE(o.Name, ''), x.NewOwnerID, COALESCE(n.Name, ''), x.Price,
+			x.Created, x.Accepted
+		FROM (
+			SELECT r.RequestID, r.WorldID, r.HouseID, r.OwnerID, r.NewOwnerID, r.Price,
+				r.Created, 0 AS Accepted
+			FROM HouseTransferRequests r
+			UNION ALL
+			SELECT 0, t.WorldID, t.HouseID, COALESCE(ho.OwnerID, 0), t.NewOwnerID, t.Price,
+				0, 1
+			FROM HouseTransfers t
+			LEFT JOIN HouseOwners ho ON ho.WorldID = t.WorldID AND ho.HouseID = t.HouseID
+		) x
+		LEFT JOIN Houses h ON h.WorldID = x.WorldID AND h.HouseID = x.HouseID
+		LEFT JOIN Characters o ON o.CharacterID = x.OwnerID
+		LEFT JOIN Characters n ON n.CharacterID = x.NewOwnerID
+		`+Where+`
+		ORDER BY x.HouseID
+	`, Args...)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house transfers: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Transfers []THouseTransfer
+	for Rows.Next() {
+		var Transfer THouseTransfer
+		if Err := Rows.Scan(&Transfer.RequestID, &Transfer.WorldID, &Transfer.HouseID,
+			&Transfer.HouseName, &Transfer.OwnerID, &Transfer.OwnerName,
+			&Transfer.NewOwnerID, &Transfer.NewOwnerName, &Transfer.Price,
+			&Transfer.Created, &Transfer.Accepted); Err != nil {
+			g_LogErr.Printf("Failed to scan house transfer: %v", Err)
+			continue
+		}
+		Transfer.MoveOut = Transfer.NewOwnerID == 0
+		Transfers = append(Transfers, Transfer)
+	}
+	return Transfers
+}
+
+// GetHouseTransfer returns the pending or scheduled transfer of a house made by
+// its current owner. Requests left behind by a previous owner aren't returned.
+func GetHouseTransfer(WorldID int, HouseID int, OwnerID int) *THouseTransfer {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Transfers := GetHouseTransfers("WHERE x.WorldID = ? AND x.HouseID = ? AND x.OwnerID = ?",
+		WorldID, HouseID, OwnerID)
+	if len(Transfers) == 0 {
+		return nil
+	}
+	return &Transfers[0]
+}
+
+// GetAccountHouseTransfers returns the transfers of houses owned by the
+// account and the transfers offered to any of its characters.
+func GetAccountHouseTransfers(AccountID int) (Outgoing []THouseTransfer, Incoming []THouseTransfer) {
+	if g_NewsDb == nil {
+		return nil, nil
+	}
+
+	Outgoing = GetHouseTransfers(`WHERE x.OwnerID IN
+		(SELECT CharacterID FROM Characters WHERE AccountID = ?)`, AccountID)
+	Incoming = GetHouseTransfers(`WHERE x.NewOwnerID IN
+		(SELECT CharacterID FROM Characters WHERE AccountID = ?)`, AccountID)
+	return Outgoing, Incoming
+}
+
+// GetAccountHouseOwner returns the character of an account that owns a house,
+// or zero if the account doesn't own it.
+func GetAccountHouseOwner(AccountID int, WorldID int, HouseID int) (OwnerID int) {
+	if g_NewsDb == nil {
+		return 0
+	}
+
+	Err := g_NewsDb.QueryRow(`
+		SELECT ho.OwnerID FROM HouseOwners ho
+		JOIN Characters c ON c.CharacterID = ho.OwnerID
+		WHERE ho.WorldID = ? AND ho.HouseID = ? AND c.AccountID = ?
+	`, WorldID, HouseID, AccountID).Scan(&OwnerID)
+	if Err != nil && Err != sql.ErrNoRows {
+		g_LogErr.Printf("Failed to query house owner: %v", Err)
+	}
+	return OwnerID
+}
+
+func RequestHouseTransfer(AccountID int, WorldID int, HouseID int, NewOwnerName string, Price int) int {
+	OwnerID := GetAccountHouseOwner(AccountID, WorldID, HouseID)
+	if OwnerID == 0 {
+		return HOUSE_TRANSFER_NOT_OWNER
+	}
+
+	var NewOwnerID int
+	Err := g_NewsDb.QueryRow(`
+		SELECT CharacterID FROM Characters
+		WHERE Name = ? AND WorldID = ? AND Deleted = 0
+	`, NewOwnerName, WorldID).Scan(&NewOwnerID)
+	if Err == sql.ErrNoRows || NewOwnerID == OwnerID {
+		return HOUSE_TRANSFER_INVALID_CHARACTER
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query transfer character: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+	defer Tx.Rollback()
+
+	var Scheduled int
+	Err = Tx.QueryRow(`
+		SELECT COUNT(*) FROM HouseTransfers WHERE WorldID = ? AND HouseID = ?
+	`, WorldID, HouseID).Scan(&Scheduled)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query house transfers: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	if Scheduled > 0 {
+		return HOUSE_TRANSFER_SCHEDULED
+	}
+
+	_, Err = Tx.Exec(`
+		INSERT OR REPLACE INTO HouseTransferRequests
+			(WorldID, HouseID, OwnerID, NewOwnerID, Price, Created)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, WorldID, HouseID, OwnerID, NewOwnerID, Price, time.Now().Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to insert house transfer request: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit house transfer request: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	return HOUSE_TRANSFER_OK
+}
+
+// ScheduleHouseTransfer replaces any pending request or scheduled transfer of
+// a house with an entry in `HouseTransfers`, which the game executes at the
+// next server save. A `NewOwnerID` of zero means the owner moves out.
+func ScheduleHouseTransfer(WorldID int, HouseID int, NewOwnerID int, Price int) bool {
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return false
+	}
+	defer Tx.Rollback()
+
+	_, Err = Tx.Exec(`
+		DELETE FROM HouseTransferRequests WHERE WorldID = ? AND HouseID = ?
+	`, WorldID, HouseID)
+	if Err == nil {
+		_, Err = Tx.Exec(`
+			DELETE FROM HouseTransfers WHERE WorldID = ? AND HouseID = ?
+		`, WorldID, HouseID)
+	}
+	if Err == nil {
+		_, Err = Tx.Exec(`
+			INSERT INTO HouseTransfers (WorldID, HouseID, NewOwnerID, Price)
+			VALUES (?, ?, ?, ?)
+		`, WorldID, HouseID, NewOwnerID, Price)
+	}
+	if Err == nil {
+		Err = Tx.Commit()
+	}
+
+	if Err != nil {
+		g_LogErr.Printf("Failed to schedule house transfer: %v", Err)
+		return false
+	}
+	return true
+}
+
+func ScheduleHouseMoveOut(AccountID int, WorldID int, HouseID int) int {
+	OwnerID := GetAccountHouseOwner(AccountID, WorldID, HouseID)
+	if OwnerID == 0 {
+		return HOUSE_TRANSFER_NOT_OWNER
+	}
+
+	if !ScheduleHouseTransfer(WorldID, HouseID, 0, 0) {
+		return HOUSE_TRANSFER_ERROR
+	}
+	return HOUSE_TRANSFER_OK
+}
+
+// CancelHouseTransfer lets the owner withdraw a pending request, an accepted
+// transfer or a move-out, as long as the game hasn't executed it yet.
+func CancelHouseTransfer(AccountID int, WorldID int, HouseID int) int {
+	OwnerID := GetAccountHouseOwner(AccountID, WorldID, HouseID)
+	if OwnerID == 0 {
+		return HOUSE_TRANSFER_NOT_OWNER
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+	defer Tx.Rollback()
+
+	_, Err = Tx.Exec(`
+		DELETE FROM HouseTransferRequests WHERE WorldID = ? AND HouseID = ?
+	`, WorldID, HouseID)
+	if Err == nil {
+		_, Err = Tx.Exec(`
+			DELETE FROM HouseTransfers WHERE WorldID = ? AND HouseID = ?
+		`, WorldID, HouseID)
+	}
+	if Err == nil {
+		Err = Tx.Commit()
+	}
+
+	if Err != nil {
+		g_LogErr.Printf("Failed to cancel house transfer: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+	return HOUSE_TRANSFER_OK
+}
+
+// AnswerHouseTransfer accepts or declines a transfer request made to one of the
+// account's characters. Accepted requests are handed to the game, provided the
+// house still belongs to the character that made the request.
+func AnswerHouseTransfer(AccountID int, RequestID int, Accept bool) int {
+	if g_NewsDb == nil {
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	var WorldID, HouseID, OwnerID, NewOwnerID, Price, CurrentOwnerID int
+	Err := g_NewsDb.QueryRow(`
+		SELECT r.WorldID, r.HouseID, r.OwnerID, r.NewOwnerID, r.Price, COALESCE(ho.OwnerID, 0)
+		FROM HouseTransferRequests r
+		JOIN Characters c ON c.CharacterID = r.NewOwnerID
+		LEFT JOIN HouseOwners ho ON ho.WorldID = r.WorldID AND ho.HouseID = r.HouseID
+		WHERE r.RequestID = ? AND c.AccountID = ?
+	`, RequestID, AccountID).Scan(&WorldID, &HouseID, &OwnerID, &NewOwnerID, &Price, &CurrentOwnerID)
+	if Err == sql.ErrNoRows {
+		return HOUSE_TRANSFER_NOT_FOUND
+	} else if Err != nil {
+		g_LogErr.Printf("Failed to query house transfer request: %v", Err)
+		return HOUSE_TRANSFER_ERROR
+	}
+
+	if !Accept || CurrentOwnerID != OwnerID {
+		_, Err := g_NewsDb.Exec(`
+			DELETE FROM HouseTransferRequests WHERE RequestID = ?
+		`, RequestID)
+		if Err != nil {
+			g_LogErr.Printf("Failed to delete house transfer request: %v", Err)
+			return HOUSE_TRANSFER_ERROR
+		}
+
+		if Accept {
+			return HOUSE_TRANSFER_NOT_FOUND
+		}
+		return HOUSE_TRANSFER_OK
+	}
+
+	if !ScheduleHouseTransfer(WorldID, HouseID, NewOwnerID, Price) {
+		return HOUSE_TRANSFER_ERROR
+	}
+	return HOUSE_TRANSFER_OK
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// NOTE: Jobs are periodic background tasks like samplers and reminders. They
+// run in their own goroutine until `ExitJobs` is called, which waits for any
+// job that is still running so it won't use resources that are about to be
+// released.
+var (
+	g_JobsStop      chan struct{}
+	g_JobsWaitGroup sync.WaitGroup
+)
+
+func InitJobs() bool {
+	g_JobsStop = make(chan struct{})
+	return true
+}
+
+func ExitJobs() {
+	if g_JobsStop != nil {
+		close(g_JobsStop)
+		g_JobsWaitGroup.Wait()
+		g_JobsStop = nil
+	}
+}
+
+func RunJob(Name string, Run func()) {
+	defer func() {
+		if Err := recover(); Err != nil {
+			g_LogErr.Printf("Job \"%v\" panicked: %v", Name, Err)
+		}
+	}()
+	Run()
+}
+
+// StartJob runs `Run` right away and then every `Interval`. A non-positive
+// interval disables the job.
+func StartJob(Name string, Interval time.Duration, Run func()) {
+	if Interval <= 0 {
+		g_LogWarn.Printf("Job \"%v\" disabled", Name)
+		return
+	}
+
+	if g_JobsStop == nil {
+		g_LogErr.Printf("Jobs not initialized, unable to start \"%v\"", Name)
+		return
+	}
+
+	Stop := g_JobsStop
+	g_JobsWaitGroup.Add(1)
+	go func() {
+		defer g_JobsWaitGroup.Done()
+		Ticker := time.NewTicker(Interval)
+		defer Ticker.Stop()
+
+		RunJob(Name, Run)
+		for {
+			select {
+			case <-Stop:
+				return
+			case <-Ticker.C:
+				RunJob(Name, Run)
+			}
+		}
+	}()
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// NOTE: Snapshots older than this are reduced to the last one of each day,
+	// which is all the per day views need.
+	KILL_STATISTICS_HOURLY_AGE = 48 * time.Hour
+)
+
+type (
+	TKillStatisticsDay struct {
+		Day           int
+		Label         string
+		TimesKilled   int
+		PlayersKilled int
+		Percent       int
+	}
+)
+
+func InitKillStatistics() bool {
+	g_Log.Printf("KillStatisticsSnapshotInterval: %v", g_KillStatisticsSnapshotInterval)
+	g_Log.Printf("KillStatisticsSnapshotRetention: %v", g_KillStatisticsSnapshotRetention)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS KillStatisticsSnapshots (
+			World TEXT NOT NULL COLLATE NOCASE,
+			RaceName TEXT NOT NULL COLLATE NOCASE,
+			Timestamp INTEGER NOT NULL,
+			TimesKilled INTEGER NOT NULL,
+			PlayersKilled INTEGER NOT NULL,
+			PRIMARY KEY (World, RaceName, Timestamp)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create kill statistics snapshots table: %v", Err)
+		return false
+	}
+
+	_, Err = g_NewsDb.Exec(`
+		CREATE INDEX IF NOT EXISTS KillStatisticsSnapshotsWorldIndex
+			ON KillStatisticsSnapshots (World, Timestamp)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create kill statistics snapshots index: %v", Err)
+		return false
+	}
+
+	StartJob("kill statistics snapshots", g_KillStatisticsSnapshotInterval, SnapshotKillStatistics)
+	return true
+}
+
+func ExitKillStatistics() {
+	// no-op
+}
+
+// SnapshotKillStatistics stores the kill statistics of every world. They're
+// running totals so the numbers for a time window are the difference between
+// the current totals and a snapshot taken at the start of it.
+func SnapshotKillStatistics() {
+	Now := time.Now()
+	for _, World := range GetWorlds() {
+		Stats := GetKillStatistics(World.Name)
+		if len(Stats) == 0 {
+			continue
+		}
+
+		Tx, Err := g_NewsDb.Begin()
+		if Err != nil {
+			g_LogErr.Printf("Failed to begin transaction: %v", Err)
+			return
+		}
+
+		for _, Entry := range Stats {
+			_, Err = Tx.Exec(`
+				INSERT OR REPLACE INTO KillStatisticsSnapshots
+					(World, RaceName, Timestamp, TimesKilled, PlayersKilled)
+				VALUES (?, ?, ?, ?, ?)
+			`, World.Name, Entry.RaceName, Now.Unix(), Entry.TimesKilled, Entry.PlayersKilled)
+			if Err != nil {
+				break
+			}
+		}
+
+		if Err == nil {
+			Err = Tx.Commit()
+		}
+
+		if Err != nil {
+			Tx.Rollback()
+			g_LogErr.Printf("Failed to insert kill statistics snapshot: %v", Err)
+			return
+		}
+	}
+
+	// NOTE: The snapshots to keep are looked up once for the whole table, a
+	// subquery per row would make this quadratic while holding the write lock.
+	_, Err := g_NewsDb.Exec(`
+		DELETE FROM KillStatisticsSnapshots
+		WHERE Timestamp < ? AND (World, Timestamp) NOT IN (
+			SELECT World, MAX(Timestamp) FROM KillStatisticsSnapshots
+			GROUP BY World, date(Timestamp, 'unixepoch', 'localtime'))
+	`, Now.Add(-KILL_STATISTICS_HOURLY_AGE).Unix())
+	if Err != nil {
+		g_LogErr.Printf("Failed to compact kill statistics snapshots: %v", Err)
+	}
+
+	if g_KillStatisticsSnapshotRetention > 0 {
+		_, Err := g_NewsDb.Exec(`
+			DELETE FROM KillStatisticsSnapshots WHERE Timestamp < ?
+		`, Now.Add(-g_KillStatisticsSnapshotRetention).Unix())
+		if Err != nil {
+			g_LogErr.Printf("Failed to delete old kill statistics snapshots: %v", Err)
+		}
+	}
+}
+
+// GetKillStatisticsBaseline returns the last snapshot taken at or before
+// `Since`, indexed by lowercase race name. If the history doesn't go back that
+// far, the oldest snapshot is used instead.
+func GetKillStatisticsBaseline(World string, Since int) map[string]TKillStatistics {
+	Baseline := make(map[string]TKillStatistics)
+	if g_NewsDb == nil {
+		return Baseline
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT RaceName, TimesKilled, PlayersKilled FROM KillStatisticsSnapshots
+		WHERE World = ? AND Timestamp = COALESCE(
+			(SELECT MAX(Timestamp) FROM KillStatisticsSnapshots
+				WHERE World = ? AND Timestamp <= ?),
+			(SELECT MIN(Timestamp) FROM KillStatisticsSnapshots
+				WHERE World = ?))
+	`, World, World, Since, World)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query kill statistics snapshot: %v", Err)
+		return Baseline
+	}
+	defer Rows.Close()
+
+	for Rows.Next() {
+		var Entry TKillStatistics
+		if Err := Rows.Scan(&Entry.RaceName, &Entry.TimesKilled, &Entry.PlayersKilled); Err != nil {
+			g_LogErr.Printf("Failed to scan kill statistics snapshot: %v", Err)
+			continue
+		}
+		Baseline[strings.ToLower(Entry.RaceName)] = Entry
+	}
+	return Baseline
+}
+
+// KillStatisticsDelta returns how much a running total grew since `Base`. A
+// total that went down means the statistics were reset in between, in which
+// case the whole current total is counted.
+func KillStatisticsDelta(Current int, Base int) int {
+	if Current < Base {
+		return Current
+	}
+	return Current - Base
+}
+
+func GetKillStatisticsForPeriod(World string, Period string) []TKillStatistics {
+	// NOTE: The result is a copy because it may be sorted afterwards and the
+	// cached slice is shared.
+	Stats := slices.Clone(GetKillStatistics(World))
+
+	var Since time.Time
+	switch Period {
+	case "day":
+		Since = time.Now().AddDate(0, 0, -1)
+	case "week":
+		Since = time.Now().AddDate(0, 0, -7)
+	default:
+		return Stats
+	}
+
+	Baseline := GetKillStatisticsBaseline(World, int(Since.Unix()))
+	for Index := range Stats {
+		Entry := &Stats[Index]
+		Base := Baseline[strings.ToLower(Entry.RaceName)]
+		Entry.TimesKilled = KillStatisticsDelta(Entry.TimesKilled, Base.TimesKilled)
+		Entry.PlayersKilled = KillStatisticsDelta(Entry.PlayersKilled, Base.PlayersKilled)
+	}
+
+	return slices.DeleteFunc(Stats, func(Entry TKillStatistics) bool {
+		return Entry.TimesKilled == 0 && Entry.PlayersKilled == 0
+	})
+}
+
+func SortKillStatistics(Stats []TKillStatistics, Sort string) {
+	slices.SortStableFunc(Stats, func(A, B TKillStatistics) int {
+		switch Sort {
+		case "killed":
+			if A.TimesKilled != B.TimesKilled {
+				return B.TimesKilled - A.TimesKilled
+			}
+		case "players":
+			if A.PlayersKilled != B.PlayersKilled {
+				return B.PlayersKilled - A.PlayersKilled
+			}
+		}
+		return strings.Compare(strings.ToLower(A.RaceName), strings.ToLower(B.RaceName))
+	})
+}
+
+// GetCreatureKillHistory returns how many times a creature was killed, and how
+// many players it killed, on each of the last `NumDays` days, oldest first.
+func GetCreatureKillHistory(World string, RaceName string, NumDays int) []TKillStatisticsDay {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Now := time.Now()
+	Since := StartOfDay(Now.AddDate(0, 0, -NumDays))
+	Rows, Err := g_NewsDb.Query(`
+		SELECT Timestamp, TimesKilled, PlayersKilled FROM KillStatisticsSnapshots
+		WHERE World = ? AND RaceName = ? AND Timestamp >= ?
+		ORDER BY Timestamp ASC
+	`, World, RaceName, Since)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query creature kill history: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	// NOTE: The first snapshot of the range is only used as the baseline for
+	// the day after it, so the range starts one day before the first day shown.
+	Days := make([]TKillStatisticsDay, NumDays)
+	for Index := range Days {
+		Time := Now.AddDate(0, 0, Index-NumDays+1)
+		Days[Index].Day = StartOfDay(Time)
+		Days[Index].Label = Time.Format("Jan 02 2006")
+	}
+
+	var Previous *TKillStatistics
+	for Rows.Next() {
+		var Timestamp int
+		var Entry TKillStatistics
+		if Err := Rows.Scan(&Timestamp, &Entry.TimesKilled, &Entry.PlayersKilled); Err != nil {
+			g_LogErr.Printf("Failed to scan kill statistics snapshot: %v", Err)
+			continue
+		}
+
+		if Previous != nil {
+			Day := StartOfDay(time.Unix(int64(Timestamp), 0))
+			Index := slices.IndexFunc(Days, func(Current TKillStatisticsDay) bool {
+				return Current.Day == Day
+			})
+			if Index >= 0 {
+				Days[Index].TimesKilled += KillStatisticsDelta(Entry.TimesKilled, Previous.TimesKilled)
+				Days[Index].PlayersKilled += KillStatisticsDelta(Entry.PlayersKilled, Previous.PlayersKilled)
+			}
+		}
+		Previous = &Entry
+	}
+
+	if Previous == nil {
+		return nil
+	}
+
+	// NOTE: Today isn't over yet so it uses the live totals.
+	for _, Entry := range GetKillStatistics(World) {
+		if strings.EqualFold(Entry.RaceName, RaceName) {
+			Days[NumDays-1].TimesKilled += KillStatisticsDelta(Entry.TimesKilled, Previous.TimesKilled)
+			Days[NumDays-1].PlayersKilled += KillStatisticsDelta(Entry.PlayersKilled, Previous.PlayersKilled)
+			break
+		}
+	}
+
+	Max := 0
+	for _, Day := range Days {
+		Max = max(Max, Day.TimesKilled)
+	}
+
+	if Max > 0 {
+		for Index := range Days {
+			Days[Index].Percent = Days[Index].TimesKilled * 100 / Max
+		}
+	}
+	return Days
+}
@@ -2,10 +2,12 @@ package main
 
 import (
         "fmt"
+        "html"
         "io"
         "log"
         "net"
         "net/http"
+        "net/url"
         "os"
         "path"
         "slices"
@@ -35,8 +37,9 @@ type (
                 Prefix    string
                 Params    []string
                 IPAddress string
-                SessionID []byte
-                AccountID int
+                SessionID   []byte
+                AccountID   int
+                Preferences *TAccountPreferences
         }
 )
 
@@ -46,6 +49,7 @@ var (
         g_HttpsPort     int    = 443
         g_HttpsCertFile string = ""
         g_HttpsKeyFile  string = ""
+        g_WebsiteURL    string = "http://localhost"
 
         // SMTP Config
         g_SmtpHost     string = "smtp.domain.com"
@@ -60,10 +64,61 @@ var (
         g_QueryManagerPassword string = ""
 
         // Query Manager Cache Config
-        g_MaxCachedAccounts        = 4096
-        g_MaxCachedCharacters      = 4096
-        g_CharacterRefreshInterval = 15 * time.Minute
-        g_WorldRefreshInterval     = 15 * time.Minute
+        g_MaxCachedAccounts         = 4096
+        g_MaxCachedCharacters       = 4096
+        g_CharacterRefreshInterval  = 15 * time.Minute
+        g_WorldRefreshInterval      = 15 * time.Minute
+        g_HighscoresRefreshInterval = time.Hour
+
+        // Character Config
+        g_SexChangePremiumDays = 3
+        g_OutfitSpriteSheet    = "res/outfits.png"
+
+        // World Status Config
+        g_WorldStatusInterval = time.Minute
+
+        // World Statistics Config
+        g_WorldSampleInterval      = 5 * time.Minute
+        g_WorldSampleDownsampleAge = 7 * 24 * time.Hour
+        g_WorldSampleRetention     = 365 * 24 * time.Hour
+
+        // Highscore Config
+        g_HighscoreSnapshotRetention = 90 * 24 * time.Hour
+
+        // Kill Statistics Config
+        g_KillStatisticsSnapshotInterval  = time.Hour
+        g_KillStatisticsSnapshotRetention = 90 * 24 * time.Hour
+
+        // Deaths Config
+        g_LatestDeaths = 50
+
+        // House Config
+        g_HouseAuctionSettlementInterval = 5 * time.Minute
+        g_HouseAuctionDuration           = 7 * 24 * time.Hour
+        g_HouseRentReminderAdvance       = 3 * 24 * time.Hour
+
+        // Guild Config
+        g_GuildCleanupInterval       = time.Hour
+        g_GuildInactivityPeriod      = 14 * 24 * time.Hour
+        g_GuildMinViceLeaders        = 1
+        g_GuildViceLeaderGracePeriod = 30 * 24 * time.Hour
+        g_GuildDisbandWarningPeriod  = 7 * 24 * time.Hour
+        g_GuildInviteExpiry          = 7 * 24 * time.Hour
+
+        // Guild Logo Config
+        g_GuildLogoMaxSize      = 256 * 1024
+        g_GuildLogoMaxDimension = 256
+
+        // Guild War Config
+        g_GuildWarInterval = 5 * time.Minute
+
+        // Guild Event Config
+        g_GuildEventReminderInterval = 15 * time.Minute
+        g_GuildEventReminderAdvance  = 24 * time.Hour
+
+        // House Map Config
+        g_MapDirectory  = "map"
+        g_HouseDataFile = "dat/houses.dat"
 
         // Loggers
         g_Log     = log.New(os.Stderr, "INFO ", log.Ldate|log.Ltime|log.Lmsgprefix)
@@ -80,6 +135,8 @@ func WebKVCallback(Key string, Value string) {
                 g_HttpsCertFile = ParseString(Value)
         } else if strings.EqualFold(Key, "HttpsKeyFile") {
                 g_HttpsKeyFile = ParseString(Value)
+        } else if strings.EqualFold(Key, "WebsiteURL") {
+                g_WebsiteURL = ParseString(Value)
         } else if strings.EqualFold(Key, "SmtpHost") {
                 g_SmtpHost = ParseString(Value)
         } else if strings.EqualFold(Key, "SmtpPort") {
@@ -104,11 +161,72 @@ func WebKVCallback(Key string, Value string) {
                 g_CharacterRefreshInterval = ParseDuration(Value)
         } else if strings.EqualFold(Key, "WorldRefreshInterval") {
                 g_WorldRefreshInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "HighscoresRefreshInterval") {
+                g_HighscoresRefreshInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "SexChangePremiumDays") {
+                g_SexChangePremiumDays = ParseInteger(Value)
+        } else if strings.EqualFold(Key, "OutfitSpriteSheet") {
+                g_OutfitSpriteSheet = ParseString(Value)
+        } else if strings.EqualFold(Key, "WorldStatusInterval") {
+                g_WorldStatusInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "WorldSampleInterval") {
+                g_WorldSampleInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "WorldSampleDownsampleAge") {
+                g_WorldSampleDownsampleAge = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "WorldSampleRetention") {
+                g_WorldSampleRetention = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "HighscoreSnapshotRetention") {
+                g_HighscoreSnapshotRetention = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "KillStatisticsSnapshotInterval") {
+                g_KillStatisticsSnapshotInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "KillStatisticsSnapshotRetention") {
+                g_KillStatisticsSnapshotRetention = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "LatestDeaths") {
+                g_LatestDeaths = ParseInteger(Value)
+        } else if strings.EqualFold(Key, "HouseAuctionSettlementInterval") {
+                g_HouseAuctionSettlementInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "HouseAuctionDuration") {
+                g_HouseAuctionDuration = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "HouseRentReminderAdvance") {
+                g_HouseRentReminderAdvance = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildCleanupInterval") {
+                g_GuildCleanupInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildInactivityPeriod") {
+                g_GuildInactivityPeriod = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildMinViceLeaders") {
+                g_GuildMinViceLeaders = ParseInteger(Value)
+        } else if strings.EqualFold(Key, "GuildViceLeaderGracePeriod") {
+                g_GuildViceLeaderGracePeriod = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildDisbandWarningPeriod") {
+                g_GuildDisbandWarningPeriod = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildInviteExpiry") {
+                g_GuildInviteExpiry = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildLogoMaxSize") {
+                g_GuildLogoMaxSize = ParseSize(Value)
+        } else if strings.EqualFold(Key, "GuildLogoMaxDimension") {
+                g_GuildLogoMaxDimension = ParseInteger(Value)
+        } else if strings.EqualFold(Key, "GuildWarInterval") {
+                g_GuildWarInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildEventReminderInterval") {
+                g_GuildEventReminderInterval = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "GuildEventReminderAdvance") {
+                g_GuildEventReminderAdvance = ParseDuration(Value)
+        } else if strings.EqualFold(Key, "MapDirectory") {
+                g_MapDirectory = ParseString(Value)
+        } else if strings.EqualFold(Key, "HouseDataFile") {
+                g_HouseDataFile = ParseString(Value)
         } else {
                 g_LogWarn.Printf("Unknown config \"%v\"", Key)
         }
 }
 
+// WebsiteURL returns an absolute link to a path on the website. It's built
+// from `WebsiteURL` in the config and never from the request, since the Host
+// header is controlled by the client.
+func WebsiteURL(Path string) string {
+        return strings.TrimRight(g_WebsiteURL, "/") + Path
+}
+
 func (Route *THttpRoute) LessThan(Method string, Prefix string, AllowParams bool) bool {
         if Route.Method != Method {
                 return Route.Method < Method
@@ -328,6 +446,67 @@ func HandleFavicon(Context *THttpRequestContext) {
         HandleResource(Context)
 }
 
+func HandleOutfit(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        Sex, _ := strconv.Atoi(QueryValues.Get("sex"))
+        Outfit := DefaultOutfit(Sex)
+        if QueryValues.Has("type") {
+                var Errs [5]error
+                Outfit.LookType, Errs[0] = strconv.Atoi(QueryValues.Get("type"))
+                Outfit.Head, Errs[1] = strconv.Atoi(QueryValues.Get("head"))
+                Outfit.Body, Errs[2] = strconv.Atoi(QueryValues.Get("body"))
+                Outfit.Legs, Errs[3] = strconv.Atoi(QueryValues.Get("legs"))
+                Outfit.Feet, Errs[4] = strconv.Atoi(QueryValues.Get("feet"))
+                for _, Err := range Errs {
+                        if Err != nil {
+                                ResourceError(Context, http.StatusBadRequest)
+                                return
+                        }
+                }
+        }
+
+        Data, Ok := RenderOutfit(Outfit)
+        if !Ok {
+                ResourceError(Context, http.StatusNotFound)
+                return
+        }
+
+        Context.Writer.Header().Set("Content-Type", "image/png")
+        Context.Writer.Header().Set("Content-Length", strconv.Itoa(len(Data)))
+        Context.Writer.Header().Set("Cache-Control", "public, max-age=86400")
+        if _, Err := Context.Writer.Write(Data); Err != nil {
+                g_LogErr.Printf("Failed to write outfit: %v", Err)
+        }
+}
+
+func HandleGuildLogo(Context *THttpRequestContext) {
+        if len(Context.Params) != 1 {
+                ResourceError(Context, http.StatusNotFound)
+                return
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Params[0])
+        if Err != nil {
+                ResourceError(Context, http.StatusBadRequest)
+                return
+        }
+
+        // NOTE: Guild pages link the logo with its upload time so a new logo
+        // gets a new URL, which lets browsers keep it for a long time.
+        Data, Updated := GetGuildLogo(GuildID)
+        Context.Writer.Header().Set("Content-Type", "image/png")
+        Context.Writer.Header().Set("Content-Length", strconv.Itoa(len(Data)))
+        Context.Writer.Header().Set("Cache-Control", "public, max-age=86400")
+        Context.Writer.Header().Set("X-Content-Type-Options", "nosniff")
+        if Updated > 0 {
+                Context.Writer.Header().Set("Last-Modified",
+                        time.Unix(int64(Updated), 0).UTC().Format(http.TimeFormat))
+        }
+        if _, Err := Context.Writer.Write(Data); Err != nil {
+                g_LogErr.Printf("Failed to write guild logo: %v", Err)
+        }
+}
+
 func HandleIndex(Context *THttpRequestContext) {
         Redirect(Context, "/account")
 }
@@ -388,6 +567,88 @@ func HandleAccountLogout(Context *THttpRequestContext) {
         Redirect(Context, "/account")
 }
 
+func HandleAccountPreferences(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        if Context.Request.Method != http.MethodPost {
+                NotFound(Context)
+                return
+        }
+
+        MainCharacter := strings.TrimSpace(Context.Request.FormValue("maincharacter"))
+        TimeZone := strings.TrimSpace(Context.Request.FormValue("timezone"))
+        Language := strings.TrimSpace(Context.Request.FormValue("language"))
+
+        MainCharacterID := 0
+        if MainCharacter != "" {
+                Result, Account := GetAccountSummary(Context.AccountID)
+                if Result != 0 {
+                        RenderMessage(Context, "Preferences Error", "Failed to retrieve account information.")
+                        return
+                }
+
+                CharFound := false
+                for _, Char := range Account.Characters {
+                        if strings.EqualFold(Char.Name, MainCharacter) && !Char.Deleted {
+                                CharFound = true
+                                break
+                        }
+                }
+
+                if CharFound {
+                        MainCharacterID = GetCharacterID(MainCharacter)
+                }
+
+                if MainCharacterID <= 0 {
+                        RenderMessage(Context, "Preferences Error", "Invalid main character.")
+                        return
+                }
+        }
+
+        if _, Ok := LoadTimeZone(TimeZone); !Ok {
+                RenderMessage(Context, "Preferences Error",
+                        "Unknown time zone. Use a name like \"Europe/Berlin\" or \"America/Sao_Paulo\".")
+                return
+        }
+
+        if Language == "" {
+                Language = DEFAULT_LANGUAGE
+        }
+
+        if !IsTemplateLanguage(Language) {
+                RenderMessage(Context, "Preferences Error", "Unsupported language.")
+                return
+        }
+
+        switch SetAccountPreferences(Context.AccountID, MainCharacterID, TimeZone, Language) {
+        case 0:
+                // NOTE: Drop the preferences loaded for this request so the
+                // summary is already rendered with the new ones.
+                Context.Preferences = nil
+                RenderAccountSummary(Context)
+        default:
+                RenderMessage(Context, "Preferences Error", "Internal error.")
+        }
+}
+
+func HandleAccountRentReminders(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        Enabled := Context.Request.FormValue("enabled") == "1"
+        switch SetHouseRentRemindersEnabled(Context.AccountID, Enabled) {
+        case 0:
+                RenderAccountSummary(Context)
+        default:
+                RenderMessage(Context, "Preferences Error", "Internal error.")
+        }
+}
+
 func HandleAccountCreate(Context *THttpRequestContext) {
         if Context.AccountID > 0 {
                 Redirect(Context, "/account")
@@ -524,6 +785,85 @@ func HandleCharacterCreate(Context *THttpRequestContext) {
         }
 }
 
+func HandleCharacterSex(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        if Context.Request.Method != http.MethodPost {
+                NotFound(Context)
+                return
+        }
+
+        Name := strings.TrimSpace(Context.Request.FormValue("name"))
+        Sex, Err := strconv.Atoi(Context.Request.FormValue("sex"))
+        if Name == "" || Err != nil || (Sex != 1 && Sex != 2) {
+                RenderMessage(Context, "Change Sex Error", "Invalid character or sex.")
+                return
+        }
+
+        Result, Account := GetAccountSummary(Context.AccountID)
+        if Result != 0 {
+                RenderMessage(Context, "Change Sex Error", "Failed to retrieve account information.")
+                return
+        }
+
+        var Character *TCharacterSummary
+        for Index := range Account.Characters {
+                if strings.EqualFold(Account.Characters[Index].Name, Name) {
+                        Character = &Account.Characters[Index]
+                        break
+                }
+        }
+
+        if Character == nil || Character.Deleted {
+                RenderMessage(Context, "Change Sex Error", "That character is not on your account.")
+                return
+        }
+
+        if Character.Online {
+                RenderMessage(Context, "Change Sex Error", "The character must be logged out to change its sex.")
+                return
+        }
+
+        // NOTE: Don't charge premium days for a change that wouldn't do anything.
+        Result, Profile := GetCharacterProfile(Character.Name)
+        if Result != 0 {
+                RenderMessage(Context, "Change Sex Error", "Failed to retrieve character information.")
+                return
+        }
+
+        if Profile.Sex == Sex {
+                RenderMessage(Context, "Change Sex Error", "The character already has that sex.")
+                return
+        }
+
+        if Account.PremiumDays <= g_SexChangePremiumDays {
+                RenderMessage(Context, "Change Sex Error",
+                        fmt.Sprintf("A sex change costs %v premium days and your account must remain premium afterwards.",
+                                g_SexChangePremiumDays))
+                return
+        }
+
+        Result = ChangeCharacterSex(Context.AccountID, Character.Name, Sex, g_SexChangePremiumDays)
+        switch Result {
+        case 0:
+                InvalidateAccountCachedData(Context.AccountID)
+                InvalidateCharacterCachedData(Character.Name)
+                RenderMessage(Context, "Sex Changed",
+                        fmt.Sprintf("%v's sex has been changed.", Character.Name))
+        case 1:
+                RenderMessage(Context, "Change Sex Error", "That character is not on your account.")
+        case 2:
+                RenderMessage(Context, "Change Sex Error", "The character must be logged out to change its sex.")
+        case 3:
+                RenderMessage(Context, "Change Sex Error", "You don't have enough premium days.")
+        default:
+                RenderMessage(Context, "Change Sex Error", "Internal error.")
+        }
+}
+
 func HandleCharacterProfile(Context *THttpRequestContext) {
         QueryValues := Context.Request.URL.Query()
         CharacterName := QueryValues.Get("name")
@@ -552,11 +892,25 @@ func HandleKillStatistics(Context *THttpRequestContext) {
         }
 }
 
+func HandleKillStatisticsCreature(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        WorldName := QueryValues.Get("world")
+        RaceName := QueryValues.Get("race")
+        if WorldName == "" || GetWorld(WorldName) == nil {
+                Redirect(Context, "/world")
+        } else if RaceName == "" {
+                Redirect(Context, "/killstatistics?world="+url.QueryEscape(WorldName))
+        } else {
+                RenderKillStatisticsCreature(Context, WorldName, RaceName)
+        }
+}
+
 func HandleHighscores(Context *THttpRequestContext) {
         QueryValues := Context.Request.URL.Query()
+        World := QueryValues.Get("world")
         Skill := QueryValues.Get("skill")
         Vocation := QueryValues.Get("vocation")
-        RenderHighscores(Context, Skill, Vocation)
+        RenderHighscores(Context, World, Skill, Vocation)
 }
 
 func HandleWorld(Context *THttpRequestContext) {
@@ -569,6 +923,56 @@ func HandleWorld(Context *THttpRequestContext) {
         }
 }
 
+func HandleWorldHistory(Context *THttpRequestContext) {
+        RenderWorldHistory(Context)
+}
+
+func HandleWorldStatus(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        WorldName := QueryValues.Get("name")
+        if WorldName == "" || GetWorld(WorldName) == nil {
+                RenderWorldList(Context)
+        } else {
+                RenderWorldStatus(Context, WorldName)
+        }
+}
+
+func HandleWorldDeaths(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        WorldName := QueryValues.Get("name")
+        if WorldName == "" || GetWorld(WorldName) == nil {
+                RenderWorldList(Context)
+        } else {
+                RenderWorldDeaths(Context, WorldName)
+        }
+}
+
+func HandleWorldDeathsFeed(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        WorldName := QueryValues.Get("name")
+        if WorldName == "" || GetWorld(WorldName) == nil {
+                ResourceError(Context, http.StatusNotFound)
+                return
+        }
+
+        PvPOnly := QueryValues.Get("pvp") != ""
+        MinLevel, _ := strconv.Atoi(QueryValues.Get("minlevel"))
+        Deaths := GetLatestDeaths(WorldName, PvPOnly, MinLevel, g_LatestDeaths)
+
+        Data, Err := BuildDeathsFeed(WorldName, Context.Request.URL.RequestURI(), Deaths)
+        if Err != nil {
+                g_LogErr.Printf("Failed to build deaths feed: %v", Err)
+                ResourceError(Context, http.StatusInternalServerError)
+                return
+        }
+
+        Context.Writer.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+        Context.Writer.Header().Set("Content-Length", strconv.Itoa(len(Data)))
+        if _, Err := Context.Writer.Write(Data); Err != nil {
+                g_LogErr.Printf("Failed to write deaths feed: %v", Err)
+        }
+}
+
 func HandleNews(Context *THttpRequestContext) {
         RenderNews(Context)
 }
@@ -602,39 +1006,74 @@ func HandleHouses(Context *THttpRequestContext) {
 }
 
 func HandleHouseDetail(Context *THttpRequestContext) {
-        HouseIDStr := Context.Request.URL.Query().Get("id")
+        QueryValues := Context.Request.URL.Query()
+        HouseIDStr := QueryValues.Get("id")
         if HouseIDStr == "" {
                 Redirect(Context, "/houses")
                 return
         }
         
-        HouseID, Err := strconv.Atoi(HouseIDStr)
-        if Err != nil {
+        WorldID, Err := strconv.Atoi(QueryValues.Get("world"))
+        HouseID, Err2 := strconv.Atoi(HouseIDStr)
+        if Err != nil || Err2 != nil {
                 BadRequest(Context)
                 return
         }
         
-        House := GetHouse(HouseID)
+        House := GetHouse(WorldID, HouseID)
         if House == nil {
                 RenderMessage(Context, "Not Found", "House not found.")
                 return
         }
         
-        Auction := GetHouseAuctionInfo(HouseID)
+        Auction := GetHouseAuctionInfo(WorldID, HouseID)
         CanBid := false
-        if Context.AccountID > 0 {
-                CanBid = CanBidOnHouse(Context.AccountID, HouseID, House.GuildHouse)
+        var Characters []TAccountCharacter
+        if Context.AccountID > 0 && Auction != nil && Auction.IsActive {
+                Characters = GetAccountWorldCharacters(Context.AccountID, House.World)
+                CanBid = len(Characters) > 0 &&
+                        CanBidOnHouse(Context.AccountID, HouseID, House.GuildHouse)
         }
-        
-        var CurrentCharID int
-        if Context.AccountID > 0 {
-                Result, Account := GetAccountSummary(Context.AccountID)
-                if Result == 0 && len(Account.Characters) > 0 {
-                        CurrentCharID = -1
+
+        var Bids []THouseBid
+        if Auction != nil && Auction.IsActive {
+                Bids = GetHouseBids(WorldID, HouseID, Auction.FinishTime)
+        }
+
+        var Transfer *THouseTransfer
+        IsOwner := false
+        if Context.AccountID > 0 && House.Owner != "" {
+                OwnerID := GetAccountHouseOwner(Context.AccountID, WorldID, HouseID)
+                if OwnerID != 0 {
+                        IsOwner = true
+                        Transfer = GetHouseTransfer(WorldID, HouseID, OwnerID)
                 }
         }
-        
-        RenderHouseDetail(Context, House, Auction, CanBid, CurrentCharID)
+
+        RenderHouseDetail(Context, House, Auction, Bids, CanBid, Characters, IsOwner, Transfer)
+}
+
+func HandleHouseMap(Context *THttpRequestContext) {
+        QueryValues := Context.Request.URL.Query()
+        WorldID, Err := strconv.Atoi(QueryValues.Get("world"))
+        HouseID, Err2 := strconv.Atoi(QueryValues.Get("id"))
+        if Err != nil || Err2 != nil {
+                ResourceError(Context, http.StatusBadRequest)
+                return
+        }
+
+        Data, Ok := RenderHouseMap(GetHouse(WorldID, HouseID))
+        if !Ok {
+                ResourceError(Context, http.StatusNotFound)
+                return
+        }
+
+        Context.Writer.Header().Set("Content-Type", "image/png")
+        Context.Writer.Header().Set("Content-Length", strconv.Itoa(len(Data)))
+        Context.Writer.Header().Set("Cache-Control", "public, max-age=86400")
+        if _, Err := Context.Writer.Write(Data); Err != nil {
+                g_LogErr.Printf("Failed to write house map: %v", Err)
+        }
 }
 
 func HandleHouseBid(Context *THttpRequestContext) {
@@ -648,55 +1087,168 @@ func HandleHouseBid(Context *THttpRequestContext) {
                 return
         }
         
+        WorldIDStr := Context.Request.FormValue("worldid")
         HouseIDStr := Context.Request.FormValue("houseid")
         BidAmountStr := Context.Request.FormValue("bidamount")
+        CharacterIDStr := Context.Request.FormValue("characterid")
         
-        HouseID, Err := strconv.Atoi(HouseIDStr)
+        WorldID, Err := strconv.Atoi(WorldIDStr)
+        HouseID, Err1 := strconv.Atoi(HouseIDStr)
         BidAmount, Err2 := strconv.Atoi(BidAmountStr)
+        CharacterID, Err3 := strconv.Atoi(CharacterIDStr)
         
-        if Err != nil || Err2 != nil || BidAmount <= 0 {
+        if Err != nil || Err1 != nil || Err2 != nil || BidAmount <= 0 {
                 RenderMessage(Context, "Error", "Invalid house or bid amount.")
                 return
         }
+
+        if Err3 != nil || CharacterID <= 0 {
+                RenderMessage(Context, "Error", "You must select a character to bid with.")
+                return
+        }
         
-        House := GetHouse(HouseID)
+        House := GetHouse(WorldID, HouseID)
         if House == nil {
                 RenderMessage(Context, "Error", "House not found.")
                 return
         }
-        
-        if !CanBidOnHouse(Context.AccountID, HouseID, House.GuildHouse) {
-                RenderMessage(Context, "Error", "You cannot bid on this house.")
+
+        Auction := GetHouseAuctionInfo(WorldID, HouseID)
+        if Auction == nil || !Auction.IsActive {
+                RenderMessage(Context, "Error", "This house is not being auctioned.")
                 return
         }
         
-        Result, Account := GetAccountSummary(Context.AccountID)
-        if Result != 0 || len(Account.Characters) == 0 {
-                RenderMessage(Context, "Error", "No characters found.")
+        if !CanBidOnHouse(Context.AccountID, HouseID, House.GuildHouse) {
+                RenderMessage(Context, "Error", "You cannot bid on this house.")
                 return
         }
-        
-        CharID := -1
-        if len(Account.Characters) > 0 {
-                CharID = 1
+
+        // IMPORTANT: The character comes from the form so it must be checked
+        // against the account's own characters on the house's world.
+        var Bidder *TAccountCharacter
+        Characters := GetAccountWorldCharacters(Context.AccountID, House.World)
+        for Index := range Characters {
+                if Characters[Index].CharacterID == CharacterID {
+                        Bidder = &Characters[Index]
+                        break
+                }
         }
-        
-        if CharID <= 0 {
-                RenderMessage(Context, "Error", "Unable to determine character ID.")
+
+        if Bidder == nil {
+                RenderMessage(Context, "Error",
+                        fmt.Sprintf("You can only bid with your own characters from %v.", House.World))
                 return
         }
         
-        BidResult := PlaceHouseBid(HouseID, CharID, BidAmount)
+        BidResult := PlaceHouseBid(WorldID, HouseID, Bidder.CharacterID, BidAmount)
         switch BidResult {
-        case 0:
-                RenderMessage(Context, "Success", fmt.Sprintf("Your bid of %d gold has been placed!", BidAmount))
-        case 1:
+        case HOUSE_BID_OK:
+                RenderMessage(Context, "Success",
+                        fmt.Sprintf("%v placed a bid of %d gold on %v!", Bidder.Name, BidAmount, House.Name))
+        case HOUSE_BID_TOO_LOW:
                 RenderMessage(Context, "Error", "Your bid must be higher than the current bid.")
+        case HOUSE_BID_NOT_ACTIVE:
+                RenderMessage(Context, "Error", "This house is not being auctioned.")
         default:
                 RenderMessage(Context, "Error", "Failed to place bid.")
         }
 }
 
+func RenderHouseTransferResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case HOUSE_TRANSFER_OK:
+                RenderMessage(Context, "Success", Success)
+        case HOUSE_TRANSFER_NOT_OWNER:
+                RenderMessage(Context, "Error", "None of your characters owns this house.")
+        case HOUSE_TRANSFER_INVALID_CHARACTER:
+                RenderMessage(Context, "Error", "The new owner must be another existing character from the same world.")
+        case HOUSE_TRANSFER_SCHEDULED:
+                RenderMessage(Context, "Error", "This house already has a transfer scheduled. Cancel it first.")
+        case HOUSE_TRANSFER_NOT_FOUND:
+                RenderMessage(Context, "Error", "This transfer request is no longer valid.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+func HandleHouseTransfer(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        WorldID, Err := strconv.Atoi(Context.Request.FormValue("worldid"))
+        HouseID, Err1 := strconv.Atoi(Context.Request.FormValue("houseid"))
+        Price, Err2 := strconv.Atoi(Context.Request.FormValue("price"))
+        NewOwner := strings.TrimSpace(Context.Request.FormValue("newowner"))
+        if Err != nil || Err1 != nil || Err2 != nil || Price < 0 || NewOwner == "" {
+                RenderMessage(Context, "Error", "Invalid house, character or price.")
+                return
+        }
+
+        Result := RequestHouseTransfer(Context.AccountID, WorldID, HouseID, NewOwner, Price)
+        RenderHouseTransferResult(Context, Result,
+                fmt.Sprintf("The transfer has been offered to %v. It will be carried out at the next server save once accepted.", html.EscapeString(NewOwner)))
+}
+
+func HandleHouseMoveOut(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        WorldID, Err := strconv.Atoi(Context.Request.FormValue("worldid"))
+        HouseID, Err2 := strconv.Atoi(Context.Request.FormValue("houseid"))
+        if Err != nil || Err2 != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Result := ScheduleHouseMoveOut(Context.AccountID, WorldID, HouseID)
+        RenderHouseTransferResult(Context, Result,
+                "You will move out of the house at the next server save.")
+}
+
+func HandleHouseTransferCancel(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        WorldID, Err := strconv.Atoi(Context.Request.FormValue("worldid"))
+        HouseID, Err2 := strconv.Atoi(Context.Request.FormValue("houseid"))
+        if Err != nil || Err2 != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Result := CancelHouseTransfer(Context.AccountID, WorldID, HouseID)
+        RenderHouseTransferResult(Context, Result, "The house transfer has been cancelled.")
+}
+
+func HandleHouseTransferAnswer(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        RequestID, Err := strconv.Atoi(Context.Request.FormValue("requestid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Accept := Context.Request.FormValue("accept") != ""
+        Result := AnswerHouseTransfer(Context.AccountID, RequestID, Accept)
+        if Accept {
+                RenderHouseTransferResult(Context, Result,
+                        "You accepted the house transfer. It will be carried out at the next server save.")
+        } else {
+                RenderHouseTransferResult(Context, Result, "You declined the house transfer.")
+        }
+}
+
 func HandleGuilds(Context *THttpRequestContext) {
         Guilds := GetGuilds()
         RenderGuilds(Context, Guilds)
@@ -794,12 +1346,44 @@ func HandleGuildDetail(Context *THttpRequestContext) {
         LeaderCharID := GetGuildLeaderCharID(GuildID)
         IsLeader := (Context.AccountID > 0 && IsCharacterLeader(Context.AccountID, GuildID))
         IsViceLeader := (Context.AccountID > 0 && GetCharacterGuildRank(Context.AccountID, GuildID) == 1)
-        HasInvite := false
+        // NOTE: These are the invitations this guild sent to characters of the
+        // account, which can be accepted or declined from here.
+        var AccountInvites []TGuildInvite
         if Context.AccountID > 0 {
-                _, guildID := GetCharacterGuildInvite(Context.AccountID)
-                HasInvite = (guildID == GuildID)
+                for _, Invite := range GetAccountGuildInvites(Context.AccountID) {
+                        if Invite.GuildID == GuildID {
+                                AccountInvites = append(AccountInvites, Invite)
+                        }
+                }
+        }
+        // NOTE: These are the characters that can leave the guild, which the
+        // leader can't.
+        var Characters []TAccountCharacter
+        if Context.AccountID > 0 {
+                for _, Character := range GetAccountGuildCharacters(Context.AccountID, GuildID) {
+                        if Character.CharacterID != LeaderCharID {
+                                Characters = append(Characters, Character)
+                        }
+                }
+        }
+        History := GetGuildHistory(GuildID)
+        LogoUpdated := GetGuildLogoUpdated(GuildID)
+        Wars := GetGuildWars(GuildID)
+        // NOTE: These are the guilds the leader can declare war on.
+        var Enemies []TGuild
+        if IsLeader {
+                for _, Enemy := range GetGuilds() {
+                        if Enemy.GuildID != GuildID {
+                                Enemies = append(Enemies, Enemy)
+                        }
+                }
+        }
+        IsMember := (Context.AccountID > 0 && GetCharacterGuildRank(Context.AccountID, GuildID) >= 0)
+        BoardUnread := 0
+        if IsMember {
+                BoardUnread = CountGuildBoardUnread(GuildID, Context.AccountID)
         }
-        RenderGuildDetail(Context, Guild, Members, Invites, IsLeader, IsViceLeader, LeaderCharID, HasInvite)
+        RenderGuildDetail(Context, Guild, Members, Invites, IsLeader, IsViceLeader, LeaderCharID, AccountInvites, Characters, History, LogoUpdated, Wars, Enemies, IsMember, BoardUnread)
 }
 
 func HandleGuildInvite(Context *THttpRequestContext) {
@@ -825,7 +1409,7 @@ func HandleGuildInvite(Context *THttpRequestContext) {
                 case 1:
                         RenderMessage(Context, "Error", "Character not found.")
                 case 2:
-                        RenderMessage(Context, "Error", "Character is already in the guild.")
+                        RenderMessage(Context, "Error", "Character is already in a guild.")
                 case 3:
                         RenderMessage(Context, "Error", "Character already has a guild invitation.")
                 default:
@@ -898,28 +1482,45 @@ func HandleGuildExpel(Context *THttpRequestContext) {
         }
 }
 
+// ParseGuildInviteForm reads the guild and character of an invitation form.
+func ParseGuildInviteForm(Context *THttpRequestContext) (int, int, bool) {
+        if Context.AccountID <= 0 {
+                RenderMessage(Context, "Error", "You must be logged in to answer a guild invitation.")
+                return 0, 0, false
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return 0, 0, false
+        }
+
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid character.")
+                return 0, 0, false
+        }
+
+        return GuildID, CharacterID, true
+}
+
 func HandleGuildAcceptInvite(Context *THttpRequestContext) {
         if Context.Request.Method == http.MethodPost {
-                if Context.AccountID <= 0 {
-                        RenderMessage(Context, "Error", "You must be logged in to join a guild.")
-                        return
-                }
-
-                GuildIDStr := Context.Request.FormValue("guildid")
-                GuildID, Err := strconv.Atoi(GuildIDStr)
-                if Err != nil {
-                        RenderMessage(Context, "Error", "Invalid guild.")
+                GuildID, CharacterID, Ok := ParseGuildInviteForm(Context)
+                if !Ok {
                         return
                 }
 
-                Result := AcceptGuildInvite(Context.AccountID, GuildID)
+                Result := AcceptGuildInvite(Context.AccountID, GuildID, CharacterID)
                 switch Result {
                 case 0:
                         RenderMessage(Context, "Success", "You have successfully joined the guild!")
                 case 1:
                         RenderMessage(Context, "Error", "Character not found.")
                 case 2:
-                        RenderMessage(Context, "Error", "You don't have an invitation from this guild.")
+                        RenderMessage(Context, "Error", "This character doesn't have an invitation from this guild or it has expired.")
+                case 4:
+                        RenderMessage(Context, "Error", "This character is already in a guild.")
                 default:
                         RenderMessage(Context, "Error", "Failed to join guild.")
                 }
@@ -928,6 +1529,112 @@ func HandleGuildAcceptInvite(Context *THttpRequestContext) {
         }
 }
 
+func HandleGuildDeclineInvite(Context *THttpRequestContext) {
+        if Context.Request.Method == http.MethodPost {
+                GuildID, CharacterID, Ok := ParseGuildInviteForm(Context)
+                if !Ok {
+                        return
+                }
+
+                Result := DeclineGuildInvite(Context.AccountID, GuildID, CharacterID)
+                switch Result {
+                case 0:
+                        RenderMessage(Context, "Success", "The invitation has been declined.")
+                case 1:
+                        RenderMessage(Context, "Error", "This character doesn't have an invitation from this guild.")
+                default:
+                        RenderMessage(Context, "Error", "Failed to decline invitation.")
+                }
+        } else {
+                NotFound(Context)
+        }
+}
+
+func RenderGuildLogoResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_LOGO_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_LOGO_TOO_LARGE:
+                RenderMessage(Context, "Error", fmt.Sprintf("The logo must be at most %v KB.", g_GuildLogoMaxSize/1024))
+        case GUILD_LOGO_BAD_FORMAT:
+                RenderMessage(Context, "Error", "The logo must be a PNG, JPEG or GIF image.")
+        case GUILD_LOGO_BAD_DIMENSIONS:
+                RenderMessage(Context, "Error", fmt.Sprintf("The logo must be at most %vx%v pixels.",
+                        g_GuildLogoMaxDimension, g_GuildLogoMaxDimension))
+        case GUILD_LOGO_NOT_FOUND:
+                RenderMessage(Context, "Error", "The guild doesn't have a logo.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+func HandleGuildLogoUpload(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        // NOTE: The body is capped before the form is parsed so oversized
+        // uploads are cut off instead of being spooled to disk.
+        MaxSize := int64(g_GuildLogoMaxSize)
+        Context.Request.Body = http.MaxBytesReader(Context.Writer,
+                Context.Request.Body, MaxSize+GUILD_LOGO_FORM_OVERHEAD)
+        if Err := Context.Request.ParseMultipartForm(MaxSize + GUILD_LOGO_FORM_OVERHEAD); Err != nil {
+                RenderGuildLogoResult(Context, GUILD_LOGO_TOO_LARGE, "")
+                return
+        }
+        defer Context.Request.MultipartForm.RemoveAll()
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return
+        }
+
+        if !IsCharacterLeader(Context.AccountID, GuildID) {
+                RenderMessage(Context, "Error", "Only the guild leader can change the logo.")
+                return
+        }
+
+        File, _, Err := Context.Request.FormFile("logo")
+        if Err != nil {
+                RenderMessage(Context, "Error", "No logo was uploaded.")
+                return
+        }
+        defer File.Close()
+
+        Data, Err := io.ReadAll(io.LimitReader(File, MaxSize+1))
+        if Err != nil {
+                g_LogErr.Printf("Failed to read guild logo: %v", Err)
+                RenderGuildLogoResult(Context, GUILD_LOGO_ERROR, "")
+                return
+        }
+
+        Result := SetGuildLogo(GuildID, Data)
+        RenderGuildLogoResult(Context, Result, "The guild logo has been updated.")
+}
+
+func HandleGuildLogoRemove(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return
+        }
+
+        if !IsCharacterLeader(Context.AccountID, GuildID) && !IsAccountGamemaster(Context.AccountID) {
+                RenderMessage(Context, "Error", "Only the guild leader or a gamemaster can remove the logo.")
+                return
+        }
+
+        Result := DeleteGuildLogo(GuildID)
+        RenderGuildLogoResult(Context, Result, "The guild logo has been removed.")
+}
+
 func HandleUpdateGuildDescription(Context *THttpRequestContext) {
         if Context.Request.Method == http.MethodPost {
                 GuildIDStr := Context.Request.FormValue("guildid")
@@ -956,71 +1663,945 @@ func HandleUpdateGuildDescription(Context *THttpRequestContext) {
         }
 }
 
-func HandleNewsArchive(Context *THttpRequestContext) {
-        if Context.Request.Method != http.MethodGet {
-                NotFound(Context)
+func HandleGuildManage(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
                 return
         }
 
-        pageStr := Context.Request.URL.Query().Get("page")
+        GuildID, Err := strconv.Atoi(Context.Request.URL.Query().Get("id"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
 
-        page := 1
-        if pageStr != "" {
-                p, err := strconv.Atoi(pageStr)
-                if err == nil && p > 0 {
-                        page = p
-                }
+        Guild := GetGuild(GuildID)
+        if Guild == nil {
+                RenderMessage(Context, "Not Found", "Guild not found.")
+                return
         }
 
-        itemsPerPage := 3
-        news, err := GetNewsPaginated(page, itemsPerPage)
-        
-        Data := NewsArchiveTmplData{
-                Common:      GetCommonTmplData("News Archive", Context.AccountID),
-                SearchNews:  news,
-                HasResults:  len(news) > 0,
-                CurrentPage: page,
-                TotalPages:  1,
-                TotalNews:   0,
+        ActorRank := GetCharacterGuildRank(Context.AccountID, GuildID)
+        if ActorRank != GUILD_RANK_LEADER && ActorRank != GUILD_RANK_VICE_LEADER {
+                RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can manage the guild.")
+                return
         }
 
-        if err == nil {
-                totalCount, err := GetTotalNewsCount()
-                if err == nil {
-                        Data.TotalNews = totalCount
-                        Data.TotalPages = (totalCount + itemsPerPage - 1) / itemsPerPage
-                        if Data.TotalPages < 1 {
-                                Data.TotalPages = 1
-                        }
+        RenderGuildManage(Context, Guild, GetGuildRanks(GuildID), GetGuildMembers(GuildID), ActorRank)
+}
+
+// ParseGuildManageForm returns the guild of a management form along with the
+// rank of the account in it. It renders an error and returns false if the
+// account's rank is lower than `MinRank`.
+func ParseGuildManageForm(Context *THttpRequestContext, MinRank int) (int, int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return 0, 0, false
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return 0, 0, false
+        }
+
+        ActorRank := GetCharacterGuildRank(Context.AccountID, GuildID)
+        if ActorRank < 0 || ActorRank > MinRank {
+                if MinRank == GUILD_RANK_LEADER {
+                        RenderMessage(Context, "Error", "Only the guild leader can manage ranks.")
+                } else {
+                        RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can manage members.")
                 }
+                return 0, 0, false
         }
 
-        RenderNewsArchive(Context, &Data)
+        return GuildID, ActorRank, true
 }
 
-func HandleNewsArchiveView(Context *THttpRequestContext) {
-        if len(Context.Params) == 0 {
-                NotFound(Context)
+func ParseGuildRankName(Context *THttpRequestContext) (string, bool) {
+        Name := strings.TrimSpace(Context.Request.FormValue("name"))
+        if Name == "" || len(Name) > GUILD_RANK_NAME_MAX {
+                RenderMessage(Context, "Error", fmt.Sprintf("Rank names must have between 1 and %v characters.", GUILD_RANK_NAME_MAX))
+                return "", false
+        }
+        return Name, true
+}
+
+func RenderGuildRankResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_RANK_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_RANK_NOT_FOUND:
+                RenderMessage(Context, "Error", "That rank or member no longer exists.")
+        case GUILD_RANK_NOT_ALLOWED:
+                RenderMessage(Context, "Error", "The leader and vice-leader ranks can't be moved, and the default ranks can't be deleted.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+func HandleGuildRankCreate(Context *THttpRequestContext) {
+        GuildID, _, Ok := ParseGuildManageForm(Context, GUILD_RANK_LEADER)
+        if !Ok {
                 return
         }
 
-        newsID, err := strconv.Atoi(Context.Params[0])
-        if err != nil {
+        Name, Ok := ParseGuildRankName(Context)
+        if !Ok {
+                return
+        }
+
+        Result := CreateGuildRank(GuildID, Name)
+        RenderGuildRankResult(Context, Result, fmt.Sprintf("The rank %v has been created.", html.EscapeString(Name)))
+}
+
+func HandleGuildRankRename(Context *THttpRequestContext) {
+        GuildID, _, Ok := ParseGuildManageForm(Context, GUILD_RANK_LEADER)
+        if !Ok {
+                return
+        }
+
+        RankID, Err := strconv.Atoi(Context.Request.FormValue("rankid"))
+        if Err != nil {
                 BadRequest(Context)
                 return
         }
 
-        news, err := GetNewsById(newsID)
-        if err != nil || news == nil {
-                RenderMessage(Context, "Not Found", "News article not found.")
+        Name, Ok := ParseGuildRankName(Context)
+        if !Ok {
                 return
         }
 
-        RenderMessage(Context, news.Title, news.Content)
+        Result := RenameGuildRank(GuildID, RankID, Name)
+        RenderGuildRankResult(Context, Result, fmt.Sprintf("The rank has been renamed to %v.", html.EscapeString(Name)))
 }
 
-func HandleAdminNews(Context *THttpRequestContext) {
-        RenderAdminNews(Context)
+func HandleGuildRankMove(Context *THttpRequestContext) {
+        GuildID, _, Ok := ParseGuildManageForm(Context, GUILD_RANK_LEADER)
+        if !Ok {
+                return
+        }
+
+        RankID, Err := strconv.Atoi(Context.Request.FormValue("rankid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Offset := 1
+        switch Context.Request.FormValue("direction") {
+        case "up":
+                Offset = -1
+        case "down":
+                Offset = 1
+        default:
+                BadRequest(Context)
+                return
+        }
+
+        Result := MoveGuildRank(GuildID, RankID, Offset)
+        RenderGuildRankResult(Context, Result, "The rank has been moved.")
+}
+
+func HandleGuildRankDelete(Context *THttpRequestContext) {
+        GuildID, _, Ok := ParseGuildManageForm(Context, GUILD_RANK_LEADER)
+        if !Ok {
+                return
+        }
+
+        RankID, Err := strconv.Atoi(Context.Request.FormValue("rankid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Result := DeleteGuildRank(GuildID, RankID)
+        RenderGuildRankResult(Context, Result, "The rank has been deleted.")
+}
+
+func HandleGuildMemberRank(Context *THttpRequestContext) {
+        GuildID, ActorRank, Ok := ParseGuildManageForm(Context, GUILD_RANK_VICE_LEADER)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        RankID, Err2 := strconv.Atoi(Context.Request.FormValue("rankid"))
+        if Err != nil || Err2 != nil {
+                BadRequest(Context)
+                return
+        }
+
+        MemberRank := GetGuildMemberRank(GuildID, CharacterID)
+        if MemberRank < 0 {
+                RenderMessage(Context, "Error", "That character is not a member of the guild.")
+                return
+        }
+
+        if !CanChangeGuildMemberRank(ActorRank, MemberRank, RankID) {
+                RenderMessage(Context, "Error", "You can't give that member this rank.")
+                return
+        }
+
+        Result := SetGuildMemberRank(GuildID, CharacterID, RankID)
+        RenderGuildRankResult(Context, Result, fmt.Sprintf("%v is now %v.",
+                html.EscapeString(GetCharacterNameByID(CharacterID)),
+                html.EscapeString(GetRankName(GuildID, RankID))))
+}
+
+func HandleGuildMemberTitle(Context *THttpRequestContext) {
+        GuildID, ActorRank, Ok := ParseGuildManageForm(Context, GUILD_RANK_VICE_LEADER)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Title := strings.TrimSpace(Context.Request.FormValue("title"))
+        if len(Title) > GUILD_TITLE_MAX {
+                RenderMessage(Context, "Error", fmt.Sprintf("Titles can have at most %v characters.", GUILD_TITLE_MAX))
+                return
+        }
+
+        MemberRank := GetGuildMemberRank(GuildID, CharacterID)
+        if MemberRank < 0 {
+                RenderMessage(Context, "Error", "That character is not a member of the guild.")
+                return
+        }
+
+        if !CanChangeGuildMemberTitle(ActorRank, MemberRank) {
+                RenderMessage(Context, "Error", "You can't change the title of that member.")
+                return
+        }
+
+        Result := SetGuildMemberTitle(GuildID, CharacterID, Title)
+        RenderGuildRankResult(Context, Result, "The title has been updated.")
+}
+
+func RenderGuildActionResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_ACTION_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_ACTION_NOT_MEMBER:
+                RenderMessage(Context, "Error", "That character is not a member of the guild.")
+        case GUILD_ACTION_IS_LEADER:
+                RenderMessage(Context, "Error", "The guild leader has to pass the leadership on or disband the guild instead.")
+        case GUILD_ACTION_GUILD_NOT_FOUND:
+                RenderMessage(Context, "Error", "Guild not found.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+// ParseGuildActionForm returns the guild of a leave, disband or leadership
+// form. These can't be undone so the form must also be confirmed.
+func ParseGuildActionForm(Context *THttpRequestContext) (int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return 0, false
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return 0, false
+        }
+
+        if Context.Request.FormValue("confirm") != "1" {
+                RenderMessage(Context, "Error", "Please confirm the action.")
+                return 0, false
+        }
+
+        return GuildID, true
+}
+
+func HandleGuildLeave(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildActionForm(Context)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Result := LeaveGuild(Context.AccountID, GuildID, CharacterID)
+        RenderGuildActionResult(Context, Result, fmt.Sprintf("%v has left the guild.",
+                GetCharacterNameByID(CharacterID)))
+}
+
+func HandleGuildDisband(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildActionForm(Context)
+        if !Ok {
+                return
+        }
+
+        if !IsCharacterLeader(Context.AccountID, GuildID) {
+                RenderMessage(Context, "Error", "Only the guild leader can disband the guild.")
+                return
+        }
+
+        Result := DisbandGuild(GuildID, GetGuildLeaderCharID(GuildID), GUILD_HISTORY_REASON_LEADER)
+        RenderGuildActionResult(Context, Result, "The guild has been disbanded.")
+}
+
+func HandleGuildTransferLeadership(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildActionForm(Context)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        if !IsCharacterLeader(Context.AccountID, GuildID) {
+                RenderMessage(Context, "Error", "Only the guild leader can pass the leadership on.")
+                return
+        }
+
+        Result := TransferGuildLeadership(GuildID, CharacterID)
+        RenderGuildActionResult(Context, Result, fmt.Sprintf("%v is now the leader of the guild.",
+                GetCharacterNameByID(CharacterID)))
+}
+
+func RenderGuildWarResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_WAR_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_WAR_NOT_FOUND:
+                RenderMessage(Context, "Error", "Guild not found.")
+        case GUILD_WAR_SAME_GUILD:
+                RenderMessage(Context, "Error", "A guild can't declare war on itself.")
+        case GUILD_WAR_ALREADY_AT_WAR:
+                RenderMessage(Context, "Error", "There is already a war or a pending declaration between these guilds.")
+        case GUILD_WAR_NOT_PENDING:
+                RenderMessage(Context, "Error", "This war declaration can no longer be answered.")
+        case GUILD_WAR_BAD_TERMS:
+                RenderMessage(Context, "Error", fmt.Sprintf("The frag limit must be between 1 and %v, the duration"+
+                        " between 1 and %v days and the wager between 0 and %v gold.",
+                        GUILD_WAR_MAX_FRAG_LIMIT, GUILD_WAR_MAX_DAYS, GUILD_WAR_MAX_WAGER))
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+// ParseGuildWarForm returns the guild of a war form, whose leader has to be
+// a character of the account.
+func ParseGuildWarForm(Context *THttpRequestContext) (int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return 0, false
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                RenderMessage(Context, "Error", "Invalid guild.")
+                return 0, false
+        }
+
+        if !IsCharacterLeader(Context.AccountID, GuildID) {
+                RenderMessage(Context, "Error", "Only the guild leader can manage wars.")
+                return 0, false
+        }
+
+        return GuildID, true
+}
+
+func HandleGuildWarDeclare(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildWarForm(Context)
+        if !Ok {
+                return
+        }
+
+        // NOTE: The wager is optional.
+        var Errs [4]error
+        var EnemyID, FragLimit, Days, Wager int
+        EnemyID, Errs[0] = strconv.Atoi(Context.Request.FormValue("enemyid"))
+        FragLimit, Errs[1] = strconv.Atoi(Context.Request.FormValue("fraglimit"))
+        Days, Errs[2] = strconv.Atoi(Context.Request.FormValue("days"))
+        if WagerStr := strings.TrimSpace(Context.Request.FormValue("wager")); WagerStr != "" {
+                Wager, Errs[3] = strconv.Atoi(WagerStr)
+        }
+        for _, Err := range Errs {
+                if Err != nil {
+                        RenderGuildWarResult(Context, GUILD_WAR_BAD_TERMS, "")
+                        return
+                }
+        }
+
+        Result := DeclareGuildWar(GuildID, EnemyID, FragLimit, Days, Wager)
+        RenderGuildWarResult(Context, Result, "War has been declared. It starts once the other guild's leader accepts it.")
+}
+
+func HandleGuildWarAnswer(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildWarForm(Context)
+        if !Ok {
+                return
+        }
+
+        WarID, Err := strconv.Atoi(Context.Request.FormValue("warid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Accept := Context.Request.FormValue("accept") != ""
+        Result := AnswerGuildWar(WarID, GuildID, Accept)
+        if Accept {
+                RenderGuildWarResult(Context, Result, "The war has begun.")
+        } else {
+                RenderGuildWarResult(Context, Result, "The war declaration has been rejected.")
+        }
+}
+
+func HandleGuildWarCancel(Context *THttpRequestContext) {
+        GuildID, Ok := ParseGuildWarForm(Context)
+        if !Ok {
+                return
+        }
+
+        WarID, Err := strconv.Atoi(Context.Request.FormValue("warid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Result := CancelGuildWar(WarID, GuildID)
+        RenderGuildWarResult(Context, Result, "The war declaration has been withdrawn.")
+}
+
+func HandleGuildWars(Context *THttpRequestContext) {
+        RenderGuildWars(Context, GetActiveGuildWars(), GetEndedGuildWars(GUILD_WAR_HISTORY_MAX))
+}
+
+func RenderGuildBoardResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_BOARD_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_BOARD_NOT_FOUND:
+                RenderMessage(Context, "Error", "Thread not found.")
+        case GUILD_BOARD_LOCKED:
+                RenderMessage(Context, "Error", "This thread is locked.")
+        case GUILD_BOARD_BAD_TEXT:
+                RenderMessage(Context, "Error", fmt.Sprintf("Titles can't be empty or longer than %v characters"+
+                        " and posts can't be empty. Longer posts are cut at %v characters.",
+                        GUILD_BOARD_TITLE_MAX, GUILD_BOARD_TEXT_MAX))
+        case GUILD_BOARD_NOT_MEMBER:
+                RenderMessage(Context, "Error", "Only members of the guild can use its message board.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+// GetGuildBoardPage returns the page of a board listing, which is clamped to
+// the number of pages by the queries.
+func GetGuildBoardPage(Context *THttpRequestContext) int {
+        Page, Err := strconv.Atoi(Context.Request.URL.Query().Get("page"))
+        if Err != nil || Page < 1 {
+                Page = 1
+        }
+        return Page
+}
+
+// GetGuildBoardThreadForm returns the thread of a board form and the rank of
+// the account in its guild, which has to be a member.
+func GetGuildBoardThreadForm(Context *THttpRequestContext) (*TGuildBoardThread, int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return nil, -1, false
+        }
+
+        ThreadID, Err := strconv.Atoi(Context.Request.FormValue("threadid"))
+        if Err != nil {
+                BadRequest(Context)
+                return nil, -1, false
+        }
+
+        Thread := GetGuildBoardThread(ThreadID, Context.AccountID)
+        if Thread == nil {
+                RenderGuildBoardResult(Context, GUILD_BOARD_NOT_FOUND, "")
+                return nil, -1, false
+        }
+
+        Rank := GetCharacterGuildRank(Context.AccountID, Thread.GuildID)
+        if Rank < 0 {
+                RenderGuildBoardResult(Context, GUILD_BOARD_NOT_MEMBER, "")
+                return nil, -1, false
+        }
+
+        return Thread, Rank, true
+}
+
+// GetGuildBoardCharacterForm returns the character a post is made with, which
+// has to be a member of the guild on the account.
+func GetGuildBoardCharacterForm(Context *THttpRequestContext, GuildID int) (int, bool) {
+        CharacterID, Err := strconv.Atoi(Context.Request.FormValue("characterid"))
+        if Err != nil || !IsGuildBoardCharacter(Context.AccountID, GuildID, CharacterID) {
+                RenderMessage(Context, "Error", "Select a character of yours that is a member of the guild.")
+                return 0, false
+        }
+        return CharacterID, true
+}
+
+func HandleGuildBoard(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.URL.Query().Get("id"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Guild := GetGuild(GuildID)
+        if Guild == nil {
+                RenderMessage(Context, "Not Found", "Guild not found.")
+                return
+        }
+
+        Rank := GetCharacterGuildRank(Context.AccountID, GuildID)
+        if Rank < 0 {
+                RenderGuildBoardResult(Context, GUILD_BOARD_NOT_MEMBER, "")
+                return
+        }
+
+        Page := GetGuildBoardPage(Context)
+        Threads, TotalPages := GetGuildBoardThreads(GuildID, Context.AccountID, Page)
+        Page = min(Page, TotalPages)
+        RenderGuildBoard(Context, Guild, Threads, GetGuildBoardCharacters(Context.AccountID, GuildID),
+                Rank <= GUILD_RANK_VICE_LEADER, Page, TotalPages)
+}
+
+func HandleGuildBoardThread(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        ThreadID, Err := strconv.Atoi(Context.Request.URL.Query().Get("id"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Thread := GetGuildBoardThread(ThreadID, Context.AccountID)
+        if Thread == nil {
+                RenderMessage(Context, "Not Found", "Thread not found.")
+                return
+        }
+
+        Rank := GetCharacterGuildRank(Context.AccountID, Thread.GuildID)
+        if Rank < 0 {
+                RenderGuildBoardResult(Context, GUILD_BOARD_NOT_MEMBER, "")
+                return
+        }
+
+        Guild := GetGuild(Thread.GuildID)
+        if Guild == nil {
+                RenderMessage(Context, "Not Found", "Guild not found.")
+                return
+        }
+
+        Page := GetGuildBoardPage(Context)
+        Posts, TotalPages := GetGuildBoardPosts(ThreadID, Context.AccountID, Page)
+        Page = min(Page, TotalPages)
+        RenderGuildBoardThread(Context, Guild, Thread, Posts, GetGuildBoardCharacters(Context.AccountID, Thread.GuildID),
+                Rank <= GUILD_RANK_VICE_LEADER, Page, TotalPages)
+}
+
+func HandleGuildBoardPost(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        if GetCharacterGuildRank(Context.AccountID, GuildID) < 0 {
+                RenderGuildBoardResult(Context, GUILD_BOARD_NOT_MEMBER, "")
+                return
+        }
+
+        CharacterID, Ok := GetGuildBoardCharacterForm(Context, GuildID)
+        if !Ok {
+                return
+        }
+
+        ThreadID, Result := CreateGuildBoardThread(GuildID, CharacterID,
+                Context.Request.FormValue("title"), Context.Request.FormValue("text"))
+        RenderGuildBoardResult(Context, Result, fmt.Sprintf("Your thread has been posted."+
+                " <a href=\"/guild/board/thread?id=%v\">View thread</a>", ThreadID))
+}
+
+func HandleGuildBoardReply(Context *THttpRequestContext) {
+        Thread, _, Ok := GetGuildBoardThreadForm(Context)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Ok := GetGuildBoardCharacterForm(Context, Thread.GuildID)
+        if !Ok {
+                return
+        }
+
+        // NOTE: The link goes to the last page, where the reply will be.
+        Result := ReplyGuildBoardThread(Thread.ThreadID, CharacterID, Context.Request.FormValue("text"))
+        RenderGuildBoardResult(Context, Result, fmt.Sprintf("Your reply has been posted."+
+                " <a href=\"/guild/board/thread?id=%v&page=%v\">Back to the thread</a>",
+                Thread.ThreadID, GetGuildBoardPageCount(Thread.Posts+1, GUILD_BOARD_POSTS_PER_PAGE)))
+}
+
+func HandleGuildBoardPin(Context *THttpRequestContext) {
+        Thread, Rank, Ok := GetGuildBoardThreadForm(Context)
+        if !Ok {
+                return
+        }
+
+        if Rank > GUILD_RANK_VICE_LEADER {
+                RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can pin threads.")
+                return
+        }
+
+        Result := SetGuildBoardThreadPinned(Thread.ThreadID, !Thread.Pinned)
+        if Thread.Pinned {
+                RenderGuildBoardResult(Context, Result, fmt.Sprintf("The thread has been unpinned."+
+                        " <a href=\"/guild/board?id=%v\">Back to the board</a>", Thread.GuildID))
+        } else {
+                RenderGuildBoardResult(Context, Result, fmt.Sprintf("The thread has been pinned."+
+                        " <a href=\"/guild/board?id=%v\">Back to the board</a>", Thread.GuildID))
+        }
+}
+
+func HandleGuildBoardLock(Context *THttpRequestContext) {
+        Thread, Rank, Ok := GetGuildBoardThreadForm(Context)
+        if !Ok {
+                return
+        }
+
+        if Rank > GUILD_RANK_VICE_LEADER {
+                RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can lock threads.")
+                return
+        }
+
+        Result := SetGuildBoardThreadLocked(Thread.ThreadID, !Thread.Locked)
+        if Thread.Locked {
+                RenderGuildBoardResult(Context, Result, fmt.Sprintf("The thread has been unlocked."+
+                        " <a href=\"/guild/board/thread?id=%v\">Back to the thread</a>", Thread.ThreadID))
+        } else {
+                RenderGuildBoardResult(Context, Result, fmt.Sprintf("The thread has been locked."+
+                        " <a href=\"/guild/board/thread?id=%v\">Back to the thread</a>", Thread.ThreadID))
+        }
+}
+
+func RenderGuildEventResult(Context *THttpRequestContext, Result int, Success string) {
+        switch Result {
+        case GUILD_EVENT_OK:
+                RenderMessage(Context, "Success", Success)
+        case GUILD_EVENT_NOT_FOUND:
+                RenderMessage(Context, "Error", "Event not found.")
+        case GUILD_EVENT_FULL:
+                RenderMessage(Context, "Error", "This event is already full. You can still answer maybe or no.")
+        case GUILD_EVENT_STARTED:
+                RenderMessage(Context, "Error", "This event has already started.")
+        case GUILD_EVENT_BAD_DETAILS:
+                RenderMessage(Context, "Error", fmt.Sprintf("Events need a title of up to %v characters,"+
+                        " a start time in the next %v days and a capacity between 0 and %v.",
+                        GUILD_EVENT_TITLE_MAX, int(GUILD_EVENT_MAX_ADVANCE.Hours()/24), GUILD_EVENT_MAX_CAPACITY))
+        case GUILD_EVENT_BAD_STATUS:
+                RenderMessage(Context, "Error", "Invalid answer.")
+        default:
+                RenderMessage(Context, "Error", "Internal error.")
+        }
+}
+
+// GetGuildEventMemberRank returns the rank of the account in a guild, which
+// has to be a member to see and answer its events.
+func GetGuildEventMemberRank(Context *THttpRequestContext, GuildID int) (int, bool) {
+        Rank := GetCharacterGuildRank(Context.AccountID, GuildID)
+        if Rank < 0 {
+                RenderMessage(Context, "Error", "Only members of the guild can see its events.")
+                return -1, false
+        }
+        return Rank, true
+}
+
+// GetGuildEventForm returns the event of an event form and the rank of the
+// account in its guild.
+func GetGuildEventForm(Context *THttpRequestContext) (*TGuildEvent, int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return nil, -1, false
+        }
+
+        EventID, Err := strconv.Atoi(Context.Request.FormValue("eventid"))
+        if Err != nil {
+                BadRequest(Context)
+                return nil, -1, false
+        }
+
+        Event := GetGuildEvent(EventID)
+        if Event == nil {
+                RenderGuildEventResult(Context, GUILD_EVENT_NOT_FOUND, "")
+                return nil, -1, false
+        }
+
+        Rank, Ok := GetGuildEventMemberRank(Context, Event.GuildID)
+        return Event, Rank, Ok
+}
+
+// GetGuildEventsGuild returns the guild of an events page, whose viewer has to
+// be a member.
+func GetGuildEventsGuild(Context *THttpRequestContext) (*TGuild, int, bool) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return nil, -1, false
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("id"))
+        if Err != nil {
+                BadRequest(Context)
+                return nil, -1, false
+        }
+
+        Guild := GetGuild(GuildID)
+        if Guild == nil {
+                RenderMessage(Context, "Not Found", "Guild not found.")
+                return nil, -1, false
+        }
+
+        Rank, Ok := GetGuildEventMemberRank(Context, GuildID)
+        return Guild, Rank, Ok
+}
+
+func HandleGuildEvents(Context *THttpRequestContext) {
+        Guild, Rank, Ok := GetGuildEventsGuild(Context)
+        if !Ok {
+                return
+        }
+
+        Location := GetContextPreferences(Context).Location
+        Month := GetGuildCalendarMonth(Context.Request.URL.Query().Get("month"), Location)
+        Events := GetGuildEvents(Guild.GuildID, Month.Unix(), Month.AddDate(0, 1, 0).Unix())
+        RenderGuildEvents(Context, Guild, Month, BuildGuildCalendar(Month, Events),
+                GetUpcomingGuildEvents(Guild.GuildID, GUILD_EVENT_UPCOMING_MAX),
+                Rank <= GUILD_RANK_VICE_LEADER)
+}
+
+func HandleGuildEvent(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        EventID, Err := strconv.Atoi(Context.Request.URL.Query().Get("id"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        Event := GetGuildEvent(EventID)
+        if Event == nil {
+                RenderMessage(Context, "Not Found", "Event not found.")
+                return
+        }
+
+        Rank, Ok := GetGuildEventMemberRank(Context, Event.GuildID)
+        if !Ok {
+                return
+        }
+
+        Guild := GetGuild(Event.GuildID)
+        if Guild == nil {
+                RenderMessage(Context, "Not Found", "Guild not found.")
+                return
+        }
+
+        RenderGuildEvent(Context, Guild, Event, GetGuildEventSignups(EventID),
+                GetGuildBoardCharacters(Context.AccountID, Event.GuildID),
+                Rank <= GUILD_RANK_VICE_LEADER)
+}
+
+func HandleGuildEventsExport(Context *THttpRequestContext) {
+        Guild, _, Ok := GetGuildEventsGuild(Context)
+        if !Ok {
+                return
+        }
+
+        Since := time.Now().Add(-GUILD_EVENT_EXPORT_HISTORY).Unix()
+        Events := QueryGuildEvents("WHERE e.GuildID = ? AND e.StartTime >= ?", Guild.GuildID, Since)
+        Data := BuildGuildEventsCalendar(Guild, Events)
+
+        Context.Writer.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+        Context.Writer.Header().Set("Content-Disposition",
+                fmt.Sprintf("attachment; filename=\"guild-%v-events.ics\"", Guild.GuildID))
+        Context.Writer.Header().Set("Content-Length", strconv.Itoa(len(Data)))
+        if _, Err := Context.Writer.Write(Data); Err != nil {
+                g_LogErr.Printf("Failed to write guild events calendar: %v", Err)
+        }
+}
+
+func HandleGuildEventCreate(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 {
+                Redirect(Context, "/account")
+                return
+        }
+
+        GuildID, Err := strconv.Atoi(Context.Request.FormValue("guildid"))
+        if Err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        if !IsCharacterLeaderOrViceLeader(Context.AccountID, GuildID) {
+                RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can schedule events.")
+                return
+        }
+
+        // NOTE: The start time is entered in the time zone of the account, and
+        // the capacity is optional.
+        Location := GetContextPreferences(Context).Location
+        StartTime, Err := time.ParseInLocation(GUILD_EVENT_TIME_FORMAT,
+                Context.Request.FormValue("time"), Location)
+        if Err != nil {
+                RenderGuildEventResult(Context, GUILD_EVENT_BAD_DETAILS, "")
+                return
+        }
+
+        Capacity := 0
+        if CapacityStr := strings.TrimSpace(Context.Request.FormValue("capacity")); CapacityStr != "" {
+                if Capacity, Err = strconv.Atoi(CapacityStr); Err != nil {
+                        RenderGuildEventResult(Context, GUILD_EVENT_BAD_DETAILS, "")
+                        return
+                }
+        }
+
+        EventID, Result := CreateGuildEvent(GuildID, Context.Request.FormValue("title"),
+                Context.Request.FormValue("description"), StartTime.Unix(), Capacity)
+        RenderGuildEventResult(Context, Result, fmt.Sprintf("The event has been scheduled."+
+                " <a href=\"/guild/event?id=%v\">View event</a>", EventID))
+}
+
+func HandleGuildEventDelete(Context *THttpRequestContext) {
+        Event, Rank, Ok := GetGuildEventForm(Context)
+        if !Ok {
+                return
+        }
+
+        if Rank > GUILD_RANK_VICE_LEADER {
+                RenderMessage(Context, "Error", "Only guild leaders and vice-leaders can cancel events.")
+                return
+        }
+
+        Result := DeleteGuildEvent(Event.EventID)
+        RenderGuildEventResult(Context, Result, fmt.Sprintf("The event has been cancelled."+
+                " <a href=\"/guild/events?id=%v\">Back to the calendar</a>", Event.GuildID))
+}
+
+func HandleGuildEventSignup(Context *THttpRequestContext) {
+        Event, _, Ok := GetGuildEventForm(Context)
+        if !Ok {
+                return
+        }
+
+        CharacterID, Ok := GetGuildBoardCharacterForm(Context, Event.GuildID)
+        if !Ok {
+                return
+        }
+
+        Result := SignUpGuildEvent(Event.EventID, CharacterID, Context.Request.FormValue("status"))
+        RenderGuildEventResult(Context, Result, fmt.Sprintf("Your answer has been saved."+
+                " <a href=\"/guild/event?id=%v\">Back to the event</a>", Event.EventID))
+}
+
+func HandleNewsArchive(Context *THttpRequestContext) {
+        if Context.Request.Method != http.MethodGet {
+                NotFound(Context)
+                return
+        }
+
+        pageStr := Context.Request.URL.Query().Get("page")
+
+        page := 1
+        if pageStr != "" {
+                p, err := strconv.Atoi(pageStr)
+                if err == nil && p > 0 {
+                        page = p
+                }
+        }
+
+        itemsPerPage := 3
+        news, err := GetNewsPaginated(page, itemsPerPage)
+        
+        Data := NewsArchiveTmplData{
+                Common:      GetCommonTmplData("News Archive", Context),
+                SearchNews:  news,
+                HasResults:  len(news) > 0,
+                CurrentPage: page,
+                TotalPages:  1,
+                TotalNews:   0,
+        }
+
+        if err == nil {
+                totalCount, err := GetTotalNewsCount()
+                if err == nil {
+                        Data.TotalNews = totalCount
+                        Data.TotalPages = (totalCount + itemsPerPage - 1) / itemsPerPage
+                        if Data.TotalPages < 1 {
+                                Data.TotalPages = 1
+                        }
+                }
+        }
+
+        RenderNewsArchive(Context, &Data)
+}
+
+func HandleNewsArchiveView(Context *THttpRequestContext) {
+        if len(Context.Params) == 0 {
+                NotFound(Context)
+                return
+        }
+
+        newsID, err := strconv.Atoi(Context.Params[0])
+        if err != nil {
+                BadRequest(Context)
+                return
+        }
+
+        news, err := GetNewsById(newsID)
+        if err != nil || news == nil {
+                RenderMessage(Context, "Not Found", "News article not found.")
+                return
+        }
+
+        RenderMessage(Context, news.Title, news.Content)
+}
+
+func HandleAdminNews(Context *THttpRequestContext) {
+        RenderAdminNews(Context)
+}
+
+func HandleAdminEvictions(Context *THttpRequestContext) {
+        if Context.AccountID <= 0 || !IsAccountGamemaster(Context.AccountID) {
+                Forbidden(Context)
+                return
+        }
+
+        RenderAdminEvictions(Context)
 }
 
 func HandleAdminNewsCreate(Context *THttpRequestContext) {
@@ -1128,13 +2709,40 @@ func main() {
         defer ExitMail()
         defer ExitTemplates()
         defer ExitNews()
-        if !InitQuery() || !InitMail() || !InitTemplates() || !InitNews() {
+        defer ExitCharacters()
+        defer ExitOutfits()
+        defer ExitPreferences()
+        defer ExitJobs()
+        defer ExitWorldStatus()
+        defer ExitWorldStats()
+        defer ExitHighscores()
+        defer ExitKillStatistics()
+        defer ExitHouses()
+        defer ExitHouseRent()
+        defer ExitHouseMaps()
+        defer ExitGuildRanks()
+        defer ExitGuilds()
+        defer ExitGuildCleanup()
+        defer ExitGuildLogos()
+        defer ExitGuildWars()
+        defer ExitGuildBoard()
+        defer ExitGuildEvents()
+        if !InitQuery() || !InitMail() || !InitTemplates() || !InitNews() ||
+                !InitCharacters() || !InitOutfits() || !InitPreferences() ||
+                !InitJobs() || !InitWorldStatus() || !InitWorldStats() ||
+                !InitHighscores() || !InitKillStatistics() ||
+                !InitHouses() || !InitHouseRent() || !InitHouseMaps() ||
+                !InitGuildRanks() || !InitGuilds() || !InitGuildCleanup() ||
+                !InitGuildLogos() || !InitGuildWars() || !InitGuildBoard() ||
+                !InitGuildEvents() {
                 return
         }
 
         Router := THttpRouter{}
         Router.Add("GET", "/res/", HandleResource)
         Router.Add("GET", "/favicon.ico", HandleFavicon)
+        Router.Add("GET", "/outfit", HandleOutfit)
+        Router.Add("GET", "/guildlogo/", HandleGuildLogo)
         Router.Add("GET", "/", HandleIndex)
         Router.Add("GET", "/index", HandleIndex)
         Router.Add("GET", "/news", HandleNews)
@@ -1146,21 +2754,35 @@ func main() {
         Router.Add("GET", "/admin/news/edit/", HandleAdminNewsEdit)
         Router.Add("POST", "/admin/news/update/", HandleAdminNewsUpdate)
         Router.Add("POST", "/admin/news/delete/", HandleAdminNewsDelete)
+        Router.Add("GET", "/admin/evictions", HandleAdminEvictions)
         Router.Add("GET", "/account", HandleAccount)
         Router.Add("POST", "/account", HandleAccount)
         Router.Add("GET", "/account/logout", HandleAccountLogout)
+        Router.Add("POST", "/account/preferences", HandleAccountPreferences)
+        Router.Add("POST", "/account/rentreminders", HandleAccountRentReminders)
         Router.Add("GET", "/account/create", HandleAccountCreate)
         Router.Add("POST", "/account/create", HandleAccountCreate)
         Router.Add("GET", "/account/recover", HandleAccountRecover)
         Router.Add("POST", "/account/recover", HandleAccountRecover)
         Router.Add("GET", "/character/create", HandleCharacterCreate)
         Router.Add("POST", "/character/create", HandleCharacterCreate)
+        Router.Add("POST", "/character/sex", HandleCharacterSex)
         Router.Add("GET", "/character", HandleCharacterProfile)
         Router.Add("GET", "/killstatistics", HandleKillStatistics)
+        Router.Add("GET", "/killstatistics/creature", HandleKillStatisticsCreature)
         Router.Add("GET", "/highscores", HandleHighscores)
         Router.Add("GET", "/world", HandleWorld)
+        Router.Add("GET", "/world/history", HandleWorldHistory)
+        Router.Add("GET", "/world/status", HandleWorldStatus)
+        Router.Add("GET", "/world/deaths", HandleWorldDeaths)
+        Router.Add("GET", "/world/deaths/feed", HandleWorldDeathsFeed)
         Router.Add("GET", "/house", HandleHouseDetail)
         Router.Add("POST", "/house/bid", HandleHouseBid)
+        Router.Add("POST", "/house/transfer", HandleHouseTransfer)
+        Router.Add("POST", "/house/transfer/cancel", HandleHouseTransferCancel)
+        Router.Add("POST", "/house/transfer/answer", HandleHouseTransferAnswer)
+        Router.Add("POST", "/house/moveout", HandleHouseMoveOut)
+        Router.Add("GET", "/house/map", HandleHouseMap)
         Router.Add("GET", "/houses", HandleHouses)
         Router.Add("GET", "/guild/create", HandleGuildCreate)
         Router.Add("POST", "/guild/create", HandleGuildCreate)
@@ -1169,8 +2791,37 @@ func main() {
         Router.Add("POST", "/guild/revoke", HandleGuildRevokeInvite)
         Router.Add("POST", "/guild/expel", HandleGuildExpel)
         Router.Add("POST", "/guild/accept-invite", HandleGuildAcceptInvite)
+        Router.Add("POST", "/guild/decline-invite", HandleGuildDeclineInvite)
         Router.Add("POST", "/guild/update-description", HandleUpdateGuildDescription)
+        Router.Add("POST", "/guild/logo", HandleGuildLogoUpload)
+        Router.Add("POST", "/guild/logo/remove", HandleGuildLogoRemove)
+        Router.Add("POST", "/guild/leave", HandleGuildLeave)
+        Router.Add("POST", "/guild/disband", HandleGuildDisband)
+        Router.Add("POST", "/guild/transfer-leadership", HandleGuildTransferLeadership)
+        Router.Add("GET", "/guild/manage", HandleGuildManage)
+        Router.Add("POST", "/guild/rank/create", HandleGuildRankCreate)
+        Router.Add("POST", "/guild/rank/rename", HandleGuildRankRename)
+        Router.Add("POST", "/guild/rank/move", HandleGuildRankMove)
+        Router.Add("POST", "/guild/rank/delete", HandleGuildRankDelete)
+        Router.Add("POST", "/guild/member/rank", HandleGuildMemberRank)
+        Router.Add("POST", "/guild/member/title", HandleGuildMemberTitle)
         Router.Add("GET", "/guilds", HandleGuilds)
+        Router.Add("POST", "/guild/war/declare", HandleGuildWarDeclare)
+        Router.Add("POST", "/guild/war/answer", HandleGuildWarAnswer)
+        Router.Add("POST", "/guild/war/cancel", HandleGuildWarCancel)
+        Router.Add("GET", "/wars", HandleGuildWars)
+        Router.Add("GET", "/guild/board", HandleGuildBoard)
+        Router.Add("GET", "/guild/board/thread", HandleGuildBoardThread)
+        Router.Add("POST", "/guild/board/post", HandleGuildBoardPost)
+        Router.Add("POST", "/guild/board/reply", HandleGuildBoardReply)
+        Router.Add("POST", "/guild/board/pin", HandleGuildBoardPin)
+        Router.Add("POST", "/guild/board/lock", HandleGuildBoardLock)
+        Router.Add("GET", "/guild/events", HandleGuildEvents)
+        Router.Add("GET", "/guild/events.ics", HandleGuildEventsExport)
+        Router.Add("GET", "/guild/event", HandleGuildEvent)
+        Router.Add("POST", "/guild/event/create", HandleGuildEventCreate)
+        Router.Add("POST", "/guild/event/delete", HandleGuildEventDelete)
+        Router.Add("POST", "/guild/event/signup", HandleGuildEventSignup)
         Router.NotFound = NotFound
 
         // NOTE(fusion): Force the server to run on IPv4 because that is the only
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"sync"
+)
+
+// NOTE: The outfit sprite sheet is a PNG made of 64x64 cells. Each row holds
+// one player outfit, starting at look type `OUTFIT_FIRST_LOOKTYPE`, with the
+// base sprite in the first column and its color template in the second. The
+// template uses pure yellow, red, green and blue to mark the head, body, legs
+// and feet areas that get tinted with the outfit colors, like the client does.
+const (
+	OUTFIT_CELL_SIZE       = 64
+	OUTFIT_FIRST_LOOKTYPE  = 128
+	OUTFIT_LAST_LOOKTYPE   = 142
+	OUTFIT_MALE_LOOKTYPE   = 128
+	OUTFIT_FEMALE_LOOKTYPE = 136
+	OUTFIT_MAX_COLOR       = 132
+	OUTFIT_MAX_CACHED      = 256
+)
+
+type (
+	TOutfit struct {
+		LookType int
+		Head     int
+		Body     int
+		Legs     int
+		Feet     int
+	}
+)
+
+var (
+	g_OutfitSheet      image.Image
+	g_OutfitCacheMutex sync.Mutex
+	g_OutfitCache      map[TOutfit][]byte
+)
+
+func InitOutfits() bool {
+	g_Log.Printf("OutfitSpriteSheet: %v", g_OutfitSpriteSheet)
+
+	File, Err := os.Open(g_OutfitSpriteSheet)
+	if Err != nil {
+		// NOTE: Outfit previews are cosmetic so a missing sprite sheet only
+		// disables them instead of preventing the server from starting.
+		g_LogWarn.Printf("Outfit previews disabled: %v", Err)
+		return true
+	}
+	defer File.Close()
+
+	Sheet, Err := png.Decode(File)
+	if Err != nil {
+		g_LogErr.Printf("Failed to decode outfit sprite sheet: %v", Err)
+		return false
+	}
+
+	g_OutfitSheet = Sheet
+	g_OutfitCache = make(map[TOutfit][]byte)
+	return true
+}
+
+func ExitOutfits() {
+	g_OutfitSheet = nil
+	g_OutfitCache = nil
+}
+
+func OutfitsEnabled() bool {
+	return g_OutfitSheet != nil
+}
+
+func DefaultOutfit(Sex int) TOutfit {
+	LookType := OUTFIT_MALE_LOOKTYPE
+	if Sex == 2 {
+		LookType = OUTFIT_FEMALE_LOOKTYPE
+	}
+
+	// NOTE: These are the colors new characters are created with.
+	return TOutfit{
+		LookType: LookType,
+		Head:     78,
+		Body:     69,
+		Legs:     58,
+		Feet:     76,
+	}
+}
+
+func (Outfit TOutfit) Valid() bool {
+	return Outfit.LookType >= OUTFIT_FIRST_LOOKTYPE && Outfit.LookType <= OUTFIT_LAST_LOOKTYPE &&
+		Outfit.Head >= 0 && Outfit.Head <= OUTFIT_MAX_COLOR &&
+		Outfit.Body >= 0 && Outfit.Body <= OUTFIT_MAX_COLOR &&
+		Outfit.Legs >= 0 && Outfit.Legs <= OUTFIT_MAX_COLOR &&
+		Outfit.Feet >= 0 && Outfit.Feet <= OUTFIT_MAX_COLOR
+}
+
+// OutfitColor converts an outfit color index into RGB using the same HSI
+// palette as the client (19 hues by 7 saturation/intensity steps).
+func OutfitColor(Index int) color.RGBA {
+	const HueSteps = 19
+	const SIValues = 7
+	if Index < 0 || Index >= HueSteps*SIValues {
+		Index = 0
+	}
+
+	var Hue, Saturation, Intensity float64
+	if Index%HueSteps != 0 {
+		Hue = float64(Index%HueSteps) / 18.0
+		switch Index / HueSteps {
+		case 0:
+			Saturation, Intensity = 0.25, 1.00
+		case 1:
+			Saturation, Intensity = 0.25, 0.75
+		case 2:
+			Saturation, Intensity = 0.50, 0.75
+		case 3:
+			Saturation, Intensity = 0.667, 0.75
+		case 4:
+			Saturation, Intensity = 1.00, 1.00
+		case 5:
+			Saturation, Intensity = 1.00, 0.75
+		case 6:
+			Saturation, Intensity = 1.00, 0.50
+		}
+	} else {
+		Intensity = 1.0 - float64(Index)/HueSteps/SIValues
+	}
+
+	if Intensity == 0 {
+		return color.RGBA{0, 0, 0, 255}
+	}
+
+	if Saturation == 0 {
+		Gray := uint8(Intensity * 255)
+		return color.RGBA{Gray, Gray, Gray, 255}
+	}
+
+	var Red, Green, Blue float64
+	switch {
+	case Hue < 1.0/6.0:
+		Red = Intensity
+		Blue = Intensity * (1 - Saturation)
+		Green = Blue + (Intensity-Blue)*6*Hue
+	case Hue < 2.0/6.0:
+		Green = Intensity
+		Blue = Intensity * (1 - Saturation)
+		Red = Green - (Intensity-Blue)*(6*Hue-1)
+	case Hue < 3.0/6.0:
+		Green = Intensity
+		Red = Intensity * (1 - Saturation)
+		Blue = Red + (Intensity-Red)*(6*Hue-2)
+	case Hue < 4.0/6.0:
+		Blue = Intensity
+		Red = Intensity * (1 - Saturation)
+		Green = Blue - (Intensity-Red)*(6*Hue-3)
+	case Hue < 5.0/6.0:
+		Blue = Intensity
+		Green = Intensity * (1 - Saturation)
+		Red = Green + (Intensity-Green)*(6*Hue-4)
+	default:
+		Red = Intensity
+		Green = Intensity * (1 - Saturation)
+		Blue = Red - (Intensity-Green)*(6*Hue-5)
+	}
+
+	return color.RGBA{uint8(Red * 255), uint8(Green * 255), uint8(Blue * 255), 255}
+}
+
+func TintOutfitPixel(Base color.RGBA, Tint color.RGBA) color.RGBA {
+	return color.RGBA{
+		R: uint8(int(Base.R) * int(Tint.R) / 255),
+		G: uint8(int(Base.G) * int(Tint.G) / 255),
+		B: uint8(int(Base.B) * int(Tint.B) / 255),
+		A: Base.A,
+	}
+}
+
+func CompositeOutfit(Sheet image.Image, Outfit TOutfit) *image.RGBA {
+	Row := Outfit.LookType - OUTFIT_FIRST_LOOKTYPE
+	Origin := Sheet.Bounds().Min.Add(image.Pt(0, Row*OUTFIT_CELL_SIZE))
+	BaseRect := image.Rect(0, 0, OUTFIT_CELL_SIZE, OUTFIT_CELL_SIZE).Add(Origin)
+	MaskRect := BaseRect.Add(image.Pt(OUTFIT_CELL_SIZE, 0))
+	if !BaseRect.In(Sheet.Bounds()) || !MaskRect.In(Sheet.Bounds()) {
+		return nil
+	}
+
+	Result := image.NewRGBA(image.Rect(0, 0, OUTFIT_CELL_SIZE, OUTFIT_CELL_SIZE))
+	draw.Draw(Result, Result.Bounds(), Sheet, BaseRect.Min, draw.Src)
+
+	Head := OutfitColor(Outfit.Head)
+	Body := OutfitColor(Outfit.Body)
+	Legs := OutfitColor(Outfit.Legs)
+	Feet := OutfitColor(Outfit.Feet)
+	for Y := 0; Y < OUTFIT_CELL_SIZE; Y += 1 {
+		for X := 0; X < OUTFIT_CELL_SIZE; X += 1 {
+			Mask := color.RGBAModel.Convert(Sheet.At(MaskRect.Min.X+X, MaskRect.Min.Y+Y)).(color.RGBA)
+			if Mask.A == 0 {
+				continue
+			}
+
+			Base := Result.RGBAAt(X, Y)
+			switch {
+			case Mask.R > 0 && Mask.G > 0 && Mask.B == 0:
+				Result.SetRGBA(X, Y, TintOutfitPixel(Base, Head))
+			case Mask.R > 0 && Mask.G == 0 && Mask.B == 0:
+				Result.SetRGBA(X, Y, TintOutfitPixel(Base, Body))
+			case Mask.R == 0 && Mask.G > 0 && Mask.B == 0:
+				Result.SetRGBA(X, Y, TintOutfitPixel(Base, Legs))
+			case Mask.R == 0 && Mask.G == 0 && Mask.B > 0:
+				Result.SetRGBA(X, Y, TintOutfitPixel(Base, Feet))
+			}
+		}
+	}
+
+	return Result
+}
+
+func RenderOutfit(Outfit TOutfit) ([]byte, bool) {
+	if g_OutfitSheet == nil || !Outfit.Valid() {
+		return nil, false
+	}
+
+	g_OutfitCacheMutex.Lock()
+	defer g_OutfitCacheMutex.Unlock()
+	if Data, Ok := g_OutfitCache[Outfit]; Ok {
+		return Data, true
+	}
+
+	Image := CompositeOutfit(g_OutfitSheet, Outfit)
+	if Image == nil {
+		g_LogErr.Printf("Outfit sprite sheet has no cell for look type %v", Outfit.LookType)
+		return nil, false
+	}
+
+	var Buffer bytes.Buffer
+	if Err := png.Encode(&Buffer, Image); Err != nil {
+		g_LogErr.Printf("Failed to encode outfit: %v", Err)
+		return nil, false
+	}
+
+	// NOTE: Outfit combinations are bounded but there are a lot of them, so
+	// just start over when the cache gets full.
+	if len(g_OutfitCache) >= OUTFIT_MAX_CACHED {
+		clear(g_OutfitCache)
+	}
+	g_OutfitCache[Outfit] = Buffer.Bytes()
+	return Buffer.Bytes(), true
+}
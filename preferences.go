@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+	_ "time/tzdata"
+)
+
+type (
+	TAccountPreferences struct {
+		AccountID       int
+		MainCharacterID int
+		MainCharacter   string
+		TimeZone        string
+		Language        string
+		Location        *time.Location
+	}
+)
+
+func InitPreferences() bool {
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS AccountPreferences (
+			AccountID INTEGER NOT NULL,
+			MainCharacterID INTEGER NOT NULL DEFAULT 0,
+			TimeZone TEXT NOT NULL DEFAULT '',
+			Language TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (AccountID)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create account preferences table: %v", Err)
+		return false
+	}
+
+	return true
+}
+
+func ExitPreferences() {
+	// no-op
+}
+
+func LoadTimeZone(TimeZone string) (*time.Location, bool) {
+	// NOTE: An empty time zone means server local time. `time.LoadLocation`
+	// would map it to UTC instead.
+	if TimeZone == "" {
+		return time.Local, true
+	}
+
+	Location, Err := time.LoadLocation(TimeZone)
+	if Err != nil {
+		return nil, false
+	}
+	return Location, true
+}
+
+func DefaultAccountPreferences(AccountID int) TAccountPreferences {
+	return TAccountPreferences{
+		AccountID: AccountID,
+		Language:  DEFAULT_LANGUAGE,
+		Location:  time.Local,
+	}
+}
+
+func GetAccountPreferences(AccountID int) TAccountPreferences {
+	Preferences := DefaultAccountPreferences(AccountID)
+	if AccountID <= 0 || g_NewsDb == nil {
+		return Preferences
+	}
+
+	var MainCharacter sql.NullString
+	Err := g_NewsDb.QueryRow(`
+		SELECT p.MainCharacterID, c.Name, p.TimeZone, p.Language
+		FROM AccountPreferences p
+		LEFT JOIN Characters c
+			ON c.CharacterID = p.MainCharacterID AND c.AccountID = p.AccountID
+		WHERE p.AccountID = ?
+	`, AccountID).Scan(&Preferences.MainCharacterID, &MainCharacter,
+		&Preferences.TimeZone, &Preferences.Language)
+	if Err != nil {
+		if Err != sql.ErrNoRows {
+			g_LogErr.Printf("Failed to query account preferences: %v", Err)
+		}
+		return DefaultAccountPreferences(AccountID)
+	}
+
+	if MainCharacter.Valid {
+		Preferences.MainCharacter = MainCharacter.String
+	} else {
+		Preferences.MainCharacterID = 0
+	}
+
+	if Location, Ok := LoadTimeZone(Preferences.TimeZone); Ok {
+		Preferences.Location = Location
+	} else {
+		g_LogWarn.Printf("Account %v has an invalid time zone \"%v\"",
+			AccountID, Preferences.TimeZone)
+		Preferences.TimeZone = ""
+	}
+
+	if !IsTemplateLanguage(Preferences.Language) {
+		Preferences.Language = DEFAULT_LANGUAGE
+	}
+
+	return Preferences
+}
+
+func SetAccountPreferences(AccountID int, MainCharacterID int, TimeZone string, Language string) int {
+	if g_NewsDb == nil {
+		return 1
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		INSERT INTO AccountPreferences (AccountID, MainCharacterID, TimeZone, Language)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (AccountID) DO UPDATE SET
+			MainCharacterID = excluded.MainCharacterID,
+			TimeZone = excluded.TimeZone,
+			Language = excluded.Language
+	`, AccountID, MainCharacterID, TimeZone, Language)
+	if Err != nil {
+		g_LogErr.Printf("Failed to update account preferences: %v", Err)
+		return 1
+	}
+
+	return 0
+}
+
+func GetContextPreferences(Context *THttpRequestContext) *TAccountPreferences {
+	// NOTE: Preferences are loaded at most once per request. The account may
+	// change in the middle of a request when logging in, in which case they're
+	// loaded again.
+	if Context.Preferences == nil || Context.Preferences.AccountID != Context.AccountID {
+		Preferences := GetAccountPreferences(Context.AccountID)
+		Context.Preferences = &Preferences
+	}
+	return Context.Preferences
+}
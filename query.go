@@ -36,9 +36,17 @@ const (
         QUERY_CREATE_CHARACTER       = 101
         QUERY_GET_ACCOUNT_SUMMARY    = 102
         QUERY_GET_CHARACTER_PROFILE  = 103
+        QUERY_CHANGE_CHARACTER_SEX   = 104
+        QUERY_GET_CHARACTER_OUTFIT   = 105
         QUERY_GET_WORLDS             = 150
         QUERY_GET_ONLINE_CHARACTERS  = 151
         QUERY_GET_KILL_STATISTICS    = 152
+        QUERY_GET_HIGHSCORES         = 153
+        QUERY_ASSIGN_HOUSE           = 154
+)
+
+const (
+        HIGHSCORES_MAX_ENTRIES = 300
 )
 
 type (
@@ -75,6 +83,7 @@ type (
                 Name        string
                 World       string
                 Sex         int
+                Outfit      TOutfit
                 Guild       string
                 Rank        string
                 Title       string
@@ -85,6 +94,10 @@ type (
                 PremiumDays int
                 Online      bool
                 Deleted     bool
+                FormerNames []string
+                Namelocked  bool
+                Banishment  *TBanishment
+                Highscores  []TCharacterHighscore
         }
 
         TKillStatistics struct {
@@ -99,6 +112,12 @@ type (
                 Profession string
         }
 
+        TAccountCharacter struct {
+                CharacterID int
+                Name        string
+                Level       int
+        }
+
         TAccountCacheEntry struct {
                 AccountID  int
                 Result     int
@@ -125,6 +144,14 @@ type (
                 RefreshTime time.Time
         }
 
+        THighscoresCacheEntry struct {
+                World       string
+                Skill       string
+                Vocation    string
+                Data        []THighscore
+                RefreshTime time.Time
+        }
+
         TQueryManagerConnection struct {
                 Handle net.Conn
         }
@@ -410,6 +437,87 @@ func (Connection *TQueryManagerConnection) GetCharacterProfile(CharacterName str
         return
 }
 
+func (Connection *TQueryManagerConnection) GetCharacterOutfit(CharacterName string) (Result int, Outfit TOutfit) {
+        var Buffer [1024]byte
+        WriteBuffer := Connection.PrepareQuery(QUERY_GET_CHARACTER_OUTFIT, Buffer[:])
+        WriteBuffer.WriteString(CharacterName)
+        Status, ReadBuffer := Connection.ExecuteQuery(true, &WriteBuffer)
+        Result = -1
+        switch Status {
+        case QUERY_STATUS_OK:
+                Result = 0
+                Outfit.LookType = int(ReadBuffer.Read16())
+                Outfit.Head = int(ReadBuffer.Read8())
+                Outfit.Body = int(ReadBuffer.Read8())
+                Outfit.Legs = int(ReadBuffer.Read8())
+                Outfit.Feet = int(ReadBuffer.Read8())
+        case QUERY_STATUS_ERROR:
+                ErrorCode := int(ReadBuffer.Read8())
+                if ErrorCode == 1 {
+                        Result = ErrorCode
+                } else {
+                        g_LogErr.Printf("Invalid error code %v", ErrorCode)
+                }
+        default:
+                g_LogErr.Printf("Request failed (%v)", Status)
+        }
+        return
+}
+
+func (Connection *TQueryManagerConnection) ChangeCharacterSex(AccountID int, CharacterName string, Sex int, PremiumDays int) (Result int) {
+        var Buffer [1024]byte
+        WriteBuffer := Connection.PrepareQuery(QUERY_CHANGE_CHARACTER_SEX, Buffer[:])
+        WriteBuffer.Write32(uint32(AccountID))
+        WriteBuffer.WriteString(CharacterName)
+        WriteBuffer.Write8(uint8(Sex))
+        WriteBuffer.Write16(uint16(PremiumDays))
+        Status, ReadBuffer := Connection.ExecuteQuery(true, &WriteBuffer)
+        Result = -1
+        switch Status {
+        case QUERY_STATUS_OK:
+                Result = 0
+        case QUERY_STATUS_ERROR:
+                ErrorCode := int(ReadBuffer.Read8())
+                if ErrorCode >= 1 && ErrorCode <= 3 {
+                        Result = ErrorCode
+                } else {
+                        g_LogErr.Printf("Invalid error code %v", ErrorCode)
+                }
+        default:
+                g_LogErr.Printf("Request failed (%v)", Status)
+        }
+        return
+}
+
+// AssignHouse hands a house to the winner of its auction. The game charges the
+// price from the character's bank account and fails with error code 2 if it
+// can't be paid. Error code 1 means the character doesn't exist and 3 that the
+// house already has an owner.
+func (Connection *TQueryManagerConnection) AssignHouse(World string, HouseID int, CharacterID int, Price int) (Result int) {
+        var Buffer [1024]byte
+        WriteBuffer := Connection.PrepareQuery(QUERY_ASSIGN_HOUSE, Buffer[:])
+        WriteBuffer.WriteString(World)
+        WriteBuffer.Write16(uint16(HouseID))
+        WriteBuffer.Write32(uint32(CharacterID))
+        WriteBuffer.Write32(uint32(Price))
+        Status, ReadBuffer := Connection.ExecuteQuery(true, &WriteBuffer)
+        Result = -1
+        switch Status {
+        case QUERY_STATUS_OK:
+                Result = 0
+        case QUERY_STATUS_ERROR:
+                ErrorCode := int(ReadBuffer.Read8())
+                if ErrorCode >= 1 && ErrorCode <= 3 {
+                        Result = ErrorCode
+                } else {
+                        g_LogErr.Printf("Invalid error code %v", ErrorCode)
+                }
+        default:
+                g_LogErr.Printf("Request failed (%v)", Status)
+        }
+        return
+}
+
 func (Connection *TQueryManagerConnection) GetWorlds() (Result int, Worlds []TWorld) {
         var Buffer [16384]byte
         WriteBuffer := Connection.PrepareQuery(QUERY_GET_WORLDS, Buffer[:])
@@ -486,6 +594,49 @@ func (Connection *TQueryManagerConnection) GetKillStatistics(World string) (Resu
         return
 }
 
+// NOTE: Deleted and gamemaster characters are left out by the query manager,
+// so the reply holds at most `MaxEntries` characters that can be shown as is.
+func (Connection *TQueryManagerConnection) GetHighscores(World string, Skill string, Vocation string, MaxEntries int) (Result int, Highscores []THighscore) {
+        var Buffer [65536]byte
+        WriteBuffer := Connection.PrepareQuery(QUERY_GET_HIGHSCORES, Buffer[:])
+        WriteBuffer.WriteString(World)
+        WriteBuffer.WriteString(Skill)
+        WriteBuffer.WriteString(Vocation)
+        WriteBuffer.Write16(uint16(MaxEntries))
+        Status, ReadBuffer := Connection.ExecuteQuery(true, &WriteBuffer)
+        Result = -1
+        switch Status {
+        case QUERY_STATUS_OK:
+                Result = 0
+                NumHighscores := int(ReadBuffer.Read16())
+                if NumHighscores > MaxEntries {
+                        g_LogErr.Printf("Too many highscores (%v, expected at most %v)", NumHighscores, MaxEntries)
+                        Result = -1
+                        NumHighscores = 0
+                }
+
+                if NumHighscores > 0 {
+                        Highscores = make([]THighscore, NumHighscores)
+                        for Index := 0; Index < NumHighscores; Index += 1 {
+                                Highscores[Index].CharacterName = ReadBuffer.ReadString()
+                                Highscores[Index].Profession = ReadBuffer.ReadString()
+                                Highscores[Index].Level = int(ReadBuffer.Read16())
+                                Highscores[Index].SkillValue = int(ReadBuffer.Read32())
+                        }
+                }
+        case QUERY_STATUS_ERROR:
+                ErrorCode := int(ReadBuffer.Read8())
+                if ErrorCode >= 1 && ErrorCode <= 2 {
+                        Result = ErrorCode
+                } else {
+                        g_LogErr.Printf("Invalid error code %v", ErrorCode)
+                }
+        default:
+                g_LogErr.Printf("Request failed (%v)", Status)
+        }
+        return
+}
+
 // Query Subsystem
 // ==============================================================================
 var (
@@ -498,6 +649,7 @@ var (
         g_WorldCacheRefreshTime time.Time
         g_OnlineCharactersCache []TOnlineCharactersCacheEntry
         g_KillStatisticsCache   []TKillStatisticsCacheEntry
+        g_HighscoresCache       []THighscoresCacheEntry
 )
 
 func InitQuery() bool {
@@ -507,6 +659,7 @@ func InitQuery() bool {
         g_Log.Printf("MaxCachedCharacters: %v", g_MaxCachedCharacters)
         g_Log.Printf("CharacterRefreshInterval: %v", g_CharacterRefreshInterval)
         g_Log.Printf("WorldRefreshInterval: %v", g_WorldRefreshInterval)
+        g_Log.Printf("HighscoresRefreshInterval: %v", g_HighscoresRefreshInterval)
 
         Result := g_QueryManagerConnection.Connect()
         if !Result {
@@ -537,6 +690,18 @@ func CreateCharacter(World string, AccountID int, Name string, Sex int) int {
         return g_QueryManagerConnection.CreateCharacter(World, AccountID, Name, Sex)
 }
 
+func ChangeCharacterSex(AccountID int, CharacterName string, Sex int, PremiumDays int) int {
+        g_QueryManagerMutex.Lock()
+        defer g_QueryManagerMutex.Unlock()
+        return g_QueryManagerConnection.ChangeCharacterSex(AccountID, CharacterName, Sex, PremiumDays)
+}
+
+func AssignHouse(World string, HouseID int, CharacterID int, Price int) int {
+        g_QueryManagerMutex.Lock()
+        defer g_QueryManagerMutex.Unlock()
+        return g_QueryManagerConnection.AssignHouse(World, HouseID, CharacterID, Price)
+}
+
 func GetAccountSummary(AccountID int) (Result int, Account TAccountSummary) {
         g_QueryManagerMutex.Lock()
         defer g_QueryManagerMutex.Unlock()
@@ -630,6 +795,17 @@ func GetCharacterProfile(CharacterName string) (Result int, Character TCharacter
 
         if Entry == nil {
                 Result, Character = g_QueryManagerConnection.GetCharacterProfile(CharacterName)
+                if Result == 0 {
+                        // NOTE: The outfit is only used for the profile preview so we fall
+                        // back to the default one for the character's sex if the query
+                        // manager can't tell us.
+                        var OutfitResult int
+                        OutfitResult, Character.Outfit = g_QueryManagerConnection.GetCharacterOutfit(CharacterName)
+                        if OutfitResult != 0 || !Character.Outfit.Valid() {
+                                Character.Outfit = DefaultOutfit(Character.Sex)
+                        }
+                        LoadCharacterPublicRecord(&Character)
+                }
                 Entry = &g_CharacterCache[LeastRecentlyUsedIndex]
                 Entry.CharacterName = CharacterName
                 Entry.Result = Result
@@ -644,6 +820,17 @@ func GetCharacterProfile(CharacterName string) (Result int, Character TCharacter
         return
 }
 
+func InvalidateCharacterCachedData(CharacterName string) {
+        g_QueryManagerMutex.Lock()
+        defer g_QueryManagerMutex.Unlock()
+        for Index := 0; Index < len(g_CharacterCache); Index += 1 {
+                if strings.EqualFold(g_CharacterCache[Index].CharacterName, CharacterName) {
+                        g_CharacterCache[Index] = TCharacterCacheEntry{}
+                        break
+                }
+        }
+}
+
 func GetWorlds() []TWorld {
         g_QueryManagerMutex.Lock()
         defer g_QueryManagerMutex.Unlock()
@@ -664,6 +851,21 @@ func GetWorlds() []TWorld {
         return g_WorldCache
 }
 
+// RefreshWorlds queries the world list right away, bypassing the cache, and
+// stores the result for `GetWorlds`. It returns nil if the query fails.
+func RefreshWorlds() []TWorld {
+        g_QueryManagerMutex.Lock()
+        defer g_QueryManagerMutex.Unlock()
+        Result, Worlds := g_QueryManagerConnection.GetWorlds()
+        if Result != 0 {
+                return nil
+        }
+
+        g_WorldCache = Worlds
+        g_WorldCacheRefreshTime = time.Now().Add(g_WorldRefreshInterval)
+        return Worlds
+}
+
 func GetWorld(World string) *TWorld {
         Worlds := GetWorlds()
         for Index := range Worlds {
@@ -762,49 +964,190 @@ func GetKillStatistics(World string) []TKillStatistics {
         }
 }
 
-func GetHighscores(Skill string, Vocation string) []THighscore {
-        // Return empty highscores list
-        return []THighscore{}
+// GetHighscores returns the ranked characters of a world for a given skill,
+// optionally limited to one base vocation (see `VocationString`). Each vocation
+// is ranked and cached on its own, so ranks are positions within that list.
+func GetHighscores(World string, Skill string, Vocation string) []THighscore {
+        g_QueryManagerMutex.Lock()
+        defer g_QueryManagerMutex.Unlock()
+
+        var Entry *THighscoresCacheEntry
+        for Index := 0; Index < len(g_HighscoresCache); Index += 1 {
+                Current := &g_HighscoresCache[Index]
+                if time.Until(Current.RefreshTime) <= 0 {
+                        g_HighscoresCache = SwapAndPop(g_HighscoresCache, Index)
+                        Index -= 1
+                        continue
+                }
+
+                if strings.EqualFold(Current.World, World) && Current.Skill == Skill && Current.Vocation == Vocation {
+                        Entry = Current
+                        break
+                }
+        }
+
+        if Entry == nil {
+                Result, Highscores := g_QueryManagerConnection.GetHighscores(World, Skill, Vocation,
+                        HIGHSCORES_MAX_ENTRIES)
+                if Result == 0 {
+                        for Index := range Highscores {
+                                Highscores[Index].Rank = Index + 1
+                        }
+                } else {
+                        // Demo mode: return empty highscores list
+                        Highscores = []THighscore{}
+                }
+
+                g_HighscoresCache = append(g_HighscoresCache, THighscoresCacheEntry{})
+                Entry = &g_HighscoresCache[len(g_HighscoresCache)-1]
+                Entry.World = World
+                Entry.Skill = Skill
+                Entry.Vocation = Vocation
+                Entry.Data = Highscores
+                Entry.RefreshTime = time.Now().Add(g_HighscoresRefreshInterval)
+        }
+
+        return Entry.Data
 }
 
+// NOTE: Houses are rented when they have an owner and auctioned when there is
+// an entry in `HouseAuctions`. Anything else is free.
+const HOUSE_QUERY = `
+        SELECT h.WorldID, h.HouseID, w.Name, h.Name, h.Rent, h.Description, h.Size, h.Town,
+                h.GuildHouse, COALESCE(c.Name, ''), COALESCE(ho.PaidUntil, 0),
+                ha.HouseID IS NOT NULL, COALESCE(ha.BidAmount, 0), COALESCE(ha.FinishTime, 0),
+                h.PositionX, h.PositionY, h.PositionZ
+        FROM Houses h
+        LEFT JOIN Worlds w ON w.WorldID = h.WorldID
+        LEFT JOIN HouseOwners ho ON ho.WorldID = h.WorldID AND ho.HouseID = h.HouseID
+        LEFT JOIN Characters c ON c.CharacterID = ho.OwnerID
+        LEFT JOIN HouseAuctions ha ON ha.WorldID = h.WorldID AND ha.HouseID = h.HouseID
+`
+
+func ScanHouse(Row interface{ Scan(...any) error }) (THouse, error) {
+        var House THouse
+        var WorldName sql.NullString
+        var Auctioned bool
+        Err := Row.Scan(&House.WorldID, &House.HouseID, &WorldName, &House.Name, &House.Rent,
+                &House.Description, &House.Size, &House.Town, &House.GuildHouse,
+                &House.Owner, &House.PaidUntil, &Auctioned, &House.HighestBid,
+                &House.AuctionEnd, &House.PositionX, &House.PositionY, &House.PositionZ)
+        if Err != nil {
+                return House, Err
+        }
+
+        House.World = WorldName.String
+        if House.Owner != "" {
+                House.Status = "Rented"
+        } else if Auctioned {
+                House.Status = "Auctioned"
+        } else {
+                House.Status = "Free"
+        }
+        return House, nil
+}
+
+// GetHouses returns the houses matching the filters from the houses page. An
+// empty or "all" town matches every town, `HouseType` is 1 for guildhouses and
+// 0 for regular houses, and `Status` is 0 for all, 1 for auctioned or 2 for
+// rented houses.
 func GetHouses(Town string, HouseType int, Status int) []THouse {
-        // TODO: Implement database query for houses
-        // For now return empty list
-        return []THouse{}
+        if g_NewsDb == nil {
+                return []THouse{}
+        }
+
+        Query := HOUSE_QUERY + " WHERE (h.GuildHouse != 0) = ?"
+        Args := []any{HouseType == 1}
+        if Town != "" && Town != "all" {
+                Query += " AND h.Town = ?"
+                Args = append(Args, Town)
+        }
+
+        switch Status {
+        case 1:
+                Query += " AND ho.HouseID IS NULL AND ha.HouseID IS NOT NULL"
+        case 2:
+                Query += " AND ho.HouseID IS NOT NULL"
+        }
+        Query += " ORDER BY w.Name, h.Town, h.Name"
+
+        Rows, Err := g_NewsDb.Query(Query, Args...)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query houses: %v", Err)
+                return []THouse{}
+        }
+        defer Rows.Close()
+
+        Houses := []THouse{}
+        for Rows.Next() {
+                House, Err := ScanHouse(Rows)
+                if Err != nil {
+                        g_LogErr.Printf("Failed to scan house: %v", Err)
+                        continue
+                }
+                Houses = append(Houses, House)
+        }
+        return Houses
 }
 
-func GetHouse(HouseID int) *THouse {
-        // TODO: Implement database query for single house
-        return nil
+// GetHouse returns a single house. House ids are only unique within a world
+// so both are needed to identify it.
+func GetHouse(WorldID int, HouseID int) *THouse {
+        if g_NewsDb == nil {
+                return nil
+        }
+
+        House, Err := ScanHouse(g_NewsDb.QueryRow(HOUSE_QUERY+" WHERE h.WorldID = ? AND h.HouseID = ?", WorldID, HouseID))
+        if Err != nil {
+                if Err != sql.ErrNoRows {
+                        g_LogErr.Printf("Failed to query house %v on world %v: %v", HouseID, WorldID, Err)
+                }
+                return nil
+        }
+        return &House
 }
 
-func GetGuildHouseID(OwnerCharacterID int) int {
+func GetGuildHouseID(OwnerCharacterID int) (worldID int, houseID int) {
         if g_NewsDb == nil {
-                return 0
+                return 0, 0
         }
 
-        var houseID int
         err := g_NewsDb.QueryRow(`
-                SELECT houseid FROM houses WHERE owner = ? AND guildhouse = true LIMIT 1
-        `, OwnerCharacterID).Scan(&houseID)
+                SELECT h.WorldID, h.HouseID FROM Houses h
+                JOIN HouseOwners ho ON ho.WorldID = h.WorldID AND ho.HouseID = h.HouseID
+                WHERE ho.OwnerID = ? AND h.GuildHouse != 0 LIMIT 1
+        `, OwnerCharacterID).Scan(&worldID, &houseID)
         if err != nil {
                 // No guild house found
-                return 0
+                return 0, 0
         }
-        return houseID
+        return worldID, houseID
 }
 
 func GetTowns() []string {
-        towns := []string{
-                "Ab'Dendriel",
-                "Carlin",
-                "Darashia",
-                "Edron",
-                "Kazordoon",
-                "Thais",
-                "Venore",
+        if g_NewsDb == nil {
+                return []string{}
+        }
+
+        Rows, Err := g_NewsDb.Query(`
+                SELECT DISTINCT Town FROM Houses WHERE Town != '' ORDER BY Town
+        `)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query towns: %v", Err)
+                return []string{}
         }
-        return towns
+        defer Rows.Close()
+
+        Towns := []string{}
+        for Rows.Next() {
+                var Town string
+                if Err := Rows.Scan(&Town); Err != nil {
+                        g_LogErr.Printf("Failed to scan town: %v", Err)
+                        continue
+                }
+                Towns = append(Towns, Town)
+        }
+        return Towns
 }
 
 func GetGuilds() []TGuild {
@@ -840,17 +1183,7 @@ func GetGuilds() []TGuild {
                 }
                 
                 guild.Leader = GetCharacterNameByID(leaderID)
-                guild.GuildHouseID = GetGuildHouseID(leaderID)
-                
-                // Calculate last activity from members' joined timestamps
-                var lastActivity int
-                err = g_NewsDb.QueryRow(`
-                        SELECT COALESCE(MAX(joined), 0) FROM guildmembers WHERE guildid = ?
-                `, guild.GuildID).Scan(&lastActivity)
-                if err != nil {
-                        lastActivity = guild.Created
-                }
-                guild.LastActivity = lastActivity
+                guild.GuildHouseWorldID, guild.GuildHouseID = GetGuildHouseID(leaderID)
                 
                 // Count vice leaders (rank = 1)
                 var viceLeaderCount int
@@ -862,15 +1195,7 @@ func GetGuilds() []TGuild {
                 }
                 guild.ViceLeaderCount = viceLeaderCount
                 
-                // Calculate if guild is inactive (no activity for 2 weeks = 1209600 seconds)
-                now := time.Now().Unix()
-                inactivityTime := int64(1209600) // 2 weeks
-                if int64(guild.LastActivity) > 0 && (now - int64(guild.LastActivity)) > inactivityTime {
-                        guild.IsInactive = true
-                        guild.DisbandDate = guild.LastActivity + 1209600
-                } else {
-                        guild.IsInactive = false
-                }
+                SetGuildDisbandStatus(&guild)
                 
                 guilds = append(guilds, guild)
         }
@@ -919,17 +1244,7 @@ func GetGuild(GuildID int) *TGuild {
         }
 
         guild.Leader = GetCharacterNameByID(leaderID)
-        guild.GuildHouseID = GetGuildHouseID(leaderID)
-        
-        // Calculate last activity from members' joined timestamps
-        var lastActivity int
-        err = g_NewsDb.QueryRow(`
-                SELECT COALESCE(MAX(joined), 0) FROM guildmembers WHERE guildid = ?
-        `, GuildID).Scan(&lastActivity)
-        if err != nil {
-                lastActivity = guild.Created
-        }
-        guild.LastActivity = lastActivity
+        guild.GuildHouseWorldID, guild.GuildHouseID = GetGuildHouseID(leaderID)
         
         // Count vice leaders (rank = 1)
         var viceLeaderCount int
@@ -941,30 +1256,16 @@ func GetGuild(GuildID int) *TGuild {
         }
         guild.ViceLeaderCount = viceLeaderCount
         
-        // Calculate if guild is inactive (no activity for 2 weeks = 1209600 seconds)
-        now := time.Now().Unix()
-        inactivityTime := int64(1209600) // 2 weeks
-        if int64(guild.LastActivity) > 0 && (now - int64(guild.LastActivity)) > inactivityTime {
-                guild.IsInactive = true
-                guild.DisbandDate = guild.LastActivity + 1209600
-        } else {
-                guild.IsInactive = false
-        }
+        SetGuildDisbandStatus(&guild)
         
         return &guild
 }
 
-func GetRankName(RankID int) string {
-        switch RankID {
-        case 0:
-                return "Leader"
-        case 1:
-                return "Vice-Leader"
-        case 2:
-                return "Member"
-        default:
-                return "Unknown"
+func GetRankName(GuildID int, RankID int) string {
+        if Name, Ok := GetGuildRankNames(GuildID)[RankID]; Ok {
+                return Name
         }
+        return DefaultGuildRankName(RankID)
 }
 
 func GetGuildMembers(GuildID int) []TGuildMember {
@@ -989,6 +1290,7 @@ func GetGuildMembers(GuildID int) []TGuildMember {
         }
         defer rows.Close()
 
+        rankNames := GetGuildRankNames(GuildID)
         var members []TGuildMember
         for rows.Next() {
                 var member TGuildMember
@@ -1006,7 +1308,13 @@ func GetGuildMembers(GuildID int) []TGuildMember {
                         continue
                 }
 
-                member.Rank = GetRankName(rankID)
+                member.CharacterID = characterID
+                member.RankID = rankID
+                if rankName, ok := rankNames[rankID]; ok {
+                        member.Rank = rankName
+                } else {
+                        member.Rank = DefaultGuildRankName(rankID)
+                }
                 
                 if charName.Valid {
                         member.CharacterName = charName.String
@@ -1109,36 +1417,27 @@ func IsCharacterLeader(AccountID int, GuildID int) bool {
         return count > 0
 }
 
+// GetCharacterGuildRank returns the highest rank any character of the account
+// holds in the guild, or -1 if none of them is a member.
 func GetCharacterGuildRank(AccountID int, GuildID int) int {
         if g_NewsDb == nil {
                 return -1
         }
-        var rank int
-        var leaderID int
-        err := g_NewsDb.QueryRow(`
-                SELECT leaderid FROM guilds WHERE guildid = ?
-        `, GuildID).Scan(&leaderID)
-        if err != nil {
-                return -1
-        }
-        err = g_NewsDb.QueryRow(`
-                SELECT characterid FROM characters WHERE accountid = ?
-        `, AccountID).Scan(&rank)
-        if err != nil {
-                return -1
-        }
-        if rank == leaderID {
+
+        if IsCharacterLeader(AccountID, GuildID) {
                 return 0
         }
-        err = g_NewsDb.QueryRow(`
-                SELECT rank FROM guildmembers 
-                WHERE characterid = (SELECT characterid FROM characters WHERE accountid = ?)
-                AND guildid = ?
-        `, AccountID, GuildID).Scan(&rank)
-        if err != nil {
+
+        var Rank sql.NullInt64
+        Err := g_NewsDb.QueryRow(`
+                SELECT MIN(m.rank) FROM guildmembers m
+                JOIN characters c ON c.characterid = m.characterid
+                WHERE m.guildid = ? AND c.accountid = ?
+        `, GuildID, AccountID).Scan(&Rank)
+        if Err != nil || !Rank.Valid {
                 return -1
         }
-        return rank
+        return int(Rank.Int64)
 }
 
 func IsCharacterLeaderOrViceLeader(AccountID int, GuildID int) bool {
@@ -1157,20 +1456,32 @@ func InviteCharacterToGuild(GuildID int, CharacterName string) int {
         if err != nil {
                 return 1
         }
+        // NOTE: Leaders of guilds founded through the website don't have a
+        // member row, so they're looked up separately.
         var isMember int
         err = g_NewsDb.QueryRow(`
-                SELECT COUNT(*) FROM guildmembers WHERE characterid = ?
-        `, charID).Scan(&isMember)
+                SELECT (SELECT COUNT(*) FROM guildmembers WHERE characterid = ?)
+                        + (SELECT COUNT(*) FROM guilds WHERE leaderid = ?)
+        `, charID, charID).Scan(&isMember)
         if err == nil && isMember > 0 {
                 return 2
         }
         var hasInvite int
         err = g_NewsDb.QueryRow(`
-                SELECT COUNT(*) FROM guildinvites WHERE characterid = ? AND guildid = ?
-        `, charID, GuildID).Scan(&hasInvite)
+                SELECT COUNT(*) FROM guildinvites
+                WHERE characterid = ? AND guildid = ? AND timestamp >= ?
+        `, charID, GuildID, GetGuildInviteCutoff()).Scan(&hasInvite)
         if err == nil && hasInvite > 0 {
                 return 3
         }
+        // NOTE: An expired invitation may still be around if the cleanup job
+        // didn't run yet, and it's replaced by the new one.
+        _, err = g_NewsDb.Exec(`
+                DELETE FROM guildinvites WHERE characterid = ? AND guildid = ?
+        `, charID, GuildID)
+        if err != nil {
+                return 4
+        }
         _, err = g_NewsDb.Exec(`
                 INSERT INTO guildinvites (guildid, characterid, recruiterid, timestamp)
                 VALUES (?, ?, 0, ?)
@@ -1229,10 +1540,29 @@ func ExpelMemberFromGuild(GuildID int, CharacterName string) int {
 }
 
 type TGuildInvite struct {
+        GuildID       int
+        GuildName     string
         CharacterName string
         CharacterID   int
         RecruiterID   int
-        Timestamp     int64
+        Timestamp     int
+        Expires       int
+}
+
+// GetGuildInviteCutoff returns the oldest timestamp an invitation can have
+// without being expired.
+func GetGuildInviteCutoff() int64 {
+        if g_GuildInviteExpiry <= 0 {
+                return 0
+        }
+        return time.Now().Add(-g_GuildInviteExpiry).Unix()
+}
+
+func SetGuildInviteExpiry(Invite *TGuildInvite) {
+        Invite.Expires = 0
+        if g_GuildInviteExpiry > 0 {
+                Invite.Expires = Invite.Timestamp + int(g_GuildInviteExpiry.Seconds())
+        }
 }
 
 func GetGuildInvites(GuildID int) []TGuildInvite {
@@ -1247,9 +1577,9 @@ func GetGuildInvites(GuildID int) []TGuildInvite {
                 SELECT gi.characterid, c.name, gi.recruiterid, gi.timestamp
                 FROM guildinvites gi
                 LEFT JOIN characters c ON gi.characterid = c.characterid
-                WHERE gi.guildid = ?
+                WHERE gi.guildid = ? AND gi.timestamp >= ?
                 ORDER BY gi.timestamp DESC
-        `, GuildID)
+        `, GuildID, GetGuildInviteCutoff())
         if err != nil {
                 g_LogErr.Printf("Failed to query guild invites: %v", err)
                 return []TGuildInvite{}
@@ -1272,65 +1602,142 @@ func GetGuildInvites(GuildID int) []TGuildInvite {
                         invite.CharacterName = fmt.Sprintf("Character #%d", invite.CharacterID)
                 }
                 
+                invite.GuildID = GuildID
+                SetGuildInviteExpiry(&invite)
                 invites = append(invites, invite)
         }
 
         return invites
 }
 
-func GetCharacterGuildInvite(AccountID int) (int, int) {
+// GetAccountGuildInvites returns the pending invitations of every character
+// of the account.
+func GetAccountGuildInvites(AccountID int) []TGuildInvite {
         if g_NewsDb == nil {
-                return -1, -1
+                return nil
         }
-        var charID int
-        var guildID int
-        err := g_NewsDb.QueryRow(`
-                SELECT characterid FROM characters WHERE accountid = ?
-        `, AccountID).Scan(&charID)
-        if err != nil {
-                return -1, -1
+
+        Rows, Err := g_NewsDb.Query(`
+                SELECT gi.guildid, g.name, gi.characterid, c.name, gi.recruiterid, gi.timestamp
+                FROM guildinvites gi
+                JOIN characters c ON c.characterid = gi.characterid
+                JOIN guilds g ON g.guildid = gi.guildid
+                WHERE c.accountid = ? AND gi.timestamp >= ?
+                ORDER BY c.name ASC, gi.timestamp DESC
+        `, AccountID, GetGuildInviteCutoff())
+        if Err != nil {
+                g_LogErr.Printf("Failed to query account guild invites: %v", Err)
+                return nil
         }
-        err = g_NewsDb.QueryRow(`
-                SELECT guildid FROM guildinvites WHERE characterid = ?
-        `, charID).Scan(&guildID)
-        if err != nil {
-                return -1, -1
+        defer Rows.Close()
+
+        var Invites []TGuildInvite
+        for Rows.Next() {
+                var Invite TGuildInvite
+                if Err := Rows.Scan(&Invite.GuildID, &Invite.GuildName, &Invite.CharacterID,
+                                &Invite.CharacterName, &Invite.RecruiterID, &Invite.Timestamp); Err != nil {
+                        g_LogErr.Printf("Failed to scan account guild invite: %v", Err)
+                        continue
+                }
+                SetGuildInviteExpiry(&Invite)
+                Invites = append(Invites, Invite)
         }
-        return charID, guildID
+        return Invites
 }
 
-func AcceptGuildInvite(AccountID int, GuildID int) int {
+// AcceptGuildInvite makes a character of the account join the guild that
+// invited it. Its other invitations are dropped since a character can only
+// be in one guild.
+func AcceptGuildInvite(AccountID int, GuildID int, CharacterID int) int {
         if g_NewsDb == nil {
                 return 3
         }
-        var charID int
-        err := g_NewsDb.QueryRow(`
-                SELECT characterid FROM characters WHERE accountid = ?
-        `, AccountID).Scan(&charID)
-        if err != nil {
+
+        JoinRank := GetGuildJoinRank(GuildID)
+        Tx, Err := g_NewsDb.Begin()
+        if Err != nil {
+                g_LogErr.Printf("Failed to begin transaction: %v", Err)
+                return 3
+        }
+        defer Tx.Rollback()
+
+        var Count int
+        Err = Tx.QueryRow(`
+                SELECT COUNT(*) FROM characters WHERE characterid = ? AND accountid = ?
+        `, CharacterID, AccountID).Scan(&Count)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query character: %v", Err)
+                return 3
+        } else if Count == 0 {
                 return 1
         }
-        
-        var inviteExists int
-        err = g_NewsDb.QueryRow(`
-                SELECT COUNT(*) FROM guildinvites WHERE characterid = ? AND guildid = ?
-        `, charID, GuildID).Scan(&inviteExists)
-        if err != nil || inviteExists == 0 {
+
+        Err = Tx.QueryRow(`
+                SELECT COUNT(*) FROM guildinvites
+                WHERE characterid = ? AND guildid = ? AND timestamp >= ?
+        `, CharacterID, GuildID, GetGuildInviteCutoff()).Scan(&Count)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query guild invite: %v", Err)
+                return 3
+        } else if Count == 0 {
                 return 2
         }
-        
-        _, err = g_NewsDb.Exec(`
+
+        Err = Tx.QueryRow(`
+                SELECT (SELECT COUNT(*) FROM guildmembers WHERE characterid = ?)
+                        + (SELECT COUNT(*) FROM guilds WHERE leaderid = ?)
+        `, CharacterID, CharacterID).Scan(&Count)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query guild membership: %v", Err)
+                return 3
+        } else if Count > 0 {
+                return 4
+        }
+
+        _, Err = Tx.Exec(`
                 INSERT INTO guildmembers (guildid, characterid, rank, joined, status)
-                VALUES (?, ?, 2, ?, 'active')
-        `, GuildID, charID, time.Now().Unix())
-        if err != nil {
+                VALUES (?, ?, ?, ?, 'active')
+        `, GuildID, CharacterID, JoinRank, time.Now().Unix())
+        if Err != nil {
+                g_LogErr.Printf("Failed to insert guild member: %v", Err)
+                return 3
+        }
+
+        _, Err = Tx.Exec(`
+                DELETE FROM guildinvites WHERE characterid = ?
+        `, CharacterID)
+        if Err != nil {
+                g_LogErr.Printf("Failed to delete guild invites: %v", Err)
+                return 3
+        }
+
+        if Err := Tx.Commit(); Err != nil {
+                g_LogErr.Printf("Failed to commit guild invite: %v", Err)
                 return 3
         }
-        
-        _, err = g_NewsDb.Exec(`
-                DELETE FROM guildinvites WHERE characterid = ? AND guildid = ?
-        `, charID, GuildID)
-        
+        return 0
+}
+
+// DeclineGuildInvite drops the invitation a guild sent to a character of the
+// account.
+func DeclineGuildInvite(AccountID int, GuildID int, CharacterID int) int {
+        if g_NewsDb == nil {
+                return 2
+        }
+
+        Result, Err := g_NewsDb.Exec(`
+                DELETE FROM guildinvites
+                WHERE guildid = ? AND characterid = ? AND characterid IN
+                        (SELECT characterid FROM characters WHERE accountid = ?)
+        `, GuildID, CharacterID, AccountID)
+        if Err != nil {
+                g_LogErr.Printf("Failed to delete guild invite: %v", Err)
+                return 2
+        }
+
+        if Affected, _ := Result.RowsAffected(); Affected == 0 {
+                return 1
+        }
         return 0
 }
 
@@ -1371,6 +1778,7 @@ func IsAccountGamemaster(AccountID int) bool {
 type THouseAuction struct {
         HouseID      int
         BidderID     int
+        BidderName   string
         BidAmount    int
         FinishTime   int64
         IsActive     bool
@@ -1449,7 +1857,39 @@ func CanBidOnHouse(AccountID int, HouseID int, IsGuildHouse bool) bool {
         return true
 }
 
-func GetHouseAuctionInfo(HouseID int) *THouseAuction {
+// GetAccountWorldCharacters returns the characters of an account that live on
+// a given world. These are the only characters the account may bid with on a
+// house from that world.
+func GetAccountWorldCharacters(AccountID int, World string) []TAccountCharacter {
+        if g_NewsDb == nil {
+                return nil
+        }
+
+        Rows, Err := g_NewsDb.Query(`
+                SELECT c.CharacterID, c.Name, c.Level FROM Characters c
+                JOIN Worlds w ON w.WorldID = c.WorldID
+                WHERE c.AccountID = ? AND w.Name = ? AND c.Deleted = 0
+                ORDER BY c.Name
+        `, AccountID, World)
+        if Err != nil {
+                g_LogErr.Printf("Failed to query account characters: %v", Err)
+                return nil
+        }
+        defer Rows.Close()
+
+        var Characters []TAccountCharacter
+        for Rows.Next() {
+                var Character TAccountCharacter
+                if Err := Rows.Scan(&Character.CharacterID, &Character.Name, &Character.Level); Err != nil {
+                        g_LogErr.Printf("Failed to scan account character: %v", Err)
+                        continue
+                }
+                Characters = append(Characters, Character)
+        }
+        return Characters
+}
+
+func GetHouseAuctionInfo(WorldID int, HouseID int) *THouseAuction {
         if g_NewsDb == nil {
                 return nil
         }
@@ -1460,9 +1900,12 @@ func GetHouseAuctionInfo(HouseID int) *THouseAuction {
         var finishTime sql.NullInt64
         
         err := g_NewsDb.QueryRow(`
-                SELECT HouseID, COALESCE(BidderID, 0), COALESCE(BidAmount, 0), COALESCE(FinishTime, 0)
-                FROM HouseAuctions WHERE HouseID = ?
-        `, HouseID).Scan(&auction.HouseID, &bidderID, &bidAmount, &finishTime)
+                SELECT ha.HouseID, COALESCE(ha.BidderID, 0), COALESCE(c.Name, ''),
+                        COALESCE(ha.BidAmount, 0), COALESCE(ha.FinishTime, 0)
+                FROM HouseAuctions ha
+                LEFT JOIN Characters c ON c.CharacterID = ha.BidderID
+                WHERE ha.WorldID = ? AND ha.HouseID = ?
+        `, WorldID, HouseID).Scan(&auction.HouseID, &bidderID, &auction.BidderName, &bidAmount, &finishTime)
         if err != nil {
                 return nil
         }
@@ -1484,45 +1927,3 @@ func GetHouseAuctionInfo(HouseID int) *THouseAuction {
         
         return &auction
 }
-
-func PlaceHouseBid(HouseID int, CharacterID int, BidAmount int) int {
-        if g_NewsDb == nil {
-                return 3
-        }
-        
-        var currentBid int
-        err := g_NewsDb.QueryRow(`
-                SELECT COALESCE(BidAmount, 0) FROM HouseAuctions WHERE HouseID = ?
-        `, HouseID).Scan(&currentBid)
-        if err != nil && err != sql.ErrNoRows {
-                return 3
-        }
-        
-        if BidAmount <= currentBid {
-                return 1
-        }
-        
-        now := time.Now().Unix()
-        var finishTime int64
-        err = g_NewsDb.QueryRow(`
-                SELECT COALESCE(FinishTime, 0) FROM HouseAuctions WHERE HouseID = ?
-        `, HouseID).Scan(&finishTime)
-        
-        if finishTime == 0 {
-                finishTime = now + (7 * 24 * 60 * 60)
-                _, err = g_NewsDb.Exec(`
-                        INSERT INTO HouseAuctions (HouseID, BidderID, BidAmount, FinishTime)
-                        VALUES (?, ?, ?, ?)
-                `, HouseID, CharacterID, BidAmount, finishTime)
-        } else {
-                _, err = g_NewsDb.Exec(`
-                        UPDATE HouseAuctions SET BidderID = ?, BidAmount = ? WHERE HouseID = ?
-                `, CharacterID, BidAmount, HouseID)
-        }
-        
-        if err != nil {
-                return 3
-        }
-        
-        return 0
-}
@@ -3,10 +3,17 @@ package main
 import (
         "fmt"
         "html/template"
-        "io"
         "net/http"
+        "os"
+        "path"
+        "slices"
         "strconv"
         "strings"
+        "time"
+)
+
+const (
+        DEFAULT_LANGUAGE = "en"
 )
 
 type (
@@ -17,6 +24,8 @@ type (
                 ServerOnline  bool
                 LastStartup   int
                 IsGamemaster  bool
+                Language      string
+                Location      *time.Location
         }
 
         GenericTmplData struct {
@@ -41,25 +50,100 @@ type (
         }
 
         AccountTmplData struct {
+                Common               CommonTmplData
+                Account              *TAccountSummary
+                SexChangePremiumDays int
+                Preferences          *TAccountPreferences
+                Languages            []string
+                OutgoingTransfers    []THouseTransfer
+                IncomingTransfers    []THouseTransfer
+                RentDue              []THouseRentDue
+                RentReminders        bool
+                GuildInvites         []TGuildInvite
+        }
+
+        AdminEvictionsTmplData struct {
                 Common  CommonTmplData
-                Account *TAccountSummary
+                Houses  []THouseRentDue
+                Advance string
         }
 
         CharacterTmplData struct {
-                Common    CommonTmplData
-                Character *TCharacterProfile
+                Common     CommonTmplData
+                Character  *TCharacterProfile
+                HasOutfits bool
+        }
+
+        CharacterCreateTmplData struct {
+                Common     CommonTmplData
+                Worlds     []TWorld
+                HasOutfits bool
         }
 
         KillStatisticsTmplData struct {
                 Common         CommonTmplData
                 World          *TWorld
                 KillStatistics []TKillStatistics
+                CurrentPeriod  string
+                CurrentSort    string
+        }
+
+        KillStatisticsCreatureTmplData struct {
+                Common   CommonTmplData
+                World    *TWorld
+                RaceName string
+                Days     []TKillStatisticsDay
+        }
+
+        TVocationCount struct {
+                Vocation string
+                Count    int
+        }
+
+        TLevelBracket struct {
+                MinLevel int
+                MaxLevel int
+                Count    int
+                Percent  int
         }
 
         WorldTmplData struct {
                 Common           CommonTmplData
                 World            *TWorld
                 OnlineCharacters []TOnlineCharacter
+                TotalOnline      int
+                MatchingOnline   int
+                VocationCounts   []TVocationCount
+                LevelBrackets    []TLevelBracket
+                CurrentSort      string
+                CurrentVocation  string
+                MinLevel         int
+                MaxLevel         int
+                CurrentPage      int
+                TotalPages       int
+                ChartRange       string
+                PlayerChart      TPlayerChart
+                DailyPeaks       []TPlayerPeak
+                WeeklyPeaks      []TPlayerPeak
+                MonthlyPeaks     []TPlayerPeak
+        }
+
+        WorldStatusTmplData struct {
+                Common CommonTmplData
+                Status TWorldStatus
+        }
+
+        WorldDeathsTmplData struct {
+                Common   CommonTmplData
+                World    *TWorld
+                Deaths   []TDeath
+                PvPOnly  bool
+                MinLevel int
+        }
+
+        WorldHistoryTmplData struct {
+                Common CommonTmplData
+                Worlds []TWorldPlayerStats
         }
 
         WorldListTmplData struct {
@@ -74,6 +158,9 @@ type (
         }
 
         THighscore struct {
+                Rank          int
+                RankDelta     int
+                NewEntry      bool
                 CharacterName string
                 Profession    string
                 Level         int
@@ -88,6 +175,9 @@ type (
                 CurrentSkillName  string
                 CurrentSkillDisplay string
                 CurrentVocation   string
+                CurrentWorld      string
+                Worlds            []TWorld
+                Gainers           []THighscoreGainer
                 CurrentPage       int
                 TotalPages        int
                 TotalHighscores   int
@@ -109,7 +199,9 @@ type (
         }
 
         THouse struct {
+                WorldID     int
                 HouseID     int
+                World       string
                 Name        string
                 Rent        int
                 Description string
@@ -119,6 +211,11 @@ type (
                 Status      string
                 Owner       string
                 PaidUntil   int
+                HighestBid  int
+                AuctionEnd  int
+                PositionX   int
+                PositionY   int
+                PositionZ   int
         }
 
         HousesTmplData struct {
@@ -134,26 +231,34 @@ type (
                 Common         CommonTmplData
                 House          *THouse
                 Auction        *THouseAuction
+                Bids           []THouseBid
                 CanBid         bool
-                CurrentCharID  int
+                Characters     []TAccountCharacter
+                IsOwner        bool
+                Transfer       *THouseTransfer
+                HasMap         bool
         }
 
         TGuild struct {
-                GuildID           int
-                Name              string
-                Description       string
-                Leader            string
-                Created           int
-                MemberCount       int
-                GuildHouseID      int
-                LastActivity      int
-                ViceLeaderCount   int
-                DisbandDate       int
-                IsInactive        bool
+                GuildID             int
+                Name                string
+                Description         string
+                Leader              string
+                Created             int
+                MemberCount         int
+                GuildHouseWorldID   int
+                GuildHouseID        int
+                ViceLeaderCount     int
+                DisbandDate         int
+                IsInactive          bool
+                LacksViceLeaders    bool
+                RequiredViceLeaders int
         }
 
         TGuildMember struct {
+                CharacterID   int
                 CharacterName string
+                RankID        int
                 Rank          string
                 Title         string
                 Joined        int
@@ -168,14 +273,87 @@ type (
         }
 
         GuildDetailTmplData struct {
-                Common        CommonTmplData
-                Guild         *TGuild
-                Members       []TGuildMember
-                Invites       []TGuildInvite
-                IsLeader      bool
-                IsViceLeader  bool
-                LeaderCharID  int
-                HasInvite     bool
+                Common           CommonTmplData
+                Guild            *TGuild
+                Members          []TGuildMember
+                Invites          []TGuildInvite
+                IsLeader         bool
+                IsViceLeader     bool
+                LeaderCharID     int
+                AccountInvites   []TGuildInvite
+                Characters       []TAccountCharacter
+                History          []TGuildHistoryEntry
+                LogoUpdated      int
+                LogoMaxSize      int
+                LogoMaxDimension int
+                Wars             []TGuildWar
+                WarEnemies       []TGuild
+                IsMember         bool
+                BoardUnread      int
+        }
+
+        GuildBoardTmplData struct {
+                Common      CommonTmplData
+                Guild       *TGuild
+                Threads     []TGuildBoardThread
+                Characters  []TAccountCharacter
+                CanModerate bool
+                CurrentPage int
+                TotalPages  int
+                TitleMax    int
+                TextMax     int
+        }
+
+        GuildEventsTmplData struct {
+                Common         CommonTmplData
+                Guild          *TGuild
+                MonthName      string
+                PrevMonth      string
+                NextMonth      string
+                Weeks          [][]TGuildCalendarDay
+                Upcoming       []TGuildEvent
+                CanManage      bool
+                MinTime        string
+                TitleMax       int
+                DescriptionMax int
+                MaxCapacity    int
+        }
+
+        GuildEventTmplData struct {
+                Common     CommonTmplData
+                Guild      *TGuild
+                Event      *TGuildEvent
+                Signups    []TGuildEventSignup
+                Characters []TAccountCharacter
+                CanManage  bool
+                Started    bool
+        }
+
+        GuildBoardThreadTmplData struct {
+                Common      CommonTmplData
+                Guild       *TGuild
+                Thread      *TGuildBoardThread
+                Posts       []TGuildBoardPost
+                Characters  []TAccountCharacter
+                CanModerate bool
+                CurrentPage int
+                TotalPages  int
+                TextMax     int
+        }
+
+        GuildWarsTmplData struct {
+                Common     CommonTmplData
+                ActiveWars []TGuildWar
+                EndedWars  []TGuildWar
+        }
+
+        GuildManageTmplData struct {
+                Common    CommonTmplData
+                Guild     *TGuild
+                Ranks     []TGuildRank
+                Members   []TGuildMember
+                IsLeader  bool
+                ActorRank int
         }
 
         GuildCreateTmplData struct {
@@ -187,15 +365,20 @@ type (
 )
 
 var (
-        g_Templates *template.Template
+        // NOTE: Every language renders the same templates, only `T` and
+        // `FormatDurationSince` differ between them. Pages are written in English
+        // and just the strings passed through `T` (e.g. the footer) are translated.
+        g_Templates         map[string]*template.Template
+        g_TemplateLanguages []string
+
+        // NOTE: A language is any `templates/<language>/strings.cfg`, which maps
+        // the English text to its translation.
+        g_Translations map[string]map[string]string
 )
 
 func InitTemplates() bool {
-        var Err error
-
         CustomFuncs := template.FuncMap{
                 "FormatTimestamp": FormatTimestamp,
-                "FormatDurationSince": FormatDurationSince,
                 "add": func(a, b int) int { return a + b },
                 "sub": func(a, b int) int { return a - b },
                 "mul": func(a, b int) int { return a * b },
@@ -210,26 +393,99 @@ func InitTemplates() bool {
                 "HTML": func(s string) template.HTML { return template.HTML(s) },
         }
 
-        g_Templates, Err = template.New("").Funcs(CustomFuncs).ParseGlob("templates/*.tmpl")
+        Languages := []string{DEFAULT_LANGUAGE}
+        Entries, Err := os.ReadDir("templates")
+        if Err != nil {
+                g_LogErr.Printf("Failed to read templates directory: %v", Err)
+                return false
+        }
+        g_Translations = make(map[string]map[string]string)
+        g_Translations[DEFAULT_LANGUAGE] = make(map[string]string)
+        for _, Entry := range Entries {
+                FileName := path.Join("templates", Entry.Name(), "strings.cfg")
+                if !Entry.IsDir() || Entry.Name() == DEFAULT_LANGUAGE || !FileExists(FileName) {
+                        continue
+                }
+
+                Translations := make(map[string]string)
+                if !ReadConfig(FileName, func(Key string, Value string) {
+                        Translations[Key] = ParseString(Value)
+                }) {
+                        return false
+                }
+                g_Translations[Entry.Name()] = Translations
+                Languages = append(Languages, Entry.Name())
+        }
+
+        // NOTE: The language functions are replaced in each copy, these are only
+        // needed so the templates parse.
+        CustomFuncs["T"] = func(Text string) string { return Text }
+        CustomFuncs["FormatDurationSince"] = func(Timestamp int) string {
+                return FormatDurationSince(Timestamp, DEFAULT_LANGUAGE)
+        }
+        Base, Err := template.New("").Funcs(CustomFuncs).ParseGlob("templates/*.tmpl")
         if Err != nil {
                 g_LogErr.Printf("Failed to parse templates: %v", Err)
                 return false
         }
+
+        g_Templates = make(map[string]*template.Template)
+        for _, Language := range Languages {
+                Templates, Err := Base.Clone()
+                if Err != nil {
+                        g_LogErr.Printf("Failed to clone \"%v\" templates: %v", Language, Err)
+                        return false
+                }
+
+                g_Templates[Language] = Templates.Funcs(template.FuncMap{
+                        "T": func(Text string) string {
+                                return Translate(Language, Text)
+                        },
+                        "FormatDurationSince": func(Timestamp int) string {
+                                return FormatDurationSince(Timestamp, Language)
+                        },
+                })
+        }
+
+        g_TemplateLanguages = Languages
+        g_Log.Printf("TemplateLanguages: %v", g_TemplateLanguages)
         return true
 }
 
 func ExitTemplates() {
         g_Templates = nil
+        g_TemplateLanguages = nil
+        g_Translations = nil
+}
+
+func IsTemplateLanguage(Language string) bool {
+        return slices.Contains(g_TemplateLanguages, Language)
 }
 
-func ExecuteTemplate(Writer io.Writer, FileName string, Data any) {
-        Err := g_Templates.ExecuteTemplate(Writer, FileName, Data)
+// Translate returns the translation of an English string into the given
+// language, or the string itself if there is none.
+func Translate(Language string, Text string) string {
+        if Translation, Ok := g_Translations[Language][Text]; Ok {
+                return Translation
+        }
+        return Text
+}
+
+func ExecuteTemplate(Context *THttpRequestContext, FileName string, Data any) {
+        Templates := g_Templates[GetContextPreferences(Context).Language]
+        if Templates == nil {
+                Templates = g_Templates[DEFAULT_LANGUAGE]
+        }
+
+        Err := Templates.ExecuteTemplate(Context.Writer, FileName, Data)
         if Err != nil {
                 g_LogErr.Printf("Failed to execute template \"%v\": %v", FileName, Err)
         }
 }
 
-func GetCommonTmplData(Title string, AccountID int) CommonTmplData {
+func GetCommonTmplData(Title string, Context *THttpRequestContext) CommonTmplData {
+        AccountID := Context.AccountID
+        Preferences := GetContextPreferences(Context)
         Worlds := GetWorlds()
         TotalPlayers := 0
         ServerOnline := false
@@ -257,23 +513,25 @@ func GetCommonTmplData(Title string, AccountID int) CommonTmplData {
                 ServerOnline:  ServerOnline,
                 LastStartup:   LastStartup,
                 IsGamemaster:  IsGamemaster,
+                Language:      Preferences.Language,
+                Location:      Preferences.Location,
         }
 }
 
 func RenderRequestError(Context *THttpRequestContext, Status int) {
         StatusText := http.StatusText(Status)
-        ExecuteTemplate(Context.Writer, "message.tmpl",
+        ExecuteTemplate(Context, "message.tmpl",
                 MessageTmplData{
-                        Common:  GetCommonTmplData(StatusText, Context.AccountID),
+                        Common:  GetCommonTmplData(StatusText, Context),
                         Heading: strconv.Itoa(Status),
                         Message: StatusText,
                 })
 }
 
 func RenderMessage(Context *THttpRequestContext, Heading string, Message string) {
-        ExecuteTemplate(Context.Writer, "message.tmpl",
+        ExecuteTemplate(Context, "message.tmpl",
                 MessageTmplData{
-                        Common:  GetCommonTmplData(Heading, Context.AccountID),
+                        Common:  GetCommonTmplData(Heading, Context),
                         Heading: Heading,
                         Message: Message,
                 })
@@ -281,8 +539,9 @@ func RenderMessage(Context *THttpRequestContext, Heading string, Message string)
 
 func RenderAccountSummary(Context *THttpRequestContext) {
         Data := AccountTmplData{
-                Common: GetCommonTmplData("Account Summary", Context.AccountID),
+                Common: GetCommonTmplData("Account Summary", Context),
                 Account: nil,
+                SexChangePremiumDays: g_SexChangePremiumDays,
         }
 
         Result, Account := GetAccountSummary(Context.AccountID)
@@ -290,35 +549,42 @@ func RenderAccountSummary(Context *THttpRequestContext) {
                 Data.Account = &Account
         }
 
-        ExecuteTemplate(Context.Writer, "account_summary.tmpl", Data)
+        Data.Preferences = GetContextPreferences(Context)
+        Data.Languages = g_TemplateLanguages
+        Data.OutgoingTransfers, Data.IncomingTransfers = GetAccountHouseTransfers(Context.AccountID)
+        Data.RentDue = GetAccountHouseRentDue(Context.AccountID)
+        Data.RentReminders = GetHouseRentRemindersEnabled(Context.AccountID)
+        Data.GuildInvites = GetAccountGuildInvites(Context.AccountID)
+        ExecuteTemplate(Context, "account_summary.tmpl", Data)
 }
 
 func RenderAccountLogin(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "account_login.tmpl",
+        ExecuteTemplate(Context, "account_login.tmpl",
                 GenericTmplData{
-                        Common: GetCommonTmplData("Login", Context.AccountID),
+                        Common: GetCommonTmplData("Login", Context),
                 })
 }
 
 func RenderAccountCreate(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "account_create.tmpl",
+        ExecuteTemplate(Context, "account_create.tmpl",
                 GenericTmplData{
-                        Common: GetCommonTmplData("Create Account", Context.AccountID),
+                        Common: GetCommonTmplData("Create Account", Context),
                 })
 }
 
 func RenderAccountRecover(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "account_recover.tmpl",
+        ExecuteTemplate(Context, "account_recover.tmpl",
                 GenericTmplData{
-                        Common: GetCommonTmplData("Recover Account", Context.AccountID),
+                        Common: GetCommonTmplData("Recover Account", Context),
                 })
 }
 
 func RenderCharacterCreate(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "character_create.tmpl",
-                WorldListTmplData{
-                        Common: GetCommonTmplData("Create Character", Context.AccountID),
-                        Worlds: GetWorlds(),
+        ExecuteTemplate(Context, "character_create.tmpl",
+                CharacterCreateTmplData{
+                        Common:     GetCommonTmplData("Create Character", Context),
+                        Worlds:     GetWorlds(),
+                        HasOutfits: OutfitsEnabled(),
                 })
 }
 
@@ -328,48 +594,254 @@ func RenderCharacterProfile(Context *THttpRequestContext, Character *TCharacterP
                 Title = fmt.Sprintf("%v's Profile", Character.Name)
         }
 
-        ExecuteTemplate(Context.Writer, "character_profile.tmpl",
+        ExecuteTemplate(Context, "character_profile.tmpl",
                 CharacterTmplData{
-                        Common: GetCommonTmplData(Title, Context.AccountID),
+                        Common: GetCommonTmplData(Title, Context),
                         Character: Character,
+                        HasOutfits: OutfitsEnabled(),
                 })
 }
 
 func RenderKillStatisticsList(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "killstatistics_list.tmpl",
+        ExecuteTemplate(Context, "killstatistics_list.tmpl",
                 WorldListTmplData{
-                        Common: GetCommonTmplData("Kill Statistics", Context.AccountID),
+                        Common: GetCommonTmplData("Kill Statistics", Context),
                         Worlds: GetWorlds(),
                 })
 }
 
 func RenderKillStatistics(Context *THttpRequestContext, WorldName string) {
-        ExecuteTemplate(Context.Writer, "killstatistics.tmpl",
+        QueryValues := Context.Request.URL.Query()
+        Period := QueryValues.Get("period")
+        if Period != "day" && Period != "week" {
+                Period = "all"
+        }
+
+        Sort := QueryValues.Get("sort")
+        if Sort != "killed" && Sort != "players" {
+                Sort = "name"
+        }
+
+        KillStatistics := GetKillStatisticsForPeriod(WorldName, Period)
+        SortKillStatistics(KillStatistics, Sort)
+        ExecuteTemplate(Context, "killstatistics.tmpl",
                 KillStatisticsTmplData{
-                        Common: GetCommonTmplData(fmt.Sprintf("Kill Statistics - %v", WorldName), Context.AccountID),
+                        Common: GetCommonTmplData(fmt.Sprintf("Kill Statistics - %v", WorldName), Context),
                         World:          GetWorld(WorldName),
-                        KillStatistics: GetKillStatistics(WorldName),
+                        KillStatistics: KillStatistics,
+                        CurrentPeriod:  Period,
+                        CurrentSort:    Sort,
+                })
+}
+
+func RenderKillStatisticsCreature(Context *THttpRequestContext, WorldName string, RaceName string) {
+        ExecuteTemplate(Context, "killstatistics_creature.tmpl",
+                KillStatisticsCreatureTmplData{
+                        Common:   GetCommonTmplData(fmt.Sprintf("Kill Statistics - %v", RaceName), Context),
+                        World:    GetWorld(WorldName),
+                        RaceName: RaceName,
+                        Days:     GetCreatureKillHistory(WorldName, RaceName, 30),
                 })
 }
 
 func RenderWorldList(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "world_list.tmpl",
+        ExecuteTemplate(Context, "world_list.tmpl",
                 WorldListTmplData{
-                        Common: GetCommonTmplData("Worlds", Context.AccountID),
+                        Common: GetCommonTmplData("Worlds", Context),
                         Worlds: GetWorlds(),
                 })
 }
 
+func SummarizeOnlineCharacters(Characters []TOnlineCharacter) ([]TVocationCount, []TLevelBracket) {
+        VocationCounts := []TVocationCount{
+                {Vocation: "knight"},
+                {Vocation: "paladin"},
+                {Vocation: "sorcerer"},
+                {Vocation: "druid"},
+                {Vocation: "none"},
+        }
+
+        // NOTE: The last bracket is open ended.
+        LevelBrackets := []TLevelBracket{
+                {MinLevel: 1, MaxLevel: 19},
+                {MinLevel: 20, MaxLevel: 39},
+                {MinLevel: 40, MaxLevel: 59},
+                {MinLevel: 60, MaxLevel: 79},
+                {MinLevel: 80, MaxLevel: 99},
+                {MinLevel: 100, MaxLevel: 0},
+        }
+
+        for _, Character := range Characters {
+                Vocation := VocationString(Character.Profession)
+                for Index := range VocationCounts {
+                        if VocationCounts[Index].Vocation == Vocation {
+                                VocationCounts[Index].Count += 1
+                                break
+                        }
+                }
+
+                for Index := len(LevelBrackets) - 1; Index >= 0; Index -= 1 {
+                        if Character.Level >= LevelBrackets[Index].MinLevel || Index == 0 {
+                                LevelBrackets[Index].Count += 1
+                                break
+                        }
+                }
+        }
+
+        if len(Characters) > 0 {
+                for Index := range LevelBrackets {
+                        LevelBrackets[Index].Percent = LevelBrackets[Index].Count * 100 / len(Characters)
+                }
+        }
+
+        return VocationCounts, LevelBrackets
+}
+
 func RenderWorldInfo(Context *THttpRequestContext, WorldName string) {
-        ExecuteTemplate(Context.Writer, "world_info.tmpl",
+        QueryValues := Context.Request.URL.Query()
+        Sort := QueryValues.Get("sort")
+        if Sort != "level" && Sort != "vocation" {
+                Sort = "name"
+        }
+
+        Vocation := QueryValues.Get("vocation")
+        MinLevel, _ := strconv.Atoi(QueryValues.Get("minlevel"))
+        MaxLevel, _ := strconv.Atoi(QueryValues.Get("maxlevel"))
+        if MinLevel < 0 {
+                MinLevel = 0
+        }
+        if MaxLevel < 0 {
+                MaxLevel = 0
+        }
+
+        Page, _ := strconv.Atoi(QueryValues.Get("page"))
+        if Page < 1 {
+                Page = 1
+        }
+
+        // IMPORTANT: `GetOnlineCharacters` returns the cached slice which is
+        // shared with other requests, so it must be copied before sorting.
+        // Everything on this page is computed from it to avoid going back to
+        // the query manager.
+        AllCharacters := GetOnlineCharacters(WorldName)
+        VocationCounts, LevelBrackets := SummarizeOnlineCharacters(AllCharacters)
+
+        Characters := make([]TOnlineCharacter, 0, len(AllCharacters))
+        for _, Character := range AllCharacters {
+                if Vocation != "" && VocationString(Character.Profession) != Vocation {
+                        continue
+                }
+
+                if MinLevel > 0 && Character.Level < MinLevel {
+                        continue
+                }
+
+                if MaxLevel > 0 && Character.Level > MaxLevel {
+                        continue
+                }
+
+                Characters = append(Characters, Character)
+        }
+
+        slices.SortStableFunc(Characters, func(A, B TOnlineCharacter) int {
+                switch Sort {
+                case "level":
+                        if A.Level != B.Level {
+                                return B.Level - A.Level
+                        }
+                case "vocation":
+                        if Result := strings.Compare(A.Profession, B.Profession); Result != 0 {
+                                return Result
+                        }
+                        if A.Level != B.Level {
+                                return B.Level - A.Level
+                        }
+                }
+                return strings.Compare(strings.ToLower(A.Name), strings.ToLower(B.Name))
+        })
+
+        ItemsPerPage := 50
+        TotalPages := (len(Characters) + ItemsPerPage - 1) / ItemsPerPage
+        if TotalPages < 1 {
+                TotalPages = 1
+        }
+
+        if Page > TotalPages {
+                Page = TotalPages
+        }
+
+        StartIndex := (Page - 1) * ItemsPerPage
+        EndIndex := min(StartIndex+ItemsPerPage, len(Characters))
+
+        ChartRange := QueryValues.Get("chart")
+        if ChartRange != "week" && ChartRange != "month" {
+                ChartRange = "day"
+        }
+
+        Common := GetCommonTmplData("Worlds", Context)
+        Now := time.Now()
+        Since := PlayerPeakPeriodStart(Now.In(Common.Location).AddDate(0, -11, 0), "month")
+        Samples := GetWorldPlayerSamples(WorldName, int(Since.Unix()))
+
+        ExecuteTemplate(Context, "world_info.tmpl",
                 WorldTmplData{
-                        Common: GetCommonTmplData("Worlds", Context.AccountID),
+                        Common:           Common,
                         World:            GetWorld(WorldName),
-                        OnlineCharacters: GetOnlineCharacters(WorldName),
+                        OnlineCharacters: Characters[StartIndex:EndIndex],
+                        TotalOnline:      len(AllCharacters),
+                        MatchingOnline:   len(Characters),
+                        VocationCounts:   VocationCounts,
+                        LevelBrackets:    LevelBrackets,
+                        CurrentSort:      Sort,
+                        CurrentVocation:  Vocation,
+                        MinLevel:         MinLevel,
+                        MaxLevel:         MaxLevel,
+                        CurrentPage:      Page,
+                        TotalPages:       TotalPages,
+                        ChartRange:       ChartRange,
+                        PlayerChart:      BuildPlayerChart(Samples,
+                                int(PlayerChartRangeStart(ChartRange, Now).Unix()), int(Now.Unix())),
+                        DailyPeaks:       GetPlayerPeaks(Samples, Common.Location, "day", 7),
+                        WeeklyPeaks:      GetPlayerPeaks(Samples, Common.Location, "week", 4),
+                        MonthlyPeaks:     GetPlayerPeaks(Samples, Common.Location, "month", 12),
                 })
 }
 
-func RenderHighscores(Context *THttpRequestContext, Skill string, Vocation string) {
+func RenderWorldStatus(Context *THttpRequestContext, WorldName string) {
+        ExecuteTemplate(Context, "world_status.tmpl",
+                WorldStatusTmplData{
+                        Common: GetCommonTmplData(fmt.Sprintf("Status History - %v", WorldName), Context),
+                        Status: GetWorldStatus(GetWorld(WorldName)),
+                })
+}
+
+func RenderWorldDeaths(Context *THttpRequestContext, WorldName string) {
+        QueryValues := Context.Request.URL.Query()
+        PvPOnly := QueryValues.Get("pvp") != ""
+        MinLevel, _ := strconv.Atoi(QueryValues.Get("minlevel"))
+        if MinLevel < 0 {
+                MinLevel = 0
+        }
+
+        ExecuteTemplate(Context, "world_deaths.tmpl",
+                WorldDeathsTmplData{
+                        Common:   GetCommonTmplData(fmt.Sprintf("Latest Deaths - %v", WorldName), Context),
+                        World:    GetWorld(WorldName),
+                        Deaths:   GetLatestDeaths(WorldName, PvPOnly, MinLevel, g_LatestDeaths),
+                        PvPOnly:  PvPOnly,
+                        MinLevel: MinLevel,
+                })
+}
+
+func RenderWorldHistory(Context *THttpRequestContext) {
+        ExecuteTemplate(Context, "world_history.tmpl",
+                WorldHistoryTmplData{
+                        Common: GetCommonTmplData("Worlds", Context),
+                        Worlds: GetWorldPlayerOverview(),
+                })
+}
+
+func RenderHighscores(Context *THttpRequestContext, World string, Skill string, Vocation string) {
         skillDisplay := map[string]string{
                 "level": "Level",
                 "magic": "Magic",
@@ -385,6 +857,22 @@ func RenderHighscores(Context *THttpRequestContext, Skill string, Vocation strin
         skillDisp := "Level"
         if disp, ok := skillDisplay[Skill]; ok {
                 skillDisp = disp
+        } else {
+                Skill = "level"
+        }
+
+        // NOTE: Each vocation has its own cache entry so anything else is
+        // treated as all vocations.
+        if !slices.Contains([]string{"knight", "paladin", "sorcerer", "druid"}, Vocation) {
+                Vocation = ""
+        }
+
+        worlds := GetWorlds()
+        if GetWorld(World) == nil {
+                World = ""
+                if len(worlds) > 0 {
+                        World = worlds[0].Name
+                }
         }
         
         pageStr := Context.Request.URL.Query().Get("page")
@@ -397,7 +885,10 @@ func RenderHighscores(Context *THttpRequestContext, Skill string, Vocation strin
         }
 
         itemsPerPage := 50
-        allHighscores := GetHighscores(Skill, Vocation)
+        var allHighscores []THighscore
+        if World != "" {
+                allHighscores = GetHighscores(World, Skill, Vocation)
+        }
         totalHighscores := len(allHighscores)
         totalPages := (totalHighscores + itemsPerPage - 1) / itemsPerPage
         if totalPages < 1 {
@@ -414,15 +905,26 @@ func RenderHighscores(Context *THttpRequestContext, Skill string, Vocation strin
                 endIdx = totalHighscores
         }
 
-        paginatedHighscores := allHighscores[startIdx:endIdx]
+        // NOTE: Highscores come from the cache and must be copied before
+        // adding rank movement to them.
+        paginatedHighscores := slices.Clone(allHighscores[startIdx:endIdx])
+
+        // NOTE: Snapshots only hold the ranking for all vocations so rank
+        // movement can't be shown for a single vocation.
+        if World != "" && Vocation == "" {
+                AnnotateHighscores(paginatedHighscores, GetPreviousHighscoreRanks(World, Skill))
+        }
         
-        ExecuteTemplate(Context.Writer, "highscores.tmpl",
+        ExecuteTemplate(Context, "highscores.tmpl",
                 HighscoresTmplData{
-                        Common: GetCommonTmplData("Highscores", Context.AccountID),
+                        Common: GetCommonTmplData("Highscores", Context),
                         Highscores:        paginatedHighscores,
                         CurrentSkill:      Skill,
                         CurrentSkillDisplay: skillDisp,
                         CurrentVocation:   Vocation,
+                        CurrentWorld:      World,
+                        Worlds:            worlds,
+                        Gainers:           GetHighscoreGainers(World, Skill, 10),
                         CurrentPage:       page,
                         TotalPages:        totalPages,
                         TotalHighscores:   totalHighscores,
@@ -457,9 +959,9 @@ func RenderNews(Context *THttpRequestContext) {
                 totalPages = 1
         }
 
-        ExecuteTemplate(Context.Writer, "news.tmpl",
+        ExecuteTemplate(Context, "news.tmpl",
                 NewsTmplData{
-                        Common: GetCommonTmplData("News", Context.AccountID),
+                        Common: GetCommonTmplData("News", Context),
                         NewsList: news,
                         CurrentPage: page,
                         TotalPages: totalPages,
@@ -468,7 +970,7 @@ func RenderNews(Context *THttpRequestContext) {
 }
 
 func RenderNewsArchive(Context *THttpRequestContext, Data *NewsArchiveTmplData) {
-        ExecuteTemplate(Context.Writer, "news_archive.tmpl", Data)
+        ExecuteTemplate(Context, "news_archive.tmpl", Data)
 }
 
 func RenderAdminNews(Context *THttpRequestContext) {
@@ -499,9 +1001,9 @@ func RenderAdminNews(Context *THttpRequestContext) {
                 totalPages = 1
         }
 
-        ExecuteTemplate(Context.Writer, "admin_news.tmpl",
+        ExecuteTemplate(Context, "admin_news.tmpl",
                 AdminNewsTmplData{
-                        Common: GetCommonTmplData("Admin News", Context.AccountID),
+                        Common: GetCommonTmplData("Admin News", Context),
                         NewsList: news,
                         EditingNews: nil,
                         CurrentPage: page,
@@ -510,6 +1012,15 @@ func RenderAdminNews(Context *THttpRequestContext) {
                 })
 }
 
+func RenderAdminEvictions(Context *THttpRequestContext) {
+        ExecuteTemplate(Context, "admin_evictions.tmpl",
+                AdminEvictionsTmplData{
+                        Common:  GetCommonTmplData("Upcoming Evictions", Context),
+                        Houses:  GetUpcomingEvictions(),
+                        Advance: g_HouseRentReminderAdvance.String(),
+                })
+}
+
 func RenderAdminNewsEdit(Context *THttpRequestContext, newsID int) {
         pageStr := Context.Request.URL.Query().Get("page")
         page := 1
@@ -543,9 +1054,9 @@ func RenderAdminNewsEdit(Context *THttpRequestContext, newsID int) {
                 g_LogErr.Printf("Failed to get news by id: %v", err)
         }
 
-        ExecuteTemplate(Context.Writer, "admin_news.tmpl",
+        ExecuteTemplate(Context, "admin_news.tmpl",
                 AdminNewsTmplData{
-                        Common: GetCommonTmplData("Admin News", Context.AccountID),
+                        Common: GetCommonTmplData("Admin News", Context),
                         NewsList: news,
                         EditingNews: editingNews,
                         CurrentPage: page,
@@ -555,16 +1066,16 @@ func RenderAdminNewsEdit(Context *THttpRequestContext, newsID int) {
 }
 
 func RenderDownloadClient(Context *THttpRequestContext) {
-        ExecuteTemplate(Context.Writer, "download_client.tmpl",
+        ExecuteTemplate(Context, "download_client.tmpl",
                 GenericTmplData{
-                        Common: GetCommonTmplData("Download Client", Context.AccountID),
+                        Common: GetCommonTmplData("Download Client", Context),
                 })
 }
 
 func RenderHouses(Context *THttpRequestContext, Houses []THouse, SelectedTown string, SelectedType int, SelectedStatus int, Towns []string) {
-        ExecuteTemplate(Context.Writer, "houses.tmpl",
+        ExecuteTemplate(Context, "houses.tmpl",
                 HousesTmplData{
-                        Common: GetCommonTmplData("Houses", Context.AccountID),
+                        Common: GetCommonTmplData("Houses", Context),
                         Houses: Houses,
                         SelectedTown: SelectedTown,
                         SelectedType: SelectedType,
@@ -573,36 +1084,131 @@ func RenderHouses(Context *THttpRequestContext, Houses []THouse, SelectedTown st
                 })
 }
 
-func RenderHouseDetail(Context *THttpRequestContext, House *THouse, Auction *THouseAuction, CanBid bool, CurrentCharID int) {
-        ExecuteTemplate(Context.Writer, "house_detail.tmpl",
+func RenderHouseDetail(Context *THttpRequestContext, House *THouse, Auction *THouseAuction, Bids []THouseBid, CanBid bool, Characters []TAccountCharacter, IsOwner bool, Transfer *THouseTransfer) {
+        ExecuteTemplate(Context, "house_detail.tmpl",
                 HouseDetailTmplData{
-                        Common:        GetCommonTmplData("House", Context.AccountID),
+                        Common:        GetCommonTmplData("House", Context),
                         House:         House,
                         Auction:       Auction,
+                        Bids:          Bids,
                         CanBid:        CanBid,
-                        CurrentCharID: CurrentCharID,
+                        Characters:    Characters,
+                        IsOwner:       IsOwner,
+                        Transfer:      Transfer,
+                        HasMap:        HouseMapsEnabled(),
                 })
 }
 
 func RenderGuilds(Context *THttpRequestContext, Guilds []TGuild) {
-        ExecuteTemplate(Context.Writer, "guilds.tmpl",
+        ExecuteTemplate(Context, "guilds.tmpl",
                 GuildsTmplData{
-                        Common: GetCommonTmplData("Guilds", Context.AccountID),
+                        Common: GetCommonTmplData("Guilds", Context),
                         Guilds: Guilds,
                 })
 }
 
-func RenderGuildDetail(Context *THttpRequestContext, Guild *TGuild, Members []TGuildMember, Invites []TGuildInvite, IsLeader bool, IsViceLeader bool, LeaderCharID int, HasInvite bool) {
-        ExecuteTemplate(Context.Writer, "guild_detail.tmpl",
+func RenderGuildDetail(Context *THttpRequestContext, Guild *TGuild, Members []TGuildMember, Invites []TGuildInvite, IsLeader bool, IsViceLeader bool, LeaderCharID int, AccountInvites []TGuildInvite, Characters []TAccountCharacter, History []TGuildHistoryEntry, LogoUpdated int, Wars []TGuildWar, WarEnemies []TGuild, IsMember bool, BoardUnread int) {
+        ExecuteTemplate(Context, "guild_detail.tmpl",
                 GuildDetailTmplData{
-                        Common:       GetCommonTmplData("Guild", Context.AccountID),
-                        Guild:        Guild,
-                        Members:      Members,
-                        Invites:      Invites,
-                        IsLeader:     IsLeader,
-                        IsViceLeader: IsViceLeader,
-                        LeaderCharID: LeaderCharID,
-                        HasInvite:    HasInvite,
+                        Common:           GetCommonTmplData("Guild", Context),
+                        Guild:            Guild,
+                        Members:          Members,
+                        Invites:          Invites,
+                        IsLeader:         IsLeader,
+                        IsViceLeader:     IsViceLeader,
+                        LeaderCharID:     LeaderCharID,
+                        AccountInvites:   AccountInvites,
+                        Characters:       Characters,
+                        History:          History,
+                        LogoUpdated:      LogoUpdated,
+                        LogoMaxSize:      g_GuildLogoMaxSize / 1024,
+                        LogoMaxDimension: g_GuildLogoMaxDimension,
+                        Wars:             Wars,
+                        WarEnemies:       WarEnemies,
+                        IsMember:         IsMember,
+                        BoardUnread:      BoardUnread,
+                })
+}
+
+func RenderGuildEvents(Context *THttpRequestContext, Guild *TGuild, Month time.Time, Weeks [][]TGuildCalendarDay, Upcoming []TGuildEvent, CanManage bool) {
+        ExecuteTemplate(Context, "guild_events.tmpl",
+                GuildEventsTmplData{
+                        Common:         GetCommonTmplData("Guild Events", Context),
+                        Guild:          Guild,
+                        MonthName:      Month.Format("January 2006"),
+                        PrevMonth:      Month.AddDate(0, -1, 0).Format(GUILD_EVENT_MONTH_FORMAT),
+                        NextMonth:      Month.AddDate(0, 1, 0).Format(GUILD_EVENT_MONTH_FORMAT),
+                        Weeks:          Weeks,
+                        Upcoming:       Upcoming,
+                        CanManage:      CanManage,
+                        MinTime:        time.Now().In(Month.Location()).Format(GUILD_EVENT_TIME_FORMAT),
+                        TitleMax:       GUILD_EVENT_TITLE_MAX,
+                        DescriptionMax: GUILD_EVENT_DESCRIPTION_MAX,
+                        MaxCapacity:    GUILD_EVENT_MAX_CAPACITY,
+                })
+}
+
+func RenderGuildEvent(Context *THttpRequestContext, Guild *TGuild, Event *TGuildEvent, Signups []TGuildEventSignup, Characters []TAccountCharacter, CanManage bool) {
+        ExecuteTemplate(Context, "guild_event.tmpl",
+                GuildEventTmplData{
+                        Common:     GetCommonTmplData("Guild Event", Context),
+                        Guild:      Guild,
+                        Event:      Event,
+                        Signups:    Signups,
+                        Characters: Characters,
+                        CanManage:  CanManage,
+                        Started:    int64(Event.StartTime) <= time.Now().Unix(),
+                })
+}
+
+func RenderGuildBoard(Context *THttpRequestContext, Guild *TGuild, Threads []TGuildBoardThread, Characters []TAccountCharacter, CanModerate bool, Page int, TotalPages int) {
+        ExecuteTemplate(Context, "guild_board.tmpl",
+                GuildBoardTmplData{
+                        Common:      GetCommonTmplData("Guild Board", Context),
+                        Guild:       Guild,
+                        Threads:     Threads,
+                        Characters:  Characters,
+                        CanModerate: CanModerate,
+                        CurrentPage: Page,
+                        TotalPages:  TotalPages,
+                        TitleMax:    GUILD_BOARD_TITLE_MAX,
+                        TextMax:     GUILD_BOARD_TEXT_MAX,
+                })
+}
+
+func RenderGuildBoardThread(Context *THttpRequestContext, Guild *TGuild, Thread *TGuildBoardThread, Posts []TGuildBoardPost, Characters []TAccountCharacter, CanModerate bool, Page int, TotalPages int) {
+        ExecuteTemplate(Context, "guild_board_thread.tmpl",
+                GuildBoardThreadTmplData{
+                        Common:      GetCommonTmplData("Guild Board", Context),
+                        Guild:       Guild,
+                        Thread:      Thread,
+                        Posts:       Posts,
+                        Characters:  Characters,
+                        CanModerate: CanModerate,
+                        CurrentPage: Page,
+                        TotalPages:  TotalPages,
+                        TextMax:     GUILD_BOARD_TEXT_MAX,
+                })
+}
+
+func RenderGuildWars(Context *THttpRequestContext, ActiveWars []TGuildWar, EndedWars []TGuildWar) {
+        ExecuteTemplate(Context, "wars.tmpl",
+                GuildWarsTmplData{
+                        Common:     GetCommonTmplData("Guild Wars", Context),
+                        ActiveWars: ActiveWars,
+                        EndedWars:  EndedWars,
+                })
+}
+
+func RenderGuildManage(Context *THttpRequestContext, Guild *TGuild, Ranks []TGuildRank, Members []TGuildMember, ActorRank int) {
+        ExecuteTemplate(Context, "guild_manage.tmpl",
+                GuildManageTmplData{
+                        Common:    GetCommonTmplData("Manage Guild", Context),
+                        Guild:     Guild,
+                        Ranks:     Ranks,
+                        Members:   Members,
+                        IsLeader:  ActorRank == GUILD_RANK_LEADER,
+                        ActorRank: ActorRank,
                 })
 }
 
@@ -615,9 +1221,9 @@ func RenderGuildCreate(Context *THttpRequestContext, Account *TAccountSummary) {
         CanCreate := Account != nil && Account.PremiumDays > 0 && len(Characters) > 0
         HasCharacters := len(Characters) > 0
 
-        ExecuteTemplate(Context.Writer, "guild_create.tmpl",
+        ExecuteTemplate(Context, "guild_create.tmpl",
                 GuildCreateTmplData{
-                        Common:         GetCommonTmplData("Found Guild", Context.AccountID),
+                        Common:         GetCommonTmplData("Found Guild", Context),
                         CanCreateGuild: CanCreate,
                         HasCharacters:  HasCharacters,
                         Characters:     Characters,
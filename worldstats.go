@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	PLAYER_CHART_WIDTH   = 600
+	PLAYER_CHART_HEIGHT  = 150
+	PLAYER_CHART_BUCKETS = 200
+)
+
+type (
+	TPlayerSample struct {
+		Timestamp  int
+		NumPlayers int
+	}
+
+	TPlayerPeak struct {
+		Label      string
+		NumPlayers int
+		Timestamp  int
+	}
+
+	TPlayerChart struct {
+		Width      int
+		Height     int
+		Points     string
+		MaxPlayers int
+		Start      int
+		End        int
+	}
+
+	TWorldPlayerStats struct {
+		World     TWorld
+		DayPeak   int
+		WeekPeak  int
+		MonthPeak int
+		Chart     TPlayerChart
+	}
+)
+
+func InitWorldStats() bool {
+	g_Log.Printf("WorldSampleInterval: %v", g_WorldSampleInterval)
+	g_Log.Printf("WorldSampleDownsampleAge: %v", g_WorldSampleDownsampleAge)
+	g_Log.Printf("WorldSampleRetention: %v", g_WorldSampleRetention)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS WorldPlayerSamples (
+			World TEXT NOT NULL COLLATE NOCASE,
+			Timestamp INTEGER NOT NULL,
+			NumPlayers INTEGER NOT NULL,
+			PRIMARY KEY (World, Timestamp)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create world player samples table: %v", Err)
+		return false
+	}
+
+	StartJob("world player sampler", g_WorldSampleInterval, SampleWorldPlayers)
+	StartJob("world player compaction", time.Hour, CompactWorldPlayerSamples)
+	return true
+}
+
+func ExitWorldStats() {
+	// no-op
+}
+
+func SampleWorldPlayers() {
+	// NOTE: The world cache may be up to `WorldRefreshInterval` old which is
+	// usually longer than the sample interval, so always ask for fresh data.
+	Worlds := RefreshWorlds()
+	if len(Worlds) == 0 {
+		return
+	}
+
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return
+	}
+	defer Tx.Rollback()
+
+	Now := time.Now().Unix()
+	for _, World := range Worlds {
+		_, Err := Tx.Exec(`
+			INSERT OR REPLACE INTO WorldPlayerSamples (World, Timestamp, NumPlayers)
+			VALUES (?, ?, ?)
+		`, World.Name, Now, World.NumPlayers)
+		if Err != nil {
+			g_LogErr.Printf("Failed to insert world player sample: %v", Err)
+			return
+		}
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit world player samples: %v", Err)
+	}
+}
+
+func CompactWorldPlayerSamples() {
+	Now := time.Now()
+
+	// NOTE: Samples older than `WorldSampleDownsampleAge` are merged into one
+	// sample per hour, stored at the start of the hour, that keeps the highest
+	// player count so peaks aren't lost.
+	if g_WorldSampleDownsampleAge > 0 {
+		Cutoff := Now.Add(-g_WorldSampleDownsampleAge).Unix()
+		Cutoff -= Cutoff % 3600
+
+		Tx, Err := g_NewsDb.Begin()
+		if Err != nil {
+			g_LogErr.Printf("Failed to begin transaction: %v", Err)
+			return
+		}
+		defer Tx.Rollback()
+
+		_, Err = Tx.Exec(`
+			INSERT INTO WorldPlayerSamples (World, Timestamp, NumPlayers)
+			SELECT World, (Timestamp / 3600) * 3600 AS Hour, MAX(NumPlayers)
+			FROM WorldPlayerSamples
+			WHERE Timestamp < ?
+			GROUP BY World, Hour
+			ON CONFLICT (World, Timestamp) DO UPDATE SET
+				NumPlayers = MAX(NumPlayers, excluded.NumPlayers)
+		`, Cutoff)
+		if Err != nil {
+			g_LogErr.Printf("Failed to downsample world player samples: %v", Err)
+			return
+		}
+
+		_, Err = Tx.Exec(`
+			DELETE FROM WorldPlayerSamples
+			WHERE Timestamp < ? AND Timestamp % 3600 != 0
+		`, Cutoff)
+		if Err != nil {
+			g_LogErr.Printf("Failed to downsample world player samples: %v", Err)
+			return
+		}
+
+		if Err := Tx.Commit(); Err != nil {
+			g_LogErr.Printf("Failed to commit world player samples: %v", Err)
+			return
+		}
+	}
+
+	if g_WorldSampleRetention > 0 {
+		_, Err := g_NewsDb.Exec(`
+			DELETE FROM WorldPlayerSamples WHERE Timestamp < ?
+		`, Now.Add(-g_WorldSampleRetention).Unix())
+		if Err != nil {
+			g_LogErr.Printf("Failed to delete old world player samples: %v", Err)
+		}
+	}
+}
+
+func GetWorldPlayerSamples(World string, Since int) []TPlayerSample {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	Rows, Err := g_NewsDb.Query(`
+		SELECT Timestamp, NumPlayers FROM WorldPlayerSamples
+		WHERE World = ? AND Timestamp >= ?
+		ORDER BY Timestamp ASC
+	`, World, Since)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query world player samples: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Samples []TPlayerSample
+	for Rows.Next() {
+		var Sample TPlayerSample
+		if Err := Rows.Scan(&Sample.Timestamp, &Sample.NumPlayers); Err != nil {
+			g_LogErr.Printf("Failed to scan world player sample: %v", Err)
+			continue
+		}
+		Samples = append(Samples, Sample)
+	}
+	return Samples
+}
+
+func PlayerPeakPeriodStart(Time time.Time, Period string) time.Time {
+	Year, Month, Day := Time.Date()
+	switch Period {
+	case "week":
+		// NOTE: Weeks start on monday.
+		Offset := (int(Time.Weekday()) + 6) % 7
+		return time.Date(Year, Month, Day-Offset, 0, 0, 0, 0, Time.Location())
+	case "month":
+		return time.Date(Year, Month, 1, 0, 0, 0, 0, Time.Location())
+	default:
+		return time.Date(Year, Month, Day, 0, 0, 0, 0, Time.Location())
+	}
+}
+
+// GetPlayerPeaks groups samples by day, week or month in the given location
+// and returns the highest player count of the last `Count` periods, newest
+// first. Periods without samples are skipped.
+func GetPlayerPeaks(Samples []TPlayerSample, Location *time.Location, Period string, Count int) []TPlayerPeak {
+	if Location == nil {
+		Location = time.Local
+	}
+
+	var Peaks []TPlayerPeak
+	for Index := len(Samples) - 1; Index >= 0; Index -= 1 {
+		Sample := Samples[Index]
+		Start := PlayerPeakPeriodStart(time.Unix(int64(Sample.Timestamp), 0).In(Location), Period)
+
+		var Label string
+		switch Period {
+		case "week":
+			Label = "Week of " + Start.Format("Jan 02 2006")
+		case "month":
+			Label = Start.Format("January 2006")
+		default:
+			Label = Start.Format("Jan 02 2006")
+		}
+
+		if len(Peaks) == 0 || Peaks[len(Peaks)-1].Label != Label {
+			if len(Peaks) >= Count {
+				break
+			}
+			Peaks = append(Peaks, TPlayerPeak{Label: Label})
+		}
+
+		Peak := &Peaks[len(Peaks)-1]
+		if Sample.NumPlayers > Peak.NumPlayers || Peak.Timestamp == 0 {
+			Peak.NumPlayers = Sample.NumPlayers
+			Peak.Timestamp = Sample.Timestamp
+		}
+	}
+	return Peaks
+}
+
+func GetPlayerPeakSince(Samples []TPlayerSample, Since int) int {
+	Peak := 0
+	for _, Sample := range Samples {
+		if Sample.Timestamp >= Since && Sample.NumPlayers > Peak {
+			Peak = Sample.NumPlayers
+		}
+	}
+	return Peak
+}
+
+// BuildPlayerChart turns the samples between `Start` and `End` into the points
+// of an SVG polyline. Samples are grouped into a fixed number of buckets that
+// keep their highest player count, so the amount of points doesn't depend on
+// the sample interval or the time range.
+func BuildPlayerChart(Samples []TPlayerSample, Start int, End int) TPlayerChart {
+	Chart := TPlayerChart{
+		Width:  PLAYER_CHART_WIDTH,
+		Height: PLAYER_CHART_HEIGHT,
+		Start:  Start,
+		End:    End,
+	}
+
+	if End <= Start {
+		return Chart
+	}
+
+	Buckets := slices.Repeat([]int{-1}, PLAYER_CHART_BUCKETS)
+	for _, Sample := range Samples {
+		if Sample.Timestamp < Start || Sample.Timestamp > End {
+			continue
+		}
+
+		Bucket := (Sample.Timestamp - Start) * (PLAYER_CHART_BUCKETS - 1) / (End - Start)
+		Buckets[Bucket] = max(Buckets[Bucket], Sample.NumPlayers)
+		Chart.MaxPlayers = max(Chart.MaxPlayers, Sample.NumPlayers)
+	}
+
+	Scale := max(Chart.MaxPlayers, 1)
+	var Points strings.Builder
+	for Index, NumPlayers := range Buckets {
+		if NumPlayers < 0 {
+			continue
+		}
+
+		X := Index * Chart.Width / (PLAYER_CHART_BUCKETS - 1)
+		Y := Chart.Height - NumPlayers*(Chart.Height-10)/Scale
+		if Points.Len() > 0 {
+			Points.WriteByte(' ')
+		}
+		fmt.Fprintf(&Points, "%d,%d", X, Y)
+	}
+	Chart.Points = Points.String()
+	return Chart
+}
+
+func PlayerChartRangeStart(Range string, Now time.Time) time.Time {
+	switch Range {
+	case "week":
+		return Now.AddDate(0, 0, -7)
+	case "month":
+		return Now.AddDate(0, -1, 0)
+	default:
+		return Now.AddDate(0, 0, -1)
+	}
+}
+
+func GetWorldPlayerOverview() []TWorldPlayerStats {
+	Worlds := GetWorlds()
+	Now := time.Now()
+	DaySince := int(Now.AddDate(0, 0, -1).Unix())
+	WeekSince := int(Now.AddDate(0, 0, -7).Unix())
+	MonthSince := int(Now.AddDate(0, -1, 0).Unix())
+
+	Overview := make([]TWorldPlayerStats, 0, len(Worlds))
+	for _, World := range Worlds {
+		Samples := GetWorldPlayerSamples(World.Name, MonthSince)
+		Overview = append(Overview, TWorldPlayerStats{
+			World:     World,
+			DayPeak:   GetPlayerPeakSince(Samples, DaySince),
+			WeekPeak:  GetPlayerPeakSince(Samples, WeekSince),
+			MonthPeak: GetPlayerPeakSince(Samples, MonthSince),
+			Chart:     BuildPlayerChart(Samples, WeekSince, int(Now.Unix())),
+		})
+	}
+	return Overview
+}
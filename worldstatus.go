@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	WORLD_STATUS_WINDOW        = 30 * 24 * time.Hour
+	WORLD_STATUS_MAX_DOWNTIMES = 50
+
+	// NOTE: Downtimes that start close to the usual server save time and are
+	// short enough are considered scheduled.
+	SERVER_SAVE_TOLERANCE    = 30 * time.Minute
+	SERVER_SAVE_MAX_DOWNTIME = time.Hour
+)
+
+type (
+	TWorldStatusEvent struct {
+		Timestamp int
+		Startup   bool
+	}
+
+	TWorldDowntime struct {
+		Start     int
+		End       int
+		Duration  string
+		Scheduled bool
+	}
+
+	TWorldStatus struct {
+		World          *TWorld
+		UptimePercent  string
+		AverageSession string
+		NumSessions    int
+		NextServerSave int
+		Downtimes      []TWorldDowntime
+	}
+)
+
+func InitWorldStatus() bool {
+	g_Log.Printf("WorldStatusInterval: %v", g_WorldStatusInterval)
+
+	if g_NewsDb == nil {
+		g_LogErr.Print("Database not initialized")
+		return false
+	}
+
+	_, Err := g_NewsDb.Exec(`
+		CREATE TABLE IF NOT EXISTS WorldStatusEvents (
+			World TEXT NOT NULL COLLATE NOCASE,
+			Timestamp INTEGER NOT NULL,
+			Startup INTEGER NOT NULL,
+			PRIMARY KEY (World, Timestamp, Startup)
+		)
+	`)
+	if Err != nil {
+		g_LogErr.Printf("Failed to create world status events table: %v", Err)
+		return false
+	}
+
+	StartJob("world status recorder", g_WorldStatusInterval, UpdateWorldStatus)
+	return true
+}
+
+func ExitWorldStatus() {
+	// no-op
+}
+
+func UpdateWorldStatus() {
+	// NOTE: Same as the player sampler, the world cache may be too old to
+	// notice short restarts so always ask for fresh data.
+	Worlds := RefreshWorlds()
+	if len(Worlds) == 0 {
+		return
+	}
+
+	RecordWorldStatus(Worlds)
+}
+
+// RecordWorldStatus stores the last startup and shutdown reported for each
+// world. They're real timestamps from the game server so recording the same
+// one twice is harmless, but a world that restarts more than once between two
+// samples will only have its last transition recorded.
+func RecordWorldStatus(Worlds []TWorld) {
+	Tx, Err := g_NewsDb.Begin()
+	if Err != nil {
+		g_LogErr.Printf("Failed to begin transaction: %v", Err)
+		return
+	}
+	defer Tx.Rollback()
+
+	for _, World := range Worlds {
+		for _, Event := range []TWorldStatusEvent{
+			{Timestamp: World.LastStartup, Startup: true},
+			{Timestamp: World.LastShutdown, Startup: false},
+		} {
+			if Event.Timestamp <= 0 {
+				continue
+			}
+
+			_, Err := Tx.Exec(`
+				INSERT OR IGNORE INTO WorldStatusEvents (World, Timestamp, Startup)
+				VALUES (?, ?, ?)
+			`, World.Name, Event.Timestamp, Event.Startup)
+			if Err != nil {
+				g_LogErr.Printf("Failed to insert world status event: %v", Err)
+				return
+			}
+		}
+	}
+
+	if Err := Tx.Commit(); Err != nil {
+		g_LogErr.Printf("Failed to commit world status events: %v", Err)
+	}
+}
+
+func GetWorldStatusEvents(World string, Since int) []TWorldStatusEvent {
+	if g_NewsDb == nil {
+		return nil
+	}
+
+	// NOTE: The last event before `Since` is also returned so the state at
+	// the start of the window is known.
+	Rows, Err := g_NewsDb.Query(`
+		SELECT Timestamp, Startup FROM WorldStatusEvents
+		WHERE World = ? AND Timestamp >= COALESCE((
+			SELECT MAX(Timestamp) FROM WorldStatusEvents
+			WHERE World = ? AND Timestamp < ?), ?)
+		ORDER BY Timestamp ASC, Startup ASC
+	`, World, World, Since, Since)
+	if Err != nil {
+		g_LogErr.Printf("Failed to query world status events: %v", Err)
+		return nil
+	}
+	defer Rows.Close()
+
+	var Events []TWorldStatusEvent
+	for Rows.Next() {
+		var Event TWorldStatusEvent
+		if Err := Rows.Scan(&Event.Timestamp, &Event.Startup); Err != nil {
+			g_LogErr.Printf("Failed to scan world status event: %v", Err)
+			continue
+		}
+		Events = append(Events, Event)
+	}
+	return Events
+}
+
+// GetServerSaveTime guesses the time of day of the daily server save as the
+// most common time of day, in 15 minute steps, at which the world went down.
+// It returns the number of seconds since midnight or -1 if there isn't enough
+// history.
+func GetServerSaveTime(Events []TWorldStatusEvent, Location *time.Location) int {
+	const Step = 15 * 60
+	Counts := make(map[int]int)
+	Best, BestCount := -1, 0
+	for _, Event := range Events {
+		if Event.Startup {
+			continue
+		}
+
+		Time := time.Unix(int64(Event.Timestamp), 0).In(Location)
+		Seconds := Time.Hour()*3600 + Time.Minute()*60 + Time.Second()
+		Seconds = (Seconds + Step/2) / Step * Step % 86400
+		Counts[Seconds] += 1
+		if Counts[Seconds] > BestCount {
+			Best, BestCount = Seconds, Counts[Seconds]
+		}
+	}
+
+	if BestCount < 2 {
+		return -1
+	}
+	return Best
+}
+
+func IsServerSaveDowntime(Start int, End int, ServerSaveTime int, Location *time.Location) bool {
+	if ServerSaveTime < 0 || End <= 0 || time.Duration(End-Start)*time.Second > SERVER_SAVE_MAX_DOWNTIME {
+		return false
+	}
+
+	Time := time.Unix(int64(Start), 0).In(Location)
+	Seconds := Time.Hour()*3600 + Time.Minute()*60 + Time.Second()
+	Distance := Seconds - ServerSaveTime
+	if Distance < 0 {
+		Distance = -Distance
+	}
+	Distance = min(Distance, 86400-Distance)
+	return time.Duration(Distance)*time.Second <= SERVER_SAVE_TOLERANCE
+}
+
+func GetWorldStatus(World *TWorld) TWorldStatus {
+	Status := TWorldStatus{World: World, NextServerSave: -1}
+	if World == nil {
+		return Status
+	}
+
+	// NOTE: The current state comes from the world list which may be ahead of
+	// the recorded events.
+	Now := int(time.Now().Unix())
+	WindowStart := Now - int(WORLD_STATUS_WINDOW/time.Second)
+	Events := GetWorldStatusEvents(World.Name, WindowStart)
+	if World.LastStartup > 0 {
+		Events = append(Events, TWorldStatusEvent{Timestamp: World.LastStartup, Startup: true})
+	}
+	if World.LastShutdown > 0 {
+		Events = append(Events, TWorldStatusEvent{Timestamp: World.LastShutdown, Startup: false})
+	}
+	slices.SortFunc(Events, func(A, B TWorldStatusEvent) int {
+		if A.Timestamp != B.Timestamp {
+			return A.Timestamp - B.Timestamp
+		}
+		if A.Startup == B.Startup {
+			return 0
+		} else if B.Startup {
+			return -1
+		}
+		return 1
+	})
+	Events = slices.Compact(Events)
+
+	ServerSaveTime := GetServerSaveTime(Events, time.Local)
+	Online := false
+	Since, SessionStart := WindowStart, 0
+	Uptime, SessionTime := 0, 0
+	for _, Event := range Events {
+		Timestamp := max(Event.Timestamp, WindowStart)
+		if Event.Startup && !Online {
+			if Event.Timestamp >= WindowStart && len(Status.Downtimes) > 0 {
+				Downtime := &Status.Downtimes[len(Status.Downtimes)-1]
+				Downtime.End = Event.Timestamp
+			}
+			Online, Since, SessionStart = true, Timestamp, Event.Timestamp
+		} else if !Event.Startup && Online {
+			Uptime += Timestamp - Since
+			if SessionStart >= WindowStart {
+				SessionTime += Event.Timestamp - SessionStart
+				Status.NumSessions += 1
+			}
+			if Event.Timestamp >= WindowStart {
+				Status.Downtimes = append(Status.Downtimes, TWorldDowntime{Start: Event.Timestamp})
+			}
+			Online, Since = false, Timestamp
+		}
+	}
+
+	if Online {
+		Uptime += Now - Since
+	}
+
+	// NOTE: Without history before the first event, the uptime can only be
+	// measured from there.
+	if len(Events) > 0 {
+		TrackingStart := max(Events[0].Timestamp, WindowStart)
+		if Now > TrackingStart {
+			Status.UptimePercent = fmt.Sprintf("%.2f", float64(Uptime)*100/float64(Now-TrackingStart))
+		}
+	}
+
+	if Status.NumSessions > 0 {
+		Average := time.Duration(SessionTime/Status.NumSessions) * time.Second
+		Status.AverageSession = Average.String()
+	}
+
+	for Index := range Status.Downtimes {
+		Downtime := &Status.Downtimes[Index]
+		End := Downtime.End
+		if End <= 0 {
+			End = Now
+		}
+		Downtime.Duration = (time.Duration(End-Downtime.Start) * time.Second).String()
+		Downtime.Scheduled = IsServerSaveDowntime(Downtime.Start, Downtime.End, ServerSaveTime, time.Local)
+	}
+
+	slices.Reverse(Status.Downtimes)
+	if len(Status.Downtimes) > WORLD_STATUS_MAX_DOWNTIMES {
+		Status.Downtimes = Status.Downtimes[:WORLD_STATUS_MAX_DOWNTIMES]
+	}
+
+	if ServerSaveTime >= 0 {
+		Year, Month, Day := time.Now().Date()
+		Next := time.Date(Year, Month, Day, 0, 0, ServerSaveTime, 0, time.Local)
+		if Next.Before(time.Now()) {
+			Next = Next.AddDate(0, 0, 1)
+		}
+		Status.NextServerSave = int(Next.Unix())
+	}
+
+	return Status
+}